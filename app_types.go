@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"html/template"
 	"log/slog"
@@ -13,46 +14,128 @@ import (
 var webAssets embed.FS
 
 type Config struct {
-	ListenAddr            string           `json:"listen_addr"`
-	SessionCookie         string           `json:"session_cookie"`
-	AuthKeySHA256         string           `json:"auth_key_sha256"`
-	CurrentVersion        string           `json:"current_version"`
-	DefaultProjectID      string           `json:"default_project_id"`
-	Projects              []ManagedProject `json:"projects"`
-	UploadDir             string           `json:"upload_dir"`
-	WorkDir               string           `json:"work_dir"`
-	BackupDir             string           `json:"backup_dir"`
-	DeploymentsFile       string           `json:"deployments_file"`
-	LogFile               string           `json:"log_file"`
-	NSSMExePath           string           `json:"nssm_exe_path"`
-	SelfUpdateServiceName string           `json:"self_update_service_name"`
-	NotifyEmail           string           `json:"notify_email"`
-	NotifyEmailAuthCode   string           `json:"notify_email_auth_code"`
-	ServiceName           string           `json:"service_name"`
-	TargetDir             string           `json:"target_dir"`
-	ReplaceMode           string           `json:"replace_mode"`
-	BackupIgnore          []string         `json:"backup_ignore"`
-	ReplaceIgnore         []string         `json:"replace_ignore"`
-	MaxUploadMB           int64            `json:"max_upload_mb"`
+	ListenAddr            string            `json:"listen_addr"`
+	SessionCookie         string            `json:"session_cookie"`
+	AuthKeySHA256         string            `json:"auth_key_sha256"`
+	UsersFile             string            `json:"users_file"`
+	TokensFile            string            `json:"tokens_file"`
+	TOTPRequired          bool              `json:"totp_required"`
+	SessionsFile          string            `json:"sessions_file"`
+	AuditFile             string            `json:"audit_file"`
+	JournalDir            string            `json:"journal_dir"`
+	ReleasesFile          string            `json:"releases_file"`
+	SessionIdleMinutes    int               `json:"session_idle_minutes"`
+	TrustedProxies        []string          `json:"trusted_proxies"`
+	LoginMaxFailures      int               `json:"login_max_failures"`
+	LoginLockoutMinutes   int               `json:"login_lockout_minutes"`
+	LoginMaxLockoutMins   int               `json:"login_max_lockout_minutes"`
+	LoginGlobalMaxFails   int               `json:"login_global_max_failures"`
+	TLSEnabled            bool              `json:"tls_enabled"`
+	TLSCertFile           string            `json:"tls_cert_file"`
+	TLSKeyFile            string            `json:"tls_key_file"`
+	TLSAutoSelfSigned     bool              `json:"tls_auto_self_signed"`
+	TLSRedirectAddr       string            `json:"tls_redirect_addr"`
+	OIDCEnabled           bool              `json:"oidc_enabled"`
+	OIDCDisplayName       string            `json:"oidc_display_name"`
+	OIDCIssuer            string            `json:"oidc_issuer"`
+	OIDCClientID          string            `json:"oidc_client_id"`
+	OIDCClientSecret      string            `json:"oidc_client_secret"`
+	OIDCScopes            []string          `json:"oidc_scopes"`
+	OIDCRedirectURL       string            `json:"oidc_redirect_url"`
+	OIDCCAFile            string            `json:"oidc_ca_file"`
+	OIDCUsernameClaim     string            `json:"oidc_username_claim"`
+	OIDCRoleClaim         string            `json:"oidc_role_claim"`
+	OIDCRoleMapping       map[string]string `json:"oidc_role_mapping"`
+	OIDCDefaultRole       string            `json:"oidc_default_role"`
+	CurrentVersion        string            `json:"current_version"`
+	DefaultProjectID      string            `json:"default_project_id"`
+	Projects              []ManagedProject  `json:"projects"`
+	UploadDir             string            `json:"upload_dir"`
+	WorkDir               string            `json:"work_dir"`
+	BackupDir             string            `json:"backup_dir"`
+	DeploymentsFile       string            `json:"deployments_file"`
+	LogFile               string            `json:"log_file"`
+	NSSMExePath           string            `json:"nssm_exe_path"`
+	SelfUpdateServiceName string            `json:"self_update_service_name"`
+	NotifyEmail           string            `json:"notify_email"`
+	NotifyEmailAuthCode   string            `json:"notify_email_auth_code"`
+	SecretKeyFile         string            `json:"secret_key_file"`
+	ServiceName           string            `json:"service_name"`
+	TargetDir             string            `json:"target_dir"`
+	ReplaceMode           string            `json:"replace_mode"`
+	BackupIgnore          []string          `json:"backup_ignore"`
+	ReplaceIgnore         []string          `json:"replace_ignore"`
+	MaxUploadMB           int64             `json:"max_upload_mb"`
 }
 
 type ManagedProject struct {
-	ID                 string   `json:"id"`
-	Name               string   `json:"name"`
-	ServiceName        string   `json:"service_name"`
-	TargetDir          string   `json:"target_dir"`
-	CurrentVersion     string   `json:"current_version"`
-	DefaultReplaceMode string   `json:"default_replace_mode"`
-	AllowInitialDeploy bool     `json:"allow_initial_deploy"`
-	ServiceInstallMode string   `json:"service_install_mode"`
-	ServiceExePath     string   `json:"service_exe_path"`
-	ServiceArgs        []string `json:"service_args"`
-	ServiceDisplayName string   `json:"service_display_name"`
-	ServiceDescription string   `json:"service_description"`
-	ServiceStartType   string   `json:"service_start_type"`
-	BackupIgnore       []string `json:"backup_ignore"`
-	ReplaceIgnore      []string `json:"replace_ignore"`
-	MaxUploadMB        int64    `json:"max_upload_mb"`
+	ID                     string              `json:"id"`
+	Name                   string              `json:"name"`
+	ServiceName            string              `json:"service_name"`
+	TargetDir              string              `json:"target_dir"`
+	CurrentVersion         string              `json:"current_version"`
+	DefaultReplaceMode     string              `json:"default_replace_mode"`
+	AllowInitialDeploy     bool                `json:"allow_initial_deploy"`
+	ServiceInstallMode     string              `json:"service_install_mode"`
+	ServiceExePath         string              `json:"service_exe_path"`
+	ServiceArgs            []string            `json:"service_args"`
+	ServiceDisplayName     string              `json:"service_display_name"`
+	ServiceDescription     string              `json:"service_description"`
+	ServiceStartType       string              `json:"service_start_type"`
+	BackupIgnore           []string            `json:"backup_ignore"`
+	ReplaceIgnore          []string            `json:"replace_ignore"`
+	MaxUploadMB            int64               `json:"max_upload_mb"`
+	RequireSignature       bool                `json:"require_signature"`
+	RequireApproval        bool                `json:"require_approval"`
+	AutoRollbackOnFailure  bool                `json:"auto_rollback_on_failure"`
+	AutoRecoverInterrupted bool                `json:"auto_recover_interrupted"`
+	QueueSupersede         bool                `json:"queue_supersede"`
+	DeployStrategy         string              `json:"deploy_strategy,omitempty"`
+	SigningKeys            []PackageSigningKey `json:"signing_keys"`
+	Hooks                  []ProjectHook       `json:"hooks"`
+	HealthCheck            *ProjectHealthCheck `json:"health_check,omitempty"`
+	Instances              []ProjectInstance   `json:"instances,omitempty"`
+	RollingBatchSize       int                 `json:"rolling_batch_size,omitempty"`
+	RollingPauseSec        int                 `json:"rolling_pause_sec,omitempty"`
+}
+
+// ProjectInstance 是同一程序在本机运行的一份副本，使用独立的目录与服务。
+// 程序配置了实例时，部署包按批滚动部署到各实例；health_check 为空时使用程序的健康检查。
+type ProjectInstance struct {
+	ID          string              `json:"id"`
+	TargetDir   string              `json:"target_dir"`
+	ServiceName string              `json:"service_name,omitempty"`
+	HealthCheck *ProjectHealthCheck `json:"health_check,omitempty"`
+}
+
+// ProjectHealthCheck 是服务启动后的健康检查；未通过时自动恢复本次部署前的备份。
+// 时间与次数字段为 0 时使用默认值。
+type ProjectHealthCheck struct {
+	Type            string `json:"type"`
+	URL             string `json:"url,omitempty"`
+	ExpectStatus    int    `json:"expect_status,omitempty"`
+	ExpectBody      string `json:"expect_body,omitempty"`
+	Address         string `json:"address,omitempty"`
+	Command         string `json:"command,omitempty"`
+	InitialDelaySec int    `json:"initial_delay_sec,omitempty"`
+	IntervalSec     int    `json:"interval_sec,omitempty"`
+	TimeoutSec      int    `json:"timeout_sec,omitempty"`
+	Retries         int    `json:"retries,omitempty"`
+	DeadlineSec     int    `json:"deadline_sec,omitempty"`
+}
+
+// ProjectHook 是部署流程中指定阶段执行的命令，通过系统 shell（Windows 为 cmd.exe）运行。
+type ProjectHook struct {
+	Stage      string `json:"stage"`
+	Command    string `json:"command"`
+	WorkDir    string `json:"work_dir,omitempty"`
+	TimeoutSec int    `json:"timeout_sec,omitempty"`
+}
+
+// PackageSigningKey 是程序信任的部署包签名公钥（ed25519，base64）。
+type PackageSigningKey struct {
+	ID        string `json:"id"`
+	PublicKey string `json:"public_key"`
 }
 
 type ChangedFile struct {
@@ -69,13 +152,23 @@ type Deployment struct {
 	ProjectID               string        `json:"project_id,omitempty"`
 	ProjectName             string        `json:"project_name,omitempty"`
 	ReplaceMode             string        `json:"replace_mode,omitempty"`
+	DeployStrategy          string        `json:"deploy_strategy,omitempty"`
 	ReplaceIgnore           []string      `json:"replace_ignore,omitempty"`
 	BackupIgnore            []string      `json:"backup_ignore,omitempty"`
 	Status                  string        `json:"status"`
 	Note                    string        `json:"note"`
 	LoginIP                 string        `json:"login_ip"`
+	Operator                string        `json:"operator,omitempty"`
+	TokenID                 string        `json:"token_id,omitempty"`
+	TokenName               string        `json:"token_name,omitempty"`
 	CreatedAt               time.Time     `json:"created_at"`
 	ScheduledAt             *time.Time    `json:"scheduled_at,omitempty"`
+	QueuePosition           int           `json:"queue_position,omitempty"`
+	SupersededBy            string        `json:"superseded_by,omitempty"`
+	ReleaseID               string        `json:"release_id,omitempty"`
+	ParentID                string        `json:"parent_id,omitempty"`
+	InstanceID              string        `json:"instance_id,omitempty"`
+	InstanceDeployments     []string      `json:"instance_deployments,omitempty"`
 	StartedAt               time.Time     `json:"started_at"`
 	FinishedAt              *time.Time    `json:"finished_at,omitempty"`
 	DurationMs              int64         `json:"duration_ms"`
@@ -95,6 +188,24 @@ type Deployment struct {
 	ServiceStartType        string        `json:"service_start_type,omitempty"`
 	ServiceCreated          bool          `json:"service_created,omitempty"`
 	ClearTargetBeforeDeploy bool          `json:"clear_target_before_deploy,omitempty"`
+	PackageSHA256           string        `json:"package_sha256,omitempty"`
+	SignatureStatus         string        `json:"signature_status,omitempty"`
+	SignatureMode           string        `json:"signature_mode,omitempty"`
+	SignerKeyID             string        `json:"signer_key_id,omitempty"`
+	BackupSHA256            string        `json:"backup_sha256,omitempty"`
+	PreviousDir             string        `json:"previous_dir,omitempty"`
+	Approvals               []Approval    `json:"approvals,omitempty"`
+	HealthStatus            string        `json:"health_status,omitempty"`
+	AutoRestore             string        `json:"auto_restore,omitempty"`
+	AutoRestoreError        string        `json:"auto_restore_error,omitempty"`
+}
+
+type Approval struct {
+	Action  string    `json:"action"`
+	User    string    `json:"user"`
+	IP      string    `json:"ip"`
+	Comment string    `json:"comment,omitempty"`
+	Time    time.Time `json:"time"`
 }
 
 const (
@@ -108,8 +219,68 @@ const (
 	ServiceStartTypeAutomatic = "automatic"
 	ServiceStartTypeManual    = "manual"
 	ServiceStartTypeDisabled  = "disabled"
+	RoleViewer                = "viewer"
+	RoleOperator              = "operator"
+	RoleAdmin                 = "admin"
 )
 
+type UserAccount struct {
+	Username     string    `json:"username"`
+	Role         string    `json:"role"`
+	PasswordHash string    `json:"password_hash"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	TOTPEnabled       bool     `json:"totp_enabled,omitempty"`
+	TOTPSecret        string   `json:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes     []string `json:"recovery_codes,omitempty"`
+
+	Grants []ProjectGrant `json:"grants,omitempty"`
+
+	// OIDCSubject 为单点登录用户在身份提供方的 sub，非空表示该账号由 OIDC 登录自动创建。
+	OIDCSubject string `json:"oidc_subject,omitempty"`
+}
+
+// ProjectGrant 限定用户可操作的程序与动作；用户没有任何授权时按角色权限访问全部程序。
+type ProjectGrant struct {
+	Project string   `json:"project"`
+	Actions []string `json:"actions"`
+}
+
+type APIToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	TokenHash  string     `json:"token_hash"`
+	Projects   []string   `json:"projects"`
+	Actions    []string   `json:"actions"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type AuditChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type AuditEntry struct {
+	ID      string        `json:"id"`
+	Time    time.Time     `json:"time"`
+	Actor   string        `json:"actor"`
+	IP      string        `json:"ip"`
+	Action  string        `json:"action"`
+	Target  string        `json:"target,omitempty"`
+	Changes []AuditChange `json:"changes,omitempty"`
+}
+
 type ServiceInstallConfig struct {
 	Name           string
 	InstallMode    string
@@ -141,7 +312,15 @@ type App struct {
 	logger      *slog.Logger
 	templates   *template.Template
 	store       *deploymentStore
+	releases    *releaseStore
 	sessions    *sessionManager
+	users       *userStore
+	tokens      *apiTokenStore
+	audit       *auditLog
+	challenges  *loginChallengeStore
+	oidc        *oidcProvider
+	loginGuard  *loginGuard
+	tls         *certReloader
 	events      *eventHub
 	static      http.Handler
 	taskMu      sync.Mutex
@@ -149,4 +328,8 @@ type App struct {
 	projectTask map[string]struct{}
 	schedMu     sync.Mutex
 	schedCancel map[string]func()
+	queueMu     sync.Mutex
+	queueHold   map[string]int
+	runMu       sync.Mutex
+	runCancel   map[string]context.CancelCauseFunc
 }
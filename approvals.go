@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ApprovalActionApprove = "approve"
+	ApprovalActionReject  = "reject"
+	ApprovalActionComment = "comment"
+
+	approvalCommentMaxLen = 500
+)
+
+func approvalComment(r *http.Request) string {
+	comment := strings.TrimSpace(r.FormValue("comment"))
+	if len([]rune(comment)) > approvalCommentMaxLen {
+		comment = string([]rune(comment)[:approvalCommentMaxLen])
+	}
+	return comment
+}
+
+// handleApprovalAction 处理 POST /api/deployments/{id}/approve|reject|comment。
+// 批准与拒绝必须由上传者以外的用户执行（API 令牌上传时也不能是令牌的创建者）；批准后任务才进入执行队列或计划时间槽。
+func (a *App) handleApprovalAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	if err := parseRequestForm(r); err != nil {
+		http.Error(w, "请求参数解析失败", http.StatusBadRequest)
+		return
+	}
+	dep, ok := a.store.Get(id)
+	if !ok {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
+	principal := principalFromRequest(r)
+	allowed := principal.CanAccessProject(dep.ProjectID)
+	if action != ApprovalActionComment {
+		allowed = principal.Allows(dep.ProjectID, TokenActionDeploy)
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("无权操作程序: %s", dep.ProjectID), http.StatusForbidden)
+		return
+	}
+	comment := approvalComment(r)
+	record := Approval{
+		Action:  action,
+		User:    principal.Username,
+		IP:      a.clientIP(r),
+		Comment: comment,
+		Time:    time.Now(),
+	}
+
+	if action == ApprovalActionComment {
+		if comment == "" {
+			http.Error(w, "评论内容不能为空", http.StatusBadRequest)
+			return
+		}
+		if err := a.store.UpdateField(id, func(d *Deployment) { d.Approvals = append(d.Approvals, record) }); err != nil {
+			http.Error(w, "保存评论失败", http.StatusInternalServerError)
+			return
+		}
+		a.publish(id, "info", "%s 评论: %s", principal.Username, comment)
+		go a.notifyApproval(id, record)
+		a.handleDeploymentsPartial(w, r)
+		return
+	}
+
+	if !strings.EqualFold(dep.Status, "pending_approval") {
+		http.Error(w, "该任务不在待审批状态", http.StatusConflict)
+		return
+	}
+	if a.isSelfApproval(principal, dep) {
+		http.Error(w, "不能审批自己提交的部署，请由其他用户处理", http.StatusForbidden)
+		return
+	}
+
+	if action == ApprovalActionReject {
+		a.rejectDeployment(w, r, dep, record)
+		return
+	}
+
+	projectID := dep.ProjectID
+	scheduled := dep.ScheduledAt != nil
+	approved := false
+	if err := a.store.UpdateField(id, func(d *Deployment) {
+		if !strings.EqualFold(d.Status, "pending_approval") {
+			return
+		}
+		approved = true
+		d.Approvals = append(d.Approvals, record)
+		d.Error = ""
+		switch {
+		case d.ScheduledAt == nil:
+			d.Status = "queued"
+			d.StartedAt = record.Time
+		case d.ScheduledAt.After(record.Time):
+			d.Status = "scheduled"
+		default:
+			d.Status = "queued"
+		}
+	}); err != nil {
+		http.Error(w, "保存审批结果失败", http.StatusInternalServerError)
+		return
+	}
+	if !approved {
+		http.Error(w, "该任务已被其他用户处理", http.StatusConflict)
+		return
+	}
+	a.logger.Info("部署已批准", "deployment_id", id, "project_id", projectID, "approver", principal.Username, "operator", dep.Operator)
+	a.publish(id, "info", "%s 已批准部署%s", principal.Username, formatApprovalComment(comment))
+
+	if scheduled {
+		runAt := *dep.ScheduledAt
+		if runAt.Before(record.Time) {
+			// 审批晚于计划时间时立即排队执行。
+			runAt = record.Time.Add(time.Second)
+		}
+		a.scheduleDeploymentTask(id, runAt)
+	} else if _, err := a.enqueueDeployment(id); err != nil {
+		// 未能入队的任务不会被调度，恢复为待审批并撤销本次批准，由审批人重试。
+		a.revertApproval(id, record)
+		a.logger.Warn("批准后加入执行队列失败", "deployment_id", id, "project_id", projectID, "error", err)
+		a.publish(id, "warn", "加入执行队列失败，已恢复为待审批: %v", err)
+		http.Error(w, fmt.Sprintf("加入执行队列失败，任务已恢复为待审批: %v", err), http.StatusInternalServerError)
+		return
+	}
+	go a.notifyApproval(id, record)
+	a.handleDeploymentsPartial(w, r)
+}
+
+// revertApproval 把批准后未能入队的任务恢复为待审批，并移除这次批准记录。
+func (a *App) revertApproval(id string, record Approval) {
+	_ = a.store.UpdateField(id, func(d *Deployment) {
+		if !strings.EqualFold(d.Status, "queued") {
+			return
+		}
+		d.Status = "pending_approval"
+		d.QueuePosition = 0
+		for i := len(d.Approvals) - 1; i >= 0; i-- {
+			ap := d.Approvals[i]
+			if ap.Action == record.Action && ap.User == record.User && ap.Time.Equal(record.Time) {
+				d.Approvals = append(d.Approvals[:i], d.Approvals[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// isSelfApproval 判断审批人是否就是提交人；通过 API 令牌提交的部署，令牌的创建者视为提交人。
+func (a *App) isSelfApproval(principal authPrincipal, dep Deployment) bool {
+	if strings.EqualFold(principal.Username, dep.Operator) {
+		return true
+	}
+	if dep.TokenID == "" {
+		return false
+	}
+	creator := a.tokenCreator(dep.TokenID)
+	return creator != "" && strings.EqualFold(principal.Username, creator)
+}
+
+func (a *App) rejectDeployment(w http.ResponseWriter, r *http.Request, dep Deployment, record Approval) {
+	rejected := false
+	uploadFile := ""
+	if err := a.store.UpdateField(dep.ID, func(d *Deployment) {
+		if !strings.EqualFold(d.Status, "pending_approval") {
+			return
+		}
+		rejected = true
+		d.Approvals = append(d.Approvals, record)
+		d.Status = "rejected"
+		d.FinishedAt = &record.Time
+		d.DurationMs = record.Time.Sub(d.CreatedAt).Milliseconds()
+		d.Error = "审批被拒绝" + formatApprovalComment(record.Comment)
+		uploadFile = strings.TrimSpace(d.UploadFile)
+	}); err != nil {
+		http.Error(w, "保存审批结果失败", http.StatusInternalServerError)
+		return
+	}
+	if !rejected {
+		http.Error(w, "该任务已被其他用户处理", http.StatusConflict)
+		return
+	}
+	if uploadFile != "" {
+		_ = os.Remove(uploadFile)
+	}
+	a.logger.Info("部署已拒绝", "deployment_id", dep.ID, "project_id", dep.ProjectID, "approver", record.User, "operator", dep.Operator)
+	a.publish(dep.ID, "warn", "%s 已拒绝部署%s", record.User, formatApprovalComment(record.Comment))
+	go a.notifyApproval(dep.ID, record)
+	a.handleDeploymentsPartial(w, r)
+}
+
+func formatApprovalComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	return "：" + comment
+}
+
+// handleDeploymentPreview 对尚未执行的部署（待审批/等待中）计算与目标目录的差异，供审批人查看。
+func (a *App) handleDeploymentPreview(w http.ResponseWriter, r *http.Request, id string) {
+	dep, ok := a.store.Get(id)
+	if !ok || !principalFromRequest(r).CanAccessProject(dep.ProjectID) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "deployment not found"})
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(dep.Status))
+	if dep.Type != "deploy" || (status != "pending_approval" && status != "scheduled" && status != "queued") {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "仅待审批或等待执行的部署支持预览变更"})
+		return
+	}
+	replaceIgnore := newIgnoreMatcher(append(append([]string{}, dep.ReplaceIgnore...), ".replaceignore", packageSignatureFile))
+	changed, ignoredPaths, err := previewZipChanges(dep.UploadFile, dep.TargetDir, replaceIgnore, normalizeReplaceMode(dep.ReplaceMode) == ReplaceModeFull)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("预演失败: %v", err)})
+		return
+	}
+	added, updated, deleted := countChangedFiles(changed)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":             true,
+		"type":           "preview",
+		"id":             dep.ID,
+		"project_id":     dep.ProjectID,
+		"project_name":   dep.ProjectName,
+		"replace_mode":   dep.ReplaceMode,
+		"initial_deploy": dep.InitialDeploy,
+		"backup_skipped": dep.BackupSkipped,
+		"changed":        changed,
+		"replace_ignore": dep.ReplaceIgnore,
+		"ignored_paths":  ignoredPaths,
+		"summary": map[string]any{
+			"total":         len(changed),
+			"added":         added,
+			"updated":       updated,
+			"deleted":       deleted,
+			"ignored_paths": len(ignoredPaths),
+		},
+	})
+}
+
+// notifyApproval 在提交、批准、拒绝或评论部署审批时发送邮件通知。
+func (a *App) notifyApproval(depID string, record Approval) {
+	dep, ok := a.store.Get(depID)
+	if !ok {
+		return
+	}
+	cfg := a.currentConfig()
+	email := strings.TrimSpace(cfg.NotifyEmail)
+	authCode := strings.TrimSpace(cfg.NotifyEmailAuthCode)
+	if email == "" || authCode == "" {
+		return
+	}
+	label := map[string]string{
+		ApprovalActionApprove: "已批准",
+		ApprovalActionReject:  "已拒绝",
+		ApprovalActionComment: "新评论",
+	}[record.Action]
+	if label == "" {
+		label = "待审批"
+	}
+	subject := fmt.Sprintf("[SimpleRemoteUpdate] 部署审批%s %s %s", label, firstNonEmpty(dep.ProjectName, dep.ProjectID), dep.ID)
+	body := fmt.Sprintf(
+		"任务ID: %s\n程序: %s\n版本: %s\n提交人: %s\n计划时间: %s\n状态: %s\n审批动作: %s\n处理人: %s\n意见: %s\n包 SHA-256: %s\n说明: %s\n",
+		dep.ID,
+		firstNonEmpty(dep.ProjectName, dep.ProjectID),
+		firstNonEmpty(dep.Version, "-"),
+		firstNonEmpty(dep.Operator, "-"),
+		formatTimePtr(dep.ScheduledAt),
+		dep.Status,
+		label,
+		firstNonEmpty(record.User, "-"),
+		firstNonEmpty(record.Comment, "-"),
+		firstNonEmpty(dep.PackageSHA256, "-"),
+		firstNonEmpty(dep.Note, "-"),
+	)
+	if err := sendNotifyEmail(email, authCode, subject, body); err != nil {
+		a.logger.Warn("审批通知邮件发送失败", "deployment_id", depID, "error", err.Error())
+		return
+	}
+	a.logger.Info("审批通知邮件已发送", "deployment_id", depID, "action", label, "to", email)
+}
+
+func countChangedFiles(changed []ChangedFile) (added, updated, deleted int) {
+	for _, c := range changed {
+		switch c.Action {
+		case "added":
+			added++
+		case "updated":
+			updated++
+		case "deleted":
+			deleted++
+		}
+	}
+	return added, updated, deleted
+}
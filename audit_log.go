@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditActionSystemUpdate   = "system.update"
+	AuditActionProjectCreate  = "project.create"
+	AuditActionProjectUpdate  = "project.update"
+	AuditActionProjectDelete  = "project.delete"
+	AuditActionPasswordChange = "password.change"
+
+	auditRedacted = "******"
+)
+
+// auditLog 以 JSON Lines 追加写入配置变更审计记录，不提供修改或删除接口。
+type auditLog struct {
+	mu   sync.Mutex
+	file string
+}
+
+func newAuditLog(file string) (*auditLog, error) {
+	if dir := filepath.Dir(file); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file}, nil
+}
+
+func (l *auditLog) Append(entry AuditEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(raw, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List 按时间倒序返回审计记录；target 非空时只返回该对象（程序 ID 或用户名）的记录。
+func (l *auditLog) List(target string) ([]AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []AuditEntry{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	out := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		if target != "" && entry.Target != target {
+			continue
+		}
+		out = append(out, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// recordAudit 写入一条审计记录；写入失败只记日志，不影响已生效的配置变更。
+func (a *App) recordAudit(r *http.Request, action, target string, changes []AuditChange) {
+	entry := AuditEntry{
+		ID:      newID("audit"),
+		Time:    time.Now(),
+		Actor:   principalFromRequest(r).Username,
+		IP:      a.clientIP(r),
+		Action:  action,
+		Target:  target,
+		Changes: changes,
+	}
+	if err := a.audit.Append(entry); err != nil {
+		a.logger.Warn("写入审计日志失败", "action", action, "target", target, "error", err)
+	}
+}
+
+// configAuditDiff 逐字段比较新旧配置；程序按 ID 展开为 projects[<id>].<字段>，敏感项只记录是否设置。
+func configAuditDiff(oldCfg, newCfg Config) []AuditChange {
+	oldFlat := flattenConfigForAudit(oldCfg)
+	newFlat := flattenConfigForAudit(newCfg)
+	keys := make([]string, 0, len(oldFlat)+len(newFlat))
+	for k := range oldFlat {
+		keys = append(keys, k)
+	}
+	for k := range newFlat {
+		if _, ok := oldFlat[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	redacted := auditRedactedFields()
+	changes := make([]AuditChange, 0)
+	for _, k := range keys {
+		oldValue, newValue := oldFlat[k], newFlat[k]
+		if oldValue == newValue {
+			continue
+		}
+		name := k
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		if _, ok := redacted[name]; ok {
+			oldValue, newValue = redactAuditValue(oldValue), redactAuditValue(newValue)
+		}
+		changes = append(changes, AuditChange{Field: k, Old: oldValue, New: newValue})
+	}
+	return changes
+}
+
+func auditRedactedFields() map[string]struct{} {
+	out := map[string]struct{}{"auth_key_sha256": {}}
+	for name := range configSecretFields(&Config{}) {
+		out[name] = struct{}{}
+	}
+	return out
+}
+
+func redactAuditValue(v string) string {
+	if v == "" {
+		return ""
+	}
+	return auditRedacted
+}
+
+var legacyProjectMirrorFields = []string{"current_version", "service_name", "target_dir", "replace_mode", "backup_ignore", "replace_ignore", "max_upload_mb"}
+
+func flattenConfigForAudit(cfg Config) map[string]string {
+	out := map[string]string{}
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return out
+	}
+	if projects, ok := fields["projects"].([]any); ok && len(projects) > 0 {
+		delete(fields, "projects")
+		// 顶层的旧版单程序字段只是默认程序的镜像，已在 projects[...] 中体现，不重复记录。
+		for _, k := range legacyProjectMirrorFields {
+			delete(fields, k)
+		}
+		for _, item := range projects {
+			project, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			id, _ := project["id"].(string)
+			for k, v := range project {
+				out["projects["+id+"]."+k] = auditValueString(v)
+			}
+		}
+	}
+	for k, v := range fields {
+		out[k] = auditValueString(v)
+	}
+	return out
+}
+
+func auditValueString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	default:
+		raw, _ := json.Marshal(t)
+		return string(raw)
+	}
+}
+
+// handleAuditAPI 分页查询审计记录：GET /api/audit?offset=0&limit=50[&target=程序ID或用户名]。
+func (a *App) handleAuditAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all, err := a.audit.List(strings.TrimSpace(r.URL.Query().Get("target")))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": "读取审计日志失败: " + err.Error()})
+		return
+	}
+	offset, limit := parsePageArgs(r, 0, 50, 200)
+	total := len(all)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	items := all[offset:end]
+	writeJSON(w, http.StatusOK, map[string]any{
+		"entries":     items,
+		"offset":      offset,
+		"limit":       limit,
+		"total":       total,
+		"next_offset": end,
+		"has_more":    end < total,
+	})
+}
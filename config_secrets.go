@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// secretPrefix 标记 config.json 中已加密的字段值：enc:v1:base64(nonce || AES-256-GCM 密文)。
+const secretPrefix = "enc:v1:"
+
+// configSecretFields 列出需要加密落盘的配置项；新增敏感配置（如 Webhook 密钥）时在此登记即可。
+func configSecretFields(cfg *Config) map[string]*string {
+	return map[string]*string{
+		"notify_email_auth_code": &cfg.NotifyEmailAuthCode,
+		"oidc_client_secret":     &cfg.OIDCClientSecret,
+	}
+}
+
+// loadOrCreateSecretKey 读取主密钥文件，不存在时生成 32 字节随机密钥并以 0600 权限保存。
+// 主密钥与 config.json 分开存放，备份或外发配置文件时不会连带泄露密钥。
+func loadOrCreateSecretKey(path string) ([]byte, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, errors.New("secret_key_file 不能为空")
+	}
+	b, err := os.ReadFile(path)
+	if err == nil {
+		key, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if decodeErr != nil || len(key) != 32 {
+			return nil, fmt.Errorf("主密钥文件格式错误: %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// encryptSecret 加密明文；明文本身以 secretPrefix 开头时同样加密，前缀不代表已加密。
+func encryptSecret(key []byte, plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 解密带前缀的密文；未加密的旧值原样返回，由调用方决定是否迁移。
+func decryptSecret(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, secretPrefix) {
+		return value, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newSecretCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("密文长度错误")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newSecretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptConfigSecrets 就地解密配置中的敏感项，返回是否存在尚未加密的明文旧值。
+func decryptConfigSecrets(cfg *Config) (bool, error) {
+	key, err := loadOrCreateSecretKey(cfg.SecretKeyFile)
+	if err != nil {
+		return false, err
+	}
+	needMigrate := false
+	for name, field := range configSecretFields(cfg) {
+		if *field == "" {
+			continue
+		}
+		if !strings.HasPrefix(*field, secretPrefix) {
+			needMigrate = true
+			continue
+		}
+		plain, err := decryptSecret(key, *field)
+		if err != nil {
+			return false, fmt.Errorf("解密 %s 失败，请确认 secret_key_file（%s）未被替换: %v", name, cfg.SecretKeyFile, err)
+		}
+		*field = plain
+	}
+	return needMigrate, nil
+}
+
+// encryptConfigSecrets 返回敏感项已加密的配置副本，用于落盘。
+func encryptConfigSecrets(cfg Config) (Config, error) {
+	key, err := loadOrCreateSecretKey(cfg.SecretKeyFile)
+	if err != nil {
+		return Config{}, err
+	}
+	for name, field := range configSecretFields(&cfg) {
+		// 只有能用当前主密钥解密的值才视为已加密的密文原样保存（如直接粘贴的 config.json 密文），其余一律按明文加密。
+		if strings.HasPrefix(*field, secretPrefix) {
+			if _, err := decryptSecret(key, *field); err == nil {
+				continue
+			}
+		}
+		enc, err := encryptSecret(key, *field)
+		if err != nil {
+			return Config{}, fmt.Errorf("加密 %s 失败: %v", name, err)
+		}
+		*field = enc
+	}
+	return cfg, nil
+}
@@ -19,6 +19,26 @@ func defaultConfig() Config {
 		ListenAddr:            ":8090",
 		SessionCookie:         "updater_session",
 		AuthKeySHA256:         sha256Hex(defaultAuthKey),
+		UsersFile:             "users.json",
+		TokensFile:            "data/api_tokens.json",
+		SessionsFile:          "data/sessions.json",
+		AuditFile:             "data/audit.jsonl",
+		JournalDir:            "data/journal",
+		ReleasesFile:          "data/releases.json",
+		SessionIdleMinutes:    60,
+		TrustedProxies:        []string{},
+		LoginMaxFailures:      5,
+		LoginLockoutMinutes:   1,
+		LoginMaxLockoutMins:   60,
+		LoginGlobalMaxFails:   100,
+		TLSCertFile:           "data/tls/server.crt",
+		TLSKeyFile:            "data/tls/server.key",
+		TLSAutoSelfSigned:     true,
+		OIDCDisplayName:       "企业账号",
+		OIDCScopes:            []string{"openid", "profile", "email"},
+		OIDCUsernameClaim:     "preferred_username",
+		OIDCRoleClaim:         "groups",
+		OIDCRoleMapping:       map[string]string{},
 		CurrentVersion:        "0.0.1",
 		UploadDir:             "data/uploads",
 		WorkDir:               "data/work",
@@ -28,6 +48,7 @@ func defaultConfig() Config {
 		SelfUpdateServiceName: "",
 		NotifyEmail:           "",
 		NotifyEmailAuthCode:   "",
+		SecretKeyFile:         "data/secret.key",
 		ServiceName:           "YourServiceName",
 		TargetDir:             "C:/YourApp",
 		ReplaceMode:           ReplaceModeFull,
@@ -59,6 +80,33 @@ func loadConfig(path string) (Config, error) {
 	if cfg.AuthKeySHA256 == "" {
 		cfg.AuthKeySHA256 = sha256Hex(defaultAuthKey)
 	}
+	if strings.TrimSpace(cfg.UsersFile) == "" {
+		cfg.UsersFile = "users.json"
+	}
+	if strings.TrimSpace(cfg.TokensFile) == "" {
+		cfg.TokensFile = "data/api_tokens.json"
+	}
+	if strings.TrimSpace(cfg.SessionsFile) == "" {
+		cfg.SessionsFile = "data/sessions.json"
+	}
+	if strings.TrimSpace(cfg.AuditFile) == "" {
+		cfg.AuditFile = "data/audit.jsonl"
+	}
+	if strings.TrimSpace(cfg.JournalDir) == "" {
+		cfg.JournalDir = "data/journal"
+	}
+	if strings.TrimSpace(cfg.ReleasesFile) == "" {
+		cfg.ReleasesFile = "data/releases.json"
+	}
+	if strings.TrimSpace(cfg.SecretKeyFile) == "" {
+		cfg.SecretKeyFile = "data/secret.key"
+	}
+	if strings.TrimSpace(cfg.OIDCUsernameClaim) == "" {
+		cfg.OIDCUsernameClaim = "preferred_username"
+	}
+	if cfg.OIDCRoleMapping == nil {
+		cfg.OIDCRoleMapping = map[string]string{}
+	}
 	if strings.TrimSpace(cfg.CurrentVersion) == "" {
 		cfg.CurrentVersion = "0.0.1"
 	}
@@ -70,10 +118,24 @@ func loadConfig(path string) (Config, error) {
 		cfg.MaxUploadMB = 1024
 	}
 	normalizeProjects(&cfg)
+	needMigrate, err := decryptConfigSecrets(&cfg)
+	if err != nil {
+		return Config{}, err
+	}
+	if needMigrate {
+		if err := saveConfig(path, cfg); err != nil {
+			return Config{}, fmt.Errorf("加密配置中的明文敏感项失败: %w", err)
+		}
+	}
 	return cfg, nil
 }
 
+// saveConfig 落盘前加密敏感项，内存中的配置始终保持明文。
 func saveConfig(path string, cfg Config) error {
+	cfg, err := encryptConfigSecrets(cfg)
+	if err != nil {
+		return err
+	}
 	raw, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
@@ -115,11 +177,13 @@ func parseTemplates() (*template.Template, error) {
 			switch status {
 			case "success":
 				return "text-emerald-700"
-			case "failed":
+			case "failed", StatusInterrupted:
 				return "text-rose-700"
-			case "deploying", "rollbacking", "queued", "scheduled", "self_updating", "switching":
+			case "deploying", "rollbacking", "queued", "scheduled", "self_updating", "switching", ReleaseStatusRunning, ReleaseStatusReverting:
 				return "text-amber-700"
-			case "canceled", "cancelled":
+			case "pending_approval":
+				return "text-violet-700"
+			case "rejected", "canceled", "cancelled", StatusAborted, ReleaseMemberSkipped:
 				return "text-slate-500"
 			default:
 				return "text-slate-700"
@@ -167,23 +231,3 @@ func sha256Hex(s string) string {
 	sum := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(sum[:])
 }
-
-func isKeyMatch(expectedHash, input string) bool {
-	want := strings.ToLower(strings.TrimSpace(expectedHash))
-	got := strings.ToLower(sha256Hex(input))
-	if len(want) != len(got) {
-		return false
-	}
-	matched := byte(1)
-	for i := 0; i < len(want); i++ {
-		if want[i] != got[i] {
-			matched = 0
-		}
-	}
-	return matched == 1
-}
-
-func isDefaultAuthHash(hash string) bool {
-	want := strings.ToLower(strings.TrimSpace(hash))
-	return want == strings.ToLower(sha256Hex(defaultAuthKey))
-}
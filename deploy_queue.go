@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 每个程序一个先进先出的部署队列：状态为 queued 的部署记录按 queue_position 排队，
+// 程序任务锁释放后由 dispatchQueue 取出队首执行；程序忙碌时上传不再被拒绝。
+
+// queuedDeployments 返回程序中排队等待的部署，按队列顺序排列；未编号的记录（升级前遗留）按创建时间排在最后。
+func (a *App) queuedDeployments(projectID string) []Deployment {
+	out := make([]Deployment, 0)
+	for _, dep := range a.store.List() {
+		if dep.Type == "deploy" && dep.ProjectID == projectID && strings.EqualFold(dep.Status, "queued") {
+			out = append(out, dep)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		pi, pj := out[i].QueuePosition, out[j].QueuePosition
+		if (pi == 0) != (pj == 0) {
+			return pj == 0
+		}
+		if pi != pj {
+			return pi < pj
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+// renumberQueueLocked 按 order 的顺序把排队位置写为 1..n，并清除已离开队列的记录上的位置；调用方持有 queueMu。
+func (a *App) renumberQueueLocked(order []Deployment) error {
+	positions := make(map[string]int, len(order))
+	for i, dep := range order {
+		positions[dep.ID] = i + 1
+	}
+	return a.store.UpdateEach(func(d *Deployment) bool {
+		pos, ok := positions[d.ID]
+		if !ok || !strings.EqualFold(d.Status, "queued") {
+			pos = 0
+		}
+		if d.QueuePosition == pos {
+			return false
+		}
+		d.QueuePosition = pos
+		return true
+	})
+}
+
+// compactQueue 在任务离开队列（取消、被替代）后重排剩余任务的位置。
+func (a *App) compactQueue(projectID string) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	if err := a.renumberQueueLocked(a.queuedDeployments(projectID)); err != nil {
+		a.logger.Warn("更新部署队列失败", "project_id", projectID, "error", err)
+	}
+}
+
+// enqueueDeployment 把 queued / scheduled 状态的部署加入程序队列末尾并尝试调度；程序开启 queue_supersede 时，
+// 同时取消比它更早创建的排队任务。返回入队后的排队位置，已开始执行时为 0。
+func (a *App) enqueueDeployment(id string) (int, error) {
+	dep, ok := a.store.Get(id)
+	if !ok {
+		return 0, errors.New("deployment not found")
+	}
+	a.queueMu.Lock()
+	queued := false
+	if err := a.store.UpdateField(id, func(d *Deployment) {
+		s := strings.ToLower(strings.TrimSpace(d.Status))
+		if d.Type != "deploy" || (s != "queued" && s != "scheduled") {
+			return
+		}
+		queued = true
+		d.Status = "queued"
+		d.Error = ""
+	}); err != nil {
+		a.queueMu.Unlock()
+		return 0, err
+	}
+	if !queued {
+		a.queueMu.Unlock()
+		return 0, fmt.Errorf("任务状态为 %s，无法加入队列", dep.Status)
+	}
+
+	rest := make([]Deployment, 0)
+	for _, q := range a.queuedDeployments(dep.ProjectID) {
+		if q.ID != id {
+			rest = append(rest, q)
+		}
+	}
+	var superseded []Deployment
+	if project, exists := findProjectByID(a.currentConfig().Projects, dep.ProjectID); exists && project.QueueSupersede {
+		kept := make([]Deployment, 0, len(rest))
+		for _, q := range rest {
+			if !q.CreatedAt.Before(dep.CreatedAt) {
+				kept = append(kept, q)
+				continue
+			}
+			if a.supersedeQueuedDeployment(q.ID, id) {
+				superseded = append(superseded, q)
+			}
+		}
+		rest = kept
+	}
+	if err := a.renumberQueueLocked(append(rest, dep)); err != nil {
+		a.logger.Warn("更新部署队列失败", "project_id", dep.ProjectID, "error", err)
+	}
+	a.queueMu.Unlock()
+
+	for _, q := range superseded {
+		a.publish(q.ID, "warn", "排队任务已被更新的部署 %s 替代", id)
+		a.notifyDeploymentIfNeeded(q.ID)
+	}
+	if len(superseded) > 0 {
+		a.publish(id, "info", "已替代 %d 个更早的排队任务", len(superseded))
+	}
+
+	a.dispatchQueue(dep.ProjectID)
+	latest, _ := a.store.Get(id)
+	if !strings.EqualFold(latest.Status, "queued") {
+		return 0, nil
+	}
+	a.publish(id, "info", "程序当前有任务在执行，已加入执行队列，当前位置: 第 %d 位", latest.QueuePosition)
+	return latest.QueuePosition, nil
+}
+
+// supersedeQueuedDeployment 取消一条排队中的部署并记录替代它的部署，删除其上传包。
+func (a *App) supersedeQueuedDeployment(id, byID string) bool {
+	canceled := false
+	uploadFile := ""
+	now := time.Now()
+	_ = a.store.UpdateField(id, func(d *Deployment) {
+		if !strings.EqualFold(d.Status, "queued") {
+			return
+		}
+		canceled = true
+		d.Status = "canceled"
+		d.SupersededBy = byID
+		d.QueuePosition = 0
+		d.FinishedAt = &now
+		d.DurationMs = now.Sub(d.CreatedAt).Milliseconds()
+		d.Error = fmt.Sprintf("已被更新的部署 %s 替代", byID)
+		uploadFile = strings.TrimSpace(d.UploadFile)
+	})
+	if canceled && uploadFile != "" {
+		_ = os.Remove(uploadFile)
+	}
+	return canceled
+}
+
+// dispatchQueue 在程序空闲时取出队首任务执行；任务执行结束释放程序任务锁后会再次调度。
+func (a *App) dispatchQueue(projectID string) {
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	queue := a.queuedDeployments(projectID)
+	if len(queue) == 0 || a.queueHold[projectID] > 0 {
+		return
+	}
+	if ok, _ := a.tryAcquireProjectTask(projectID); !ok {
+		return
+	}
+	for _, head := range queue {
+		// 先登记中止入口再改为 deploying，中止请求看到执行状态时总能找到该任务。
+		ctx, endRun := a.beginTaskRun(context.Background(), head.ID)
+		claimed := false
+		now := time.Now()
+		_ = a.store.UpdateField(head.ID, func(d *Deployment) {
+			if !strings.EqualFold(d.Status, "queued") {
+				return
+			}
+			// 在同一次存储更新中离开队列，避免与取消请求并发时重复执行或执行已取消的任务。
+			claimed = true
+			d.Status = "deploying"
+			d.StartedAt = now
+			d.QueuePosition = 0
+		})
+		if !claimed {
+			endRun()
+			continue
+		}
+		if err := a.renumberQueueLocked(a.queuedDeployments(projectID)); err != nil {
+			a.logger.Warn("更新部署队列失败", "project_id", projectID, "error", err)
+		}
+		a.publish(head.ID, "info", "程序空闲，开始执行")
+		go func() {
+			defer endRun()
+			a.runDeployment(ctx, head.ID, projectID)
+		}()
+		return
+	}
+	a.releaseProjectTask(projectID)
+}
+
+// holdQueue 暂停调度程序的排队任务，让发布回退等需要程序任务锁的操作在当前任务结束后优先执行；
+// 返回的函数恢复调度，调用方应在释放程序任务锁之前调用。
+func (a *App) holdQueue(projectID string) func() {
+	a.queueMu.Lock()
+	a.queueHold[projectID]++
+	a.queueMu.Unlock()
+	return func() {
+		a.queueMu.Lock()
+		if a.queueHold[projectID]--; a.queueHold[projectID] <= 0 {
+			delete(a.queueHold, projectID)
+		}
+		a.queueMu.Unlock()
+	}
+}
+
+// dispatchAllQueues 尝试调度所有有排队任务的程序，用于自更新等全局锁释放之后。
+func (a *App) dispatchAllQueues() {
+	for _, projectID := range a.queuedProjectIDs() {
+		a.dispatchQueue(projectID)
+	}
+}
+
+// resumeQueuedDeployments 在启动时按原顺序恢复各程序的排队任务。
+func (a *App) resumeQueuedDeployments() {
+	for _, projectID := range a.queuedProjectIDs() {
+		a.compactQueue(projectID)
+		a.dispatchQueue(projectID)
+	}
+}
+
+func (a *App) queuedProjectIDs() []string {
+	seen := map[string]struct{}{}
+	out := make([]string, 0)
+	for _, dep := range a.store.List() {
+		if dep.Type != "deploy" || !strings.EqualFold(dep.Status, "queued") {
+			continue
+		}
+		if _, ok := seen[dep.ProjectID]; ok {
+			continue
+		}
+		seen[dep.ProjectID] = struct{}{}
+		out = append(out, dep.ProjectID)
+	}
+	return out
+}
+
+// moveQueuedDeployment 把排队中的部署移动到指定位置（1 为队首，超出范围时放到队尾），返回移动后的位置。
+func (a *App) moveQueuedDeployment(id string, position int) (int, error) {
+	dep, ok := a.store.Get(id)
+	if !ok {
+		return 0, errors.New("deployment not found")
+	}
+	a.queueMu.Lock()
+	defer a.queueMu.Unlock()
+	queue := a.queuedDeployments(dep.ProjectID)
+	idx := -1
+	for i, q := range queue {
+		if q.ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return 0, errors.New("任务不在排队中，可能已开始执行或已取消")
+	}
+	item := queue[idx]
+	queue = append(queue[:idx], queue[idx+1:]...)
+	target := position - 1
+	if target < 0 {
+		target = 0
+	}
+	if target > len(queue) {
+		target = len(queue)
+	}
+	queue = append(queue[:target], append([]Deployment{item}, queue[target:]...)...)
+	if err := a.renumberQueueLocked(queue); err != nil {
+		return 0, err
+	}
+	return target + 1, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StatusAborted 表示执行中的部署或回滚被人工中止；部署已开始替换文件时会先恢复部署前版本。
+const StatusAborted = "aborted"
+
+// beginTaskRun 为正在执行的部署/回滚登记可取消的 context，返回的函数在任务结束时注销。
+// parent 被取消时任务随之中止，中止原因沿用 parent 的原因（如滚动更新中止时的各实例部署）。
+func (a *App) beginTaskRun(parent context.Context, id string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	a.runMu.Lock()
+	a.runCancel[id] = cancel
+	a.runMu.Unlock()
+	return ctx, func() {
+		a.runMu.Lock()
+		delete(a.runCancel, id)
+		a.runMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// abortTaskRun 请求中止执行中的任务；任务不在本进程中执行时返回 false。
+func (a *App) abortTaskRun(id, operator string) bool {
+	if operator == "" {
+		operator = "未知用户"
+	}
+	return a.cancelTaskRun(id, fmt.Errorf("任务已被 %s 中止", operator))
+}
+
+// cancelTaskRun 以 cause 为中止原因取消执行中的任务，用于把上级任务的中止原因传给子任务。
+func (a *App) cancelTaskRun(id string, cause error) bool {
+	a.runMu.Lock()
+	cancel := a.runCancel[id]
+	a.runMu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel(cause)
+	return true
+}
+
+// taskRunning 报告任务是否仍在本进程中执行；执行函数返回（含最后的日志发布）后才变为 false。
+func (a *App) taskRunning(id string) bool {
+	a.runMu.Lock()
+	defer a.runMu.Unlock()
+	_, ok := a.runCancel[id]
+	return ok
+}
+
+// abortCause 返回中止原因；ctx 未取消时返回 nil。
+func abortCause(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	return errors.New("任务已中止")
+}
+
+// sleepContext 等待 d，ctx 取消时提前返回 ctx 的错误。
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 部署日志（journal）逐条追加记录部署/回滚已完成的阶段，每条写入后立即落盘。
+// 任务正常结束（含失败）时删除；进程意外退出后残留的日志用于启动时判断目标目录与服务处于什么状态。
+const (
+	JournalStarted        = "started"
+	JournalBackupDone     = "backup_done"
+	JournalStagingReady   = "staging_ready"
+	JournalServiceStopped = "service_stopped"
+	JournalReplaceStarted = "replace_started"
+	JournalFilesReplaced  = "files_replaced"
+	JournalReplaceDone    = "replace_done"
+	JournalSwapStarted    = "swap_started"
+	JournalSwapDone       = "swap_done"
+	JournalRestoreStarted = "restore_started"
+	JournalRestoreDone    = "restore_done"
+	JournalServiceStarted = "service_started"
+
+	journalProgressInterval = time.Second
+)
+
+// StatusInterrupted 表示任务执行期间本服务进程退出，启动时已检测到并完成（或等待人工）恢复。
+const StatusInterrupted = "interrupted"
+
+type JournalEntry struct {
+	Time        time.Time `json:"time"`
+	Stage       string    `json:"stage"`
+	ServiceName string    `json:"service_name,omitempty"`
+	BackupFile  string    `json:"backup_file,omitempty"`
+	StagingDir  string    `json:"staging_dir,omitempty"`
+	PreviousDir string    `json:"previous_dir,omitempty"`
+	Done        int       `json:"done,omitempty"`
+	Total       int       `json:"total,omitempty"`
+}
+
+// deploymentJournal 是单个任务的部署日志；nil 或打开失败时所有方法均为空操作，不影响部署本身。
+type deploymentJournal struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	lastFile time.Time
+}
+
+func journalPath(dir, id string) string {
+	return filepath.Join(dir, id+".jsonl")
+}
+
+func (a *App) openJournal(id string) *deploymentJournal {
+	dir := a.currentConfig().JournalDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		a.logger.Warn("创建部署日志目录失败", "deployment_id", id, "error", err)
+		return nil
+	}
+	path := journalPath(dir, id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		a.logger.Warn("打开部署日志失败", "deployment_id", id, "error", err)
+		return nil
+	}
+	return &deploymentJournal{path: path, f: f}
+}
+
+func (j *deploymentJournal) Record(e JournalEntry) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return
+	}
+	e.Time = time.Now()
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := j.f.Write(append(raw, '\n')); err == nil {
+		_ = j.f.Sync()
+	}
+}
+
+// FileProgress 记录已替换的文件数，按时间间隔节流，最后一个文件总会写入。
+func (j *deploymentJournal) FileProgress(done, total int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	throttled := done < total && time.Since(j.lastFile) < journalProgressInterval
+	if !throttled {
+		j.lastFile = time.Now()
+	}
+	j.mu.Unlock()
+	if !throttled {
+		j.Record(JournalEntry{Stage: JournalFilesReplaced, Done: done, Total: total})
+	}
+}
+
+// Remove 在任务正常结束后关闭并删除部署日志。
+func (j *deploymentJournal) Remove() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f != nil {
+		_ = j.f.Close()
+		j.f = nil
+	}
+	_ = os.Remove(j.path)
+}
+
+// journalState 汇总部署日志中已完成的阶段。
+type journalState struct {
+	LastStage      string
+	ServiceName    string
+	BackupFile     string
+	StagingDir     string
+	PreviousDir    string
+	ServiceStopped bool
+	ServiceStarted bool
+	FilesTouched   bool
+	Done, Total    int
+}
+
+// readJournal 读取部署日志；进程退出时最后一行可能只写了一半，解析失败的行直接跳过。
+func readJournal(path string) (journalState, error) {
+	var st journalState
+	f, err := os.Open(path)
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e JournalEntry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		st.LastStage = e.Stage
+		if e.ServiceName != "" {
+			st.ServiceName = e.ServiceName
+		}
+		if e.BackupFile != "" {
+			st.BackupFile = e.BackupFile
+		}
+		if e.StagingDir != "" {
+			st.StagingDir = e.StagingDir
+		}
+		if e.PreviousDir != "" {
+			st.PreviousDir = e.PreviousDir
+		}
+		switch e.Stage {
+		case JournalServiceStopped:
+			st.ServiceStopped = true
+			st.ServiceStarted = false
+		case JournalServiceStarted:
+			st.ServiceStarted = true
+			st.ServiceStopped = false
+		case JournalReplaceStarted, JournalSwapStarted, JournalRestoreStarted:
+			st.FilesTouched = true
+		case JournalFilesReplaced:
+			st.Done, st.Total = e.Done, e.Total
+		}
+	}
+	return st, scanner.Err()
+}
+
+// recoverInterruptedTasks 在启动时检查上次进程退出时仍在执行的部署与回滚：
+// 修复目录切换中途的状态，标记为 interrupted 并通知；目标目录已被改动时，
+// 按程序配置 auto_recover_interrupted 自动从备份恢复，否则等待人工在部署记录中恢复。
+func (a *App) recoverInterruptedTasks() {
+	cfg := a.currentConfig()
+	pending := map[string]Deployment{}
+	for _, dep := range a.store.List() {
+		// 排队中的任务尚未改动目标目录，由 resumeQueuedDeployments 按原顺序继续执行。
+		status := strings.ToLower(strings.TrimSpace(dep.Status))
+		if status == "deploying" || status == "rollbacking" {
+			pending[dep.ID] = dep
+		}
+	}
+
+	entries, _ := os.ReadDir(cfg.JournalDir)
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".jsonl")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if _, running := pending[id]; !running {
+			// 任务已结束但日志未及删除，或记录已不存在。
+			_ = os.Remove(filepath.Join(cfg.JournalDir, e.Name()))
+		}
+	}
+
+	// 同一程序的中断任务（如部署与其自动回滚）按创建顺序依次处理。
+	byProject := map[string][]Deployment{}
+	for _, dep := range pending {
+		projectID := strings.TrimSpace(dep.ProjectID)
+		if projectID == "" {
+			projectID = cfg.DefaultProjectID
+		}
+		byProject[projectID] = append(byProject[projectID], dep)
+	}
+	for projectID, deps := range byProject {
+		if ok, _ := a.tryAcquireProjectTask(projectID); !ok {
+			continue
+		}
+		sort.Slice(deps, func(i, j int) bool { return deps[i].CreatedAt.Before(deps[j].CreatedAt) })
+		go func(projectID string, deps []Deployment) {
+			defer a.releaseProjectTask(projectID)
+			for _, dep := range deps {
+				a.recoverInterruptedTask(dep.ID)
+			}
+		}(projectID, deps)
+	}
+}
+
+func (a *App) recoverInterruptedTask(id string) {
+	defer a.notifyDeploymentIfNeeded(id)
+	defer func() {
+		if rec := recover(); rec != nil {
+			a.logger.Error("interrupted task recovery panic", "deployment_id", id, "panic", rec)
+		}
+	}()
+
+	dep, ok := a.store.Get(id)
+	if !ok {
+		return
+	}
+	cfg := a.currentConfig()
+	path := journalPath(cfg.JournalDir, id)
+	st, err := readJournal(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// 没有部署日志（如升级前开始的任务）时无法判断进度，按目标目录可能已改动处理。
+		st.FilesTouched = true
+		st.LastStage = "未知"
+	} else if err != nil {
+		a.publish(id, "warn", "读取部署日志失败: %v", err)
+	}
+	defer os.Remove(path)
+	if st.ServiceName == "" {
+		st.ServiceName = dep.ServiceName
+	}
+	if st.PreviousDir == "" {
+		st.PreviousDir = dep.PreviousDir
+	}
+	if st.BackupFile == "" {
+		st.BackupFile = dep.BackupFile
+	}
+	a.publish(id, "warn", "检测到任务在服务重启前中断，最后完成的阶段: %s", firstNonEmpty(st.LastStage, "-"))
+
+	// 目录切换时进程在两次重命名之间退出：目标目录不存在而上一版本目录仍在，先把目录换回来。
+	if st.PreviousDir != "" && !isExistingDir(dep.TargetDir) && isExistingDir(st.PreviousDir) {
+		if err := os.Rename(st.PreviousDir, dep.TargetDir); err != nil {
+			a.publish(id, "error", "还原目标目录失败，原目录位于 %s: %v", st.PreviousDir, err)
+		} else {
+			a.publish(id, "warn", "已把 %s 还原为目标目录", st.PreviousDir)
+			st.PreviousDir = ""
+			if dep.Type == "deploy" {
+				st.FilesTouched = false
+			}
+		}
+	}
+	if st.StagingDir != "" {
+		_ = os.RemoveAll(st.StagingDir)
+	}
+
+	reason := fmt.Sprintf("服务重启前任务中断（阶段: %s", firstNonEmpty(st.LastStage, "未开始"))
+	if st.Total > 0 {
+		reason += fmt.Sprintf("，已替换 %d/%d 个文件", st.Done, st.Total)
+	}
+	reason += "）"
+
+	if len(dep.InstanceDeployments) > 0 {
+		// 滚动更新本身不改动目录，已开始的实例由各自的部署记录按部署日志恢复。
+		a.markInterrupted(id, reason+fmt.Sprintf("；滚动更新已停止，各实例的恢复情况见实例部署记录 %s，未开始的实例未更新",
+			strings.Join(dep.InstanceDeployments, ", ")))
+		return
+	}
+	if !st.FilesTouched {
+		// 目标目录未被改动，只需把停止的服务重新启动。
+		if st.ServiceStopped && st.ServiceName != "" {
+			if err := startService(context.Background(), st.ServiceName, 45*time.Second); err != nil {
+				reason += fmt.Sprintf("；重新启动服务失败: %v", err)
+			} else {
+				reason += "；目标目录未改动，已重新启动服务"
+			}
+		} else {
+			reason += "；目标目录未改动"
+		}
+		a.markInterrupted(id, reason)
+		return
+	}
+
+	project, _ := findProjectByID(cfg.Projects, dep.ProjectID)
+	if st.BackupFile == "" {
+		a.markInterrupted(id, reason+"；没有可用备份，请人工检查目标目录与服务")
+		return
+	}
+	if !project.AutoRecoverInterrupted {
+		a.markInterrupted(id, reason+"；目标目录可能不完整，请在部署记录中点击“恢复部署前版本”")
+		return
+	}
+
+	a.markInterrupted(id, reason)
+	_ = a.store.UpdateField(id, func(d *Deployment) {
+		d.BackupFile = st.BackupFile
+		d.PreviousDir = st.PreviousDir
+	})
+	source, _ := a.store.Get(id)
+	rbID := newID("rb")
+	rollback := newRollbackDeployment(rbID, source, source.ProjectID)
+	rollback.Note = fmt.Sprintf("%s 中断后启动时自动恢复", id)
+	rollback.LoginIP = source.LoginIP
+	rollback.Operator = source.Operator
+	if err := a.store.Add(rollback); err != nil {
+		a.markInterrupted(id, reason+fmt.Sprintf("；创建恢复记录失败: %v", err))
+		return
+	}
+	a.publish(id, "warn", "按程序配置自动恢复部署前版本，恢复记录: %s", rbID)
+	a.executeRollback(rbID, id, dep.Type == "rollback")
+	a.notifyDeploymentIfNeeded(rbID)
+	if rb, _ := a.store.Get(rbID); rb.Status != "success" {
+		a.markInterrupted(id, reason+fmt.Sprintf("；自动恢复失败（%s）: %s", rbID, rb.Error))
+		return
+	}
+	a.markInterrupted(id, reason+fmt.Sprintf("；已自动恢复（%s）", rbID))
+}
+
+func (a *App) markInterrupted(id, reason string) {
+	now := time.Now()
+	_ = a.store.UpdateField(id, func(d *Deployment) {
+		d.Status = StatusInterrupted
+		d.FinishedAt = &now
+		if !d.StartedAt.IsZero() {
+			d.DurationMs = now.Sub(d.StartedAt).Milliseconds()
+		}
+		d.Error = reason
+	})
+	a.publish(id, "warn", "%s", reason)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -15,9 +16,23 @@ const (
 	fileOpRetryDelay    = 1500 * time.Millisecond
 )
 
-func (a *App) runDeployment(id, projectID string) {
+func (a *App) runDeployment(ctx context.Context, id, projectID string) {
 	defer a.releaseProjectTask(projectID)
 	defer a.notifyDeploymentIfNeeded(id)
+	if dep, ok := a.store.Get(id); ok {
+		if project, exists := findProjectByID(a.currentConfig().Projects, dep.ProjectID); exists && len(project.Instances) > 0 {
+			a.runRollingDeployment(ctx, id, project)
+			return
+		}
+	}
+	a.executeDeployment(ctx, id)
+}
+
+// executeDeployment 执行部署记录 id。调用方负责程序任务锁与通知；parent 取消时部署随之中止。
+// 滚动更新的实例部署（parent_id 非空）使用记录中实例的目录与服务，成功后不写程序当前版本。
+func (a *App) executeDeployment(parent context.Context, id string) {
+	ctx, endRun := a.beginTaskRun(parent, id)
+	defer endRun()
 	defer func() {
 		if rec := recover(); rec != nil {
 			a.logger.Error("deployment panic", "deployment_id", id, "panic", rec)
@@ -41,7 +56,13 @@ func (a *App) runDeployment(id, projectID string) {
 	if dep.ProjectID != "" {
 		if p, exists := findProjectByID(cfg.Projects, dep.ProjectID); exists {
 			project = p
-			dep.ServiceName = p.ServiceName
+			if inst, ok := projectInstanceByID(p, dep.InstanceID); ok {
+				if inst.HealthCheck != nil {
+					project.HealthCheck = inst.HealthCheck
+				}
+			} else {
+				dep.ServiceName = p.ServiceName
+			}
 			if dep.TargetDir == "" {
 				dep.TargetDir = p.TargetDir
 			}
@@ -82,8 +103,18 @@ func (a *App) runDeployment(id, projectID string) {
 		d.Status = "deploying"
 		d.StartedAt = start
 	})
+	hc := hookContext{DeploymentID: id, ProjectID: dep.ProjectID, InstanceID: dep.InstanceID, Version: dep.Version, TargetDir: dep.TargetDir}
+	journal := a.openJournal(id)
+	defer journal.Remove()
+	journal.Record(JournalEntry{Stage: JournalStarted, ServiceName: dep.ServiceName})
 
 	finish := func(status string, err error, changed []ChangedFile, backupPath string) {
+		if status == "failed" && err != nil {
+			hc.Error = err.Error()
+			if hookErr := a.runProjectHooks(context.Background(), project, HookStageOnFailure, -1, hc); hookErr != nil {
+				a.publish(id, "warn", "%v", hookErr)
+			}
+		}
 		now := time.Now()
 		_ = a.store.UpdateField(id, func(d *Deployment) {
 			d.Status = status
@@ -104,6 +135,20 @@ func (a *App) runDeployment(id, projectID string) {
 	}
 
 	a.publishProgress(id, "info", "准备部署", 5, "部署开始")
+	if dep.PackageSHA256 != "" {
+		sum, err := fileSHA256(dep.UploadFile)
+		if err != nil || sum != dep.PackageSHA256 {
+			if err == nil {
+				err = fmt.Errorf("上传包摘要不一致，文件可能已被篡改: 期望 %s，实际 %s", dep.PackageSHA256, sum)
+			}
+			finish("failed", fmt.Errorf("校验上传包失败: %w", err), nil, "")
+			a.publish(id, "error", "校验上传包失败: %v", err)
+			return
+		}
+		if dep.SignatureStatus == SignatureStatusVerified {
+			a.publish(id, "info", "上传包签名已验证（%s，公钥 %s），SHA-256: %s", dep.SignatureMode, dep.SignerKeyID, dep.PackageSHA256)
+		}
+	}
 	targetExists, targetEmpty, targetCheckErr := inspectTargetDirState(dep.TargetDir)
 	if targetCheckErr != nil {
 		finish("failed", fmt.Errorf("检查目标目录失败: %w", targetCheckErr), nil, "")
@@ -148,7 +193,7 @@ func (a *App) runDeployment(id, projectID string) {
 	} else if len(replaceRules) == 0 {
 		replaceRules = resolveReplaceIgnoreRulesForTarget(dep.TargetDir, cfg.ReplaceIgnore, cfg.BackupIgnore)
 	}
-	replaceIgnore := newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore"))
+	replaceIgnore := newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore", packageSignatureFile))
 	dep.ReplaceMode = normalizeReplaceMode(dep.ReplaceMode)
 	removeMissing := dep.ReplaceMode == ReplaceModeFull
 	if removeMissing {
@@ -157,8 +202,67 @@ func (a *App) runDeployment(id, projectID string) {
 		a.publish(id, "info", "替换模式: 局部替换（仅覆盖上传包中的文件，不删除其他文件）")
 	}
 
-	backupPath := filepath.Join(cfg.BackupDir, id+".zip")
+	// 中止时按进度清理：已开始改动目标目录则恢复部署前版本，否则只重新启动已停止的服务。
+	backupPath := ""
+	serviceStopped := false
+	filesTouched := false
+	restoreTarget := func() backupRestore {
+		return backupRestore{
+			ServiceName:   dep.ServiceName,
+			TargetDir:     dep.TargetDir,
+			BackupFile:    backupPath,
+			ReplaceIgnore: newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore")),
+			PreviousDir:   a.deploymentPreviousDir(id),
+			Journal:       journal,
+		}
+	}
+	aborted := func(changed []ChangedFile) bool {
+		cause := abortCause(ctx)
+		if cause == nil {
+			return false
+		}
+		a.publishProgress(id, "warn", "中止部署", -1, "%v，开始清理", cause)
+		err := cause
+		switch {
+		case filesTouched && backupPath == "":
+			err = fmt.Errorf("%v；本次部署没有备份，目标目录可能不完整，请人工检查", cause)
+		case filesTouched:
+			a.publishProgress(id, "warn", "中止部署", 96, "恢复部署前版本: %s", backupPath)
+			if restoreErr := a.restoreBackup(context.Background(), id, restoreTarget(), func(p int) int { return 96 + p*3/100 }); restoreErr != nil {
+				err = fmt.Errorf("%v；恢复部署前版本失败: %v", cause, restoreErr)
+			} else {
+				err = fmt.Errorf("%v；已恢复部署前版本", cause)
+			}
+		case serviceStopped:
+			if startErr := startService(context.Background(), dep.ServiceName, 45*time.Second); startErr != nil {
+				err = fmt.Errorf("%v；目标目录未改动，重新启动服务失败: %v", cause, startErr)
+			} else {
+				err = fmt.Errorf("%v；目标目录未改动，已重新启动服务", cause)
+			}
+		default:
+			err = fmt.Errorf("%v；目标目录未改动", cause)
+		}
+		finish(StatusAborted, err, changed, backupPath)
+		a.publish(id, "warn", "%v", err)
+		return true
+	}
+
+	if err := a.runProjectHooks(ctx, project, HookStageBeforeBackup, 12, hc); err != nil {
+		if aborted(nil) {
+			return
+		}
+		finish("failed", err, nil, "")
+		a.publish(id, "error", "%v", err)
+		return
+	}
+	if aborted(nil) {
+		return
+	}
+
+	backupPath = filepath.Join(cfg.BackupDir, id+".zip")
+	hc.BackupFile = backupPath
 	if dep.InitialDeploy {
+		hc.BackupFile = ""
 		backupPath = ""
 		dep.BackupSkipped = true
 		_ = a.store.UpdateField(id, func(d *Deployment) {
@@ -168,16 +272,25 @@ func (a *App) runDeployment(id, projectID string) {
 		a.publishProgress(id, "info", "备份目标目录", 30, "首次部署跳过备份：目标目录为空或不存在")
 	} else {
 		a.publishProgress(id, "info", "备份目标目录", 15, "开始备份目标目录")
-		if err := zipDirectory(dep.TargetDir, backupPath, backupIgnore); err != nil {
+		backupSHA256, err := zipDirectory(ctx, dep.TargetDir, backupPath, backupIgnore)
+		if err != nil {
+			if ctx.Err() != nil {
+				_ = os.Remove(backupPath)
+				backupPath = ""
+				aborted(nil)
+				return
+			}
 			finish("failed", fmt.Errorf("备份失败: %w", err), nil, "")
 			a.publish(id, "error", "备份失败: %v", err)
 			return
 		}
 		_ = a.store.UpdateField(id, func(d *Deployment) {
 			d.BackupFile = backupPath
+			d.BackupSHA256 = backupSHA256
 			d.BackupSkipped = false
 		})
-		a.publishProgress(id, "info", "备份目标目录", 30, "备份完成: %s", backupPath)
+		journal.Record(JournalEntry{Stage: JournalBackupDone, BackupFile: backupPath})
+		a.publishProgress(id, "info", "备份目标目录", 30, "备份完成: %s（SHA-256: %s）", backupPath, backupSHA256)
 	}
 
 	workDir := filepath.Join(cfg.WorkDir, id)
@@ -189,6 +302,7 @@ func (a *App) runDeployment(id, projectID string) {
 		return
 	}
 	defer os.RemoveAll(workDir)
+	hc.PackageDir = extractDir
 
 	a.publishProgress(id, "info", "解压上传包", 40, "解压上传包")
 	if err := extractZip(dep.UploadFile, extractDir); err != nil {
@@ -196,6 +310,35 @@ func (a *App) runDeployment(id, projectID string) {
 		a.publish(id, "error", "解压失败: %v", err)
 		return
 	}
+	if aborted(nil) {
+		return
+	}
+
+	dep.DeployStrategy = normalizeDeployStrategy(project.DeployStrategy)
+	swap := dep.DeployStrategy == DeployStrategySwap
+	var changed []ChangedFile
+	stagingDir := ""
+	if swap {
+		_ = a.store.UpdateField(id, func(d *Deployment) { d.DeployStrategy = dep.DeployStrategy })
+		stagingDir = swapSiblingDir(dep.TargetDir, swapStagingSuffix, id)
+		defer os.RemoveAll(stagingDir)
+		a.publishProgress(id, "info", "构建新版本目录", 45, "部署方式: 目录切换，在 %s 构建新版本目录", stagingDir)
+		var err error
+		changed, err = buildStagingDir(ctx, dep.TargetDir, stagingDir, extractDir, replaceIgnore, removeMissing, !dep.InitialDeploy && targetExists)
+		if err != nil {
+			if aborted(nil) {
+				return
+			}
+			finish("failed", fmt.Errorf("构建新版本目录失败: %w", err), nil, backupPath)
+			a.publish(id, "error", "构建新版本目录失败: %v", err)
+			return
+		}
+		journal.Record(JournalEntry{Stage: JournalStagingReady, StagingDir: stagingDir})
+		a.publishProgress(id, "info", "构建新版本目录", 50, "新版本目录已就绪，变更文件数: %d", len(changed))
+		if aborted(nil) {
+			return
+		}
+	}
 
 	serviceManaged := dep.ServiceName != ""
 	serviceExistsNow := false
@@ -212,13 +355,18 @@ func (a *App) runDeployment(id, projectID string) {
 	}
 	if serviceManaged && serviceExistsNow {
 		a.publishProgress(id, "info", "停止服务", 55, "停止服务: %s", dep.ServiceName)
-		if err := stopService(dep.ServiceName, 45*time.Second); err != nil {
+		serviceStopped = true
+		if err := stopService(ctx, dep.ServiceName, 45*time.Second); err != nil {
+			if aborted(nil) {
+				return
+			}
 			finish("failed", fmt.Errorf("停止服务失败: %w", err), nil, backupPath)
 			a.publish(id, "error", "停止服务失败: %v", err)
 			return
 		}
+		journal.Record(JournalEntry{Stage: JournalServiceStopped, ServiceName: dep.ServiceName})
 		a.publish(id, "info", "服务已停止")
-		a.waitAfterServiceStop(id, "替换文件", 60, dep.ServiceName)
+		a.waitAfterServiceStop(ctx, id, "替换文件", 60, dep.ServiceName)
 	} else if serviceManaged && serviceShouldCreate {
 		a.publish(id, "info", "服务 %s 当前不存在，将在部署完成后自动创建", dep.ServiceName)
 	} else if serviceManaged {
@@ -228,52 +376,135 @@ func (a *App) runDeployment(id, projectID string) {
 	} else {
 		a.publish(id, "warn", "service_name 为空，跳过停止服务，直接替换文件")
 	}
-	if err := os.MkdirAll(dep.TargetDir, 0755); err != nil {
-		finish("failed", fmt.Errorf("创建目标目录失败: %w", err), nil, backupPath)
-		a.publish(id, "error", "创建目标目录失败: %v", err)
+	if err := a.runProjectHooks(ctx, project, HookStageAfterStop, 62, hc); err != nil {
+		if aborted(nil) {
+			return
+		}
+		if serviceManaged && serviceExistsNow {
+			// 文件尚未替换，恢复启动原服务。
+			if restartErr := startService(context.Background(), dep.ServiceName, 45*time.Second); restartErr != nil {
+				err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+			}
+		}
+		finish("failed", err, nil, backupPath)
+		a.publish(id, "error", "%v", err)
 		return
 	}
-	if dep.ClearTargetBeforeDeploy && targetHasExistingFiles {
-		a.publishProgress(id, "warn", "清空目标目录", 66, "检测到首次部署前目标目录已有内容，开始清空目标目录")
-		if err := a.runFileOpWithRetry(id, "清空目标目录", 66, "清空目标目录", func() error {
-			return clearDirWithIgnore(dep.TargetDir, newIgnoreMatcher(nil))
-		}); err != nil {
-			finish("failed", fmt.Errorf("清空目标目录失败: %w", err), nil, backupPath)
-			a.publish(id, "error", "清空目标目录失败: %v", err)
-			return
+
+	// 从替换文件开始，失败时目标目录可能已是新旧混合状态；开启 auto_rollback_on_failure 时自动恢复本次备份。
+	autoRollback := project.AutoRollbackOnFailure && backupPath != ""
+	failAfterReplace := func(err error, changed []ChangedFile) {
+		a.publish(id, "error", "%v", err)
+		if autoRollback {
+			_, err = a.autoRollbackDeployment(id, err)
 		}
-		a.publish(id, "warn", "目标目录已清空，开始部署压缩包内容")
+		finish("failed", err, changed, backupPath)
+	}
+	if aborted(nil) {
+		return
 	}
 
-	a.publishProgress(id, "info", "替换文件", 70, "开始替换文件")
-	var changed []ChangedFile
-	err := a.runFileOpWithRetry(id, "替换文件", 70, "替换文件", func() error {
-		var syncErr error
-		changed, syncErr = syncDirectories(extractDir, dep.TargetDir, replaceIgnore, removeMissing)
-		return syncErr
-	})
-	if err != nil {
-		if serviceManaged {
-			if restartErr := startService(dep.ServiceName, 45*time.Second); restartErr != nil {
-				err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+	if swap {
+		a.publishProgress(id, "info", "切换目录", 70, "切换到新版本目录")
+		journal.Record(JournalEntry{Stage: JournalSwapStarted, StagingDir: stagingDir, PreviousDir: swapSiblingDir(dep.TargetDir, swapPreviousSuffix, id)})
+		prevDir, err := a.swapInStagingDir(ctx, id, dep.TargetDir, stagingDir, replaceIgnore, !dep.InitialDeploy, 70)
+		if err != nil {
+			// 切换失败时目标目录已还原，重新启动原服务即可。
+			if aborted(nil) {
+				return
 			}
+			if serviceManaged && serviceExistsNow {
+				if restartErr := startService(context.Background(), dep.ServiceName, 45*time.Second); restartErr != nil {
+					err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+				}
+			}
+			finish("failed", fmt.Errorf("切换目录失败: %w", err), nil, backupPath)
+			a.publish(id, "error", "切换目录失败: %v", err)
+			return
 		}
-		finish("failed", fmt.Errorf("替换文件失败: %w", err), nil, backupPath)
-		a.publish(id, "error", "替换文件失败: %v", err)
-		return
+		if prevDir != "" && dep.InitialDeploy {
+			// 首次部署（含清空目标目录）不保留原目录，与就地清空的行为一致。
+			_ = os.RemoveAll(prevDir)
+			prevDir = ""
+		}
+		filesTouched = true
+		journal.Record(JournalEntry{Stage: JournalSwapDone, PreviousDir: prevDir})
+		if prevDir != "" {
+			_ = a.store.UpdateField(id, func(d *Deployment) { d.PreviousDir = prevDir })
+			a.publish(id, "info", "上一版本目录已保留: %s", prevDir)
+		}
+	} else {
+		journal.Record(JournalEntry{Stage: JournalReplaceStarted})
+		filesTouched = true
+		if err := os.MkdirAll(dep.TargetDir, 0755); err != nil {
+			finish("failed", fmt.Errorf("创建目标目录失败: %w", err), nil, backupPath)
+			a.publish(id, "error", "创建目标目录失败: %v", err)
+			return
+		}
+		if dep.ClearTargetBeforeDeploy && targetHasExistingFiles {
+			a.publishProgress(id, "warn", "清空目标目录", 66, "检测到首次部署前目标目录已有内容，开始清空目标目录")
+			if err := a.runFileOpWithRetry(ctx, id, "清空目标目录", 66, "清空目标目录", func() error {
+				return clearDirWithIgnore(dep.TargetDir, newIgnoreMatcher(nil))
+			}); err != nil {
+				if aborted(nil) {
+					return
+				}
+				finish("failed", fmt.Errorf("清空目标目录失败: %w", err), nil, backupPath)
+				a.publish(id, "error", "清空目标目录失败: %v", err)
+				return
+			}
+			a.publish(id, "warn", "目标目录已清空，开始部署压缩包内容")
+		}
+
+		a.publishProgress(id, "info", "替换文件", 70, "开始替换文件")
+		err := a.runFileOpWithRetry(ctx, id, "替换文件", 70, "替换文件", func() error {
+			var syncErr error
+			changed, syncErr = syncDirectories(ctx, extractDir, dep.TargetDir, replaceIgnore, removeMissing, journal.FileProgress)
+			return syncErr
+		})
+		if err != nil {
+			if aborted(nil) {
+				return
+			}
+			if serviceManaged && !autoRollback {
+				if restartErr := startService(context.Background(), dep.ServiceName, 45*time.Second); restartErr != nil {
+					err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+				}
+			}
+			failAfterReplace(fmt.Errorf("替换文件失败: %w", err), nil)
+			return
+		}
+		journal.Record(JournalEntry{Stage: JournalReplaceDone})
 	}
 	a.publishProgress(id, "info", "替换文件", 82, "文件替换完成，变更文件数: %d", len(changed))
+	if err := a.runProjectHooks(ctx, project, HookStageAfterReplace, 84, hc); err != nil {
+		if aborted(changed) {
+			return
+		}
+		if !autoRollback {
+			err = fmt.Errorf("%w；文件已替换，可回滚到部署前版本", err)
+			// 不自动回滚时服务保持停止会导致程序不可用，与替换失败一样重新启动服务。
+			if serviceManaged && serviceExistsNow {
+				if restartErr := startService(context.Background(), dep.ServiceName, 45*time.Second); restartErr != nil {
+					err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+				}
+			}
+		}
+		failAfterReplace(err, changed)
+		return
+	}
+	if aborted(changed) {
+		return
+	}
 	if serviceShouldCreate {
 		serviceCfg, cfgErr := buildServiceInstallConfig(cfg, dep)
 		if cfgErr != nil {
-			finish("failed", cfgErr, changed, backupPath)
-			a.publish(id, "error", "%v", cfgErr)
+			failAfterReplace(cfgErr, changed)
 			return
 		}
 		a.publishProgress(id, "info", "安装服务", 86, "创建服务: %s", dep.ServiceName)
 		if err := createService(dep.ServiceName, serviceCfg); err != nil {
-			finish("failed", fmt.Errorf("创建服务失败: %w", err), changed, backupPath)
-			a.publish(id, "error", "创建服务失败: %v", err)
+			failAfterReplace(fmt.Errorf("创建服务失败: %w", err), changed)
 			return
 		}
 		dep.ServiceCreated = true
@@ -283,16 +514,54 @@ func (a *App) runDeployment(id, projectID string) {
 
 	if serviceManaged {
 		a.publishProgress(id, "info", "启动服务", 90, "启动服务: %s", dep.ServiceName)
-		if err := startService(dep.ServiceName, 45*time.Second); err != nil {
-			finish("failed", fmt.Errorf("启动服务失败: %w", err), changed, backupPath)
-			a.publish(id, "error", "启动服务失败: %v", err)
+		if err := startService(ctx, dep.ServiceName, 45*time.Second); err != nil {
+			if aborted(changed) {
+				return
+			}
+			failAfterReplace(fmt.Errorf("启动服务失败: %w", err), changed)
 			return
 		}
+		journal.Record(JournalEntry{Stage: JournalServiceStarted, ServiceName: dep.ServiceName})
 	} else {
 		a.publish(id, "warn", "service_name 为空，跳过启动服务")
 	}
+	if err := a.runProjectHooks(ctx, project, HookStageAfterStart, 93, hc); err != nil {
+		if aborted(changed) {
+			return
+		}
+		failAfterReplace(err, changed)
+		return
+	}
+	if project.HealthCheck != nil {
+		if err := a.runHealthCheck(ctx, id, *project.HealthCheck, 94, dep.TargetDir); err != nil {
+			if aborted(changed) {
+				return
+			}
+			_ = a.store.UpdateField(id, func(d *Deployment) { d.HealthStatus = HealthStatusFailed })
+			a.publish(id, "error", "%v", err)
+			if autoRollback {
+				// 与其他替换后失败一样生成关联的回滚记录，恢复结果同时写入 auto_restore。
+				restore, rbErr := a.autoRollbackDeployment(id, err)
+				_ = a.store.UpdateField(id, func(d *Deployment) {
+					d.AutoRestore = restore
+					if restore == AutoRestoreFailed {
+						d.AutoRestoreError = rbErr.Error()
+					}
+				})
+				finish("failed", rbErr, changed, backupPath)
+				return
+			}
+			restoreErr := a.restoreAfterHealthFailure(id, restoreTarget(), err)
+			finish("failed", restoreErr, changed, backupPath)
+			return
+		}
+		_ = a.store.UpdateField(id, func(d *Deployment) { d.HealthStatus = HealthStatusPassed })
+	}
+	if aborted(changed) {
+		return
+	}
 
-	if dep.Version != "" {
+	if dep.Version != "" && dep.ParentID == "" {
 		if err := a.setProjectCurrentVersion(dep.ProjectID, dep.Version); err != nil {
 			a.publish(id, "warn", "部署成功，但写入当前版本失败: %v", err)
 		} else {
@@ -307,6 +576,14 @@ func (a *App) runDeployment(id, projectID string) {
 func (a *App) runRollback(id, sourceID, projectID string) {
 	defer a.releaseProjectTask(projectID)
 	defer a.notifyDeploymentIfNeeded(id)
+	a.executeRollback(id, sourceID, true)
+}
+
+// executeRollback 执行回滚记录 id：用源部署的备份恢复目标目录。调用方负责程序任务锁与通知；
+// setVersion 为 true 时把程序当前版本写为源部署的版本。
+func (a *App) executeRollback(id, sourceID string, setVersion bool) {
+	ctx, endRun := a.beginTaskRun(context.Background(), id)
+	defer endRun()
 	defer func() {
 		if rec := recover(); rec != nil {
 			a.logger.Error("rollback panic", "deployment_id", id, "panic", rec)
@@ -368,6 +645,9 @@ func (a *App) runRollback(id, sourceID, projectID string) {
 	if len(dep.ReplaceIgnore) == 0 && len(source.ReplaceIgnore) > 0 {
 		dep.ReplaceIgnore = append([]string{}, source.ReplaceIgnore...)
 	}
+	journal := a.openJournal(id)
+	defer journal.Remove()
+	journal.Record(JournalEntry{Stage: JournalStarted, ServiceName: dep.ServiceName, BackupFile: dep.BackupFile})
 	if dep.BackupFile == "" {
 		finish("failed", errors.New("回滚失败: 备份文件路径为空"))
 		a.publish(id, "error", "回滚失败: 备份文件路径为空")
@@ -378,6 +658,20 @@ func (a *App) runRollback(id, sourceID, projectID string) {
 		a.publish(id, "error", "回滚失败: 找不到备份文件 %s", dep.BackupFile)
 		return
 	}
+	if dep.BackupSHA256 == "" {
+		dep.BackupSHA256 = source.BackupSHA256
+	}
+	if dep.BackupSHA256 != "" {
+		sum, err := fileSHA256(dep.BackupFile)
+		if err != nil || sum != dep.BackupSHA256 {
+			if err == nil {
+				err = fmt.Errorf("备份文件摘要不一致，可能已损坏或被篡改: 期望 %s，实际 %s", dep.BackupSHA256, sum)
+			}
+			finish("failed", fmt.Errorf("回滚失败: %w", err))
+			a.publish(id, "error", "回滚失败: %v", err)
+			return
+		}
+	}
 
 	replaceRules := dep.ReplaceIgnore
 	if len(replaceRules) == 0 {
@@ -386,57 +680,27 @@ func (a *App) runRollback(id, sourceID, projectID string) {
 	replaceIgnore := newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore"))
 	a.publishProgress(id, "info", "准备回滚", 8, "回滚开始，目标记录: %s", sourceID)
 
-	serviceManaged := dep.ServiceName != ""
-	if serviceManaged {
-		a.publishProgress(id, "info", "停止服务", 30, "停止服务: %s", dep.ServiceName)
-		if err := stopService(dep.ServiceName, 45*time.Second); err != nil {
-			finish("failed", fmt.Errorf("停止服务失败: %w", err))
-			a.publish(id, "error", "停止服务失败: %v", err)
+	if err := a.restoreBackup(ctx, id, backupRestore{
+		ServiceName:   dep.ServiceName,
+		TargetDir:     dep.TargetDir,
+		BackupFile:    dep.BackupFile,
+		ReplaceIgnore: replaceIgnore,
+		PreviousDir:   firstNonEmpty(dep.PreviousDir, source.PreviousDir),
+		Journal:       journal,
+	}, func(p int) int { return p }); err != nil {
+		if cause := abortCause(ctx); cause != nil {
+			err = fmt.Errorf("%v；目标目录可能不完整，可重新执行回滚: %v", cause, err)
+			finish(StatusAborted, err)
+			a.publish(id, "warn", "%v", err)
 			return
 		}
-		a.waitAfterServiceStop(id, "清理目标目录", 40, dep.ServiceName)
-	} else {
-		a.publish(id, "warn", "service_name 为空，跳过停止服务，直接回滚文件")
-	}
-
-	a.publishProgress(id, "info", "清理目标目录", 50, "清理目标目录（保留忽略项）")
-	if err := a.runFileOpWithRetry(id, "清理目标目录", 50, "清理目标目录", func() error {
-		return clearDirWithIgnore(dep.TargetDir, replaceIgnore)
-	}); err != nil {
-		if serviceManaged {
-			_ = startService(dep.ServiceName, 45*time.Second)
-		}
-		finish("failed", fmt.Errorf("清理目标目录失败: %w", err))
-		a.publish(id, "error", "清理目标目录失败: %v", err)
+		finish("failed", err)
+		a.publish(id, "error", "%v", err)
 		return
 	}
 
-	a.publishProgress(id, "info", "恢复备份包", 70, "恢复备份包: %s", dep.BackupFile)
-	if err := a.runFileOpWithRetry(id, "恢复备份包", 70, "恢复备份包", func() error {
-		return extractZip(dep.BackupFile, dep.TargetDir)
-	}); err != nil {
-		if serviceManaged {
-			if restartErr := startService(dep.ServiceName, 45*time.Second); restartErr != nil {
-				err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
-			}
-		}
-		finish("failed", fmt.Errorf("恢复备份失败: %w", err))
-		a.publish(id, "error", "恢复备份失败: %v", err)
-		return
-	}
-
-	if serviceManaged {
-		a.publishProgress(id, "info", "启动服务", 90, "启动服务: %s", dep.ServiceName)
-		if err := startService(dep.ServiceName, 45*time.Second); err != nil {
-			finish("failed", fmt.Errorf("启动服务失败: %w", err))
-			a.publish(id, "error", "启动服务失败: %v", err)
-			return
-		}
-	} else {
-		a.publish(id, "warn", "service_name 为空，跳过启动服务")
-	}
-
-	if source.Version != "" {
+	// 单个实例回滚后其他实例仍运行源部署的版本，不改写程序当前版本。
+	if setVersion && source.Version != "" && source.ParentID == "" {
 		projectID := source.ProjectID
 		if projectID == "" {
 			projectID = dep.ProjectID
@@ -452,6 +716,119 @@ func (a *App) runRollback(id, sourceID, projectID string) {
 	a.publishProgress(id, "info", "回滚完成", 100, "回滚完成，耗时 %d ms", time.Since(start).Milliseconds())
 }
 
+// autoRollbackDeployment 在部署替换文件之后失败时创建一条回滚记录（rollback_of 指向失败的部署），
+// 立即恢复本次部署前的备份并启动原版本。调用方已持有程序任务锁；返回恢复结果（AutoRestore*）与写入失败部署记录的错误。
+func (a *App) autoRollbackDeployment(id string, cause error) (string, error) {
+	source, ok := a.store.Get(id)
+	if !ok || source.BackupFile == "" {
+		return AutoRestoreSkipped, fmt.Errorf("%v；没有可用备份，未自动回滚", cause)
+	}
+	rbID := newID("rb")
+	rollback := newRollbackDeployment(rbID, source, source.ProjectID)
+	rollback.Note = fmt.Sprintf("部署 %s 失败后自动回滚", id)
+	rollback.LoginIP = source.LoginIP
+	rollback.Operator = source.Operator
+	rollback.TokenID = source.TokenID
+	rollback.TokenName = source.TokenName
+	if err := a.store.Add(rollback); err != nil {
+		a.publish(id, "error", "创建自动回滚记录失败: %v", err)
+		return AutoRestoreFailed, fmt.Errorf("%v；创建自动回滚记录失败: %v", cause, err)
+	}
+	a.publish(id, "warn", "部署失败，自动回滚到部署前版本，回滚记录: %s", rbID)
+	a.executeRollback(rbID, id, false)
+	a.notifyDeploymentIfNeeded(rbID)
+	if rb, _ := a.store.Get(rbID); rb.Status != "success" {
+		a.publish(id, "error", "自动回滚失败（%s）: %s", rbID, rb.Error)
+		return AutoRestoreFailed, fmt.Errorf("%v；自动回滚失败（%s）: %s", cause, rbID, rb.Error)
+	}
+	a.publish(id, "warn", "已自动回滚到部署前版本（%s）", rbID)
+	return AutoRestoreSuccess, fmt.Errorf("%v；已自动回滚（%s）", cause, rbID)
+}
+
+// backupRestore 描述一次从备份包恢复目标目录的操作。
+type backupRestore struct {
+	ServiceName   string
+	TargetDir     string
+	BackupFile    string
+	ReplaceIgnore *IgnoreMatcher
+	// PreviousDir 为目录切换部署保留的上一版本目录，存在时直接切换回去，不再解压备份包。
+	PreviousDir string
+	Journal     *deploymentJournal
+}
+
+// restoreBackup 停止服务、清理目标目录（保留忽略项）、解压备份包并重新启动服务；
+// 手动回滚与健康检查失败后的自动恢复共用此流程。progress 把 0-100 的步骤进度映射到调用方的进度区间。
+// ctx 取消时停止后续步骤并尽量重新启动服务，目标目录可能停留在部分恢复的状态。
+func (a *App) restoreBackup(ctx context.Context, id string, rs backupRestore, progress func(int) int) error {
+	serviceManaged := rs.ServiceName != ""
+	if serviceManaged {
+		a.publishProgress(id, "info", "停止服务", progress(30), "停止服务: %s", rs.ServiceName)
+		if err := stopService(ctx, rs.ServiceName, 45*time.Second); err != nil {
+			if ctx.Err() != nil {
+				_ = startService(context.Background(), rs.ServiceName, 45*time.Second)
+			}
+			return fmt.Errorf("停止服务失败: %w", err)
+		}
+		rs.Journal.Record(JournalEntry{Stage: JournalServiceStopped, ServiceName: rs.ServiceName})
+		a.waitAfterServiceStop(ctx, id, "清理目标目录", progress(40), rs.ServiceName)
+	} else {
+		a.publish(id, "warn", "service_name 为空，跳过停止服务，直接回滚文件")
+	}
+	if err := ctx.Err(); err != nil {
+		if serviceManaged {
+			_ = startService(context.Background(), rs.ServiceName, 45*time.Second)
+		}
+		return err
+	}
+
+	rs.Journal.Record(JournalEntry{Stage: JournalRestoreStarted, BackupFile: rs.BackupFile, PreviousDir: rs.PreviousDir})
+	if rs.PreviousDir != "" && isExistingDir(rs.PreviousDir) {
+		a.publishProgress(id, "info", "切换目录", progress(60), "切换回上一版本目录: %s", rs.PreviousDir)
+		if err := a.swapBackPreviousDir(ctx, id, rs.TargetDir, rs.PreviousDir, rs.ReplaceIgnore, progress(60)); err != nil {
+			if serviceManaged {
+				if restartErr := startService(context.Background(), rs.ServiceName, 45*time.Second); restartErr != nil {
+					err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+				}
+			}
+			return fmt.Errorf("切换回上一版本目录失败: %w", err)
+		}
+	} else {
+		a.publishProgress(id, "info", "清理目标目录", progress(50), "清理目标目录（保留忽略项）")
+		if err := a.runFileOpWithRetry(ctx, id, "清理目标目录", progress(50), "清理目标目录", func() error {
+			return clearDirWithIgnore(rs.TargetDir, rs.ReplaceIgnore)
+		}); err != nil {
+			if serviceManaged {
+				_ = startService(context.Background(), rs.ServiceName, 45*time.Second)
+			}
+			return fmt.Errorf("清理目标目录失败: %w", err)
+		}
+
+		a.publishProgress(id, "info", "恢复备份包", progress(70), "恢复备份包: %s", rs.BackupFile)
+		if err := a.runFileOpWithRetry(ctx, id, "恢复备份包", progress(70), "恢复备份包", func() error {
+			return extractZip(rs.BackupFile, rs.TargetDir)
+		}); err != nil {
+			if serviceManaged {
+				if restartErr := startService(context.Background(), rs.ServiceName, 45*time.Second); restartErr != nil {
+					err = fmt.Errorf("%v; 尝试恢复启动服务失败: %v", err, restartErr)
+				}
+			}
+			return fmt.Errorf("恢复备份失败: %w", err)
+		}
+	}
+
+	rs.Journal.Record(JournalEntry{Stage: JournalRestoreDone})
+	if serviceManaged {
+		a.publishProgress(id, "info", "启动服务", progress(90), "启动服务: %s", rs.ServiceName)
+		if err := startService(ctx, rs.ServiceName, 45*time.Second); err != nil {
+			return fmt.Errorf("启动服务失败: %w", err)
+		}
+		rs.Journal.Record(JournalEntry{Stage: JournalServiceStarted, ServiceName: rs.ServiceName})
+	} else {
+		a.publish(id, "warn", "service_name 为空，跳过启动服务")
+	}
+	return nil
+}
+
 func (a *App) runSelfUpdate(id string) {
 	defer a.releaseSelfTask()
 	defer func() {
@@ -643,9 +1020,9 @@ func resolveSelfUpdateServiceName(cfg Config) string {
 	return ""
 }
 
-func (a *App) waitAfterServiceStop(depID, stage string, progress int, serviceName string) {
+func (a *App) waitAfterServiceStop(ctx context.Context, depID, stage string, progress int, serviceName string) {
 	a.publishProgress(depID, "info", stage, progress, "服务已停止，等待 %.1f 秒释放文件句柄: %s", postStopSettleDelay.Seconds(), serviceName)
-	time.Sleep(postStopSettleDelay)
+	_ = sleepContext(ctx, postStopSettleDelay)
 }
 
 func isTargetInitialDeploy(targetExists, targetEmpty bool) bool {
@@ -708,9 +1085,13 @@ func buildServiceInstallConfig(cfg Config, dep Deployment) (ServiceInstallConfig
 	}, nil
 }
 
-func (a *App) runFileOpWithRetry(depID, stage string, progress int, opName string, fn func() error) error {
+// runFileOpWithRetry 重试文件操作以等待文件句柄释放；ctx 取消时不再重试。
+func (a *App) runFileOpWithRetry(ctx context.Context, depID, stage string, progress int, opName string, fn func() error) error {
 	var lastErr error
 	for attempt := 1; attempt <= fileOpRetryTimes; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		lastErr = fn()
 		if lastErr == nil {
 			if attempt > 1 {
@@ -719,8 +1100,13 @@ func (a *App) runFileOpWithRetry(depID, stage string, progress int, opName strin
 			return nil
 		}
 		if attempt < fileOpRetryTimes {
+			if ctx.Err() != nil {
+				return lastErr
+			}
 			a.publish(depID, "warn", "%s失败（第 %d/%d 次）: %v；%dms 后重试", opName, attempt, fileOpRetryTimes, lastErr, fileOpRetryDelay.Milliseconds())
-			time.Sleep(fileOpRetryDelay)
+			if err := sleepContext(ctx, fileOpRetryDelay); err != nil {
+				return lastErr
+			}
 		}
 	}
 	return fmt.Errorf("%s重试 %d 次后仍失败: %w", opName, fileOpRetryTimes, lastErr)
@@ -2,7 +2,10 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash/crc32"
@@ -126,23 +129,26 @@ func (m *IgnoreMatcher) ShouldIgnore(rel string, isDir bool) bool {
 	return false
 }
 
-func zipDirectory(srcDir, dstZip string, ignore *IgnoreMatcher) error {
+// zipDirectory 打包目录并在写入的同时计算压缩包的 SHA-256；ctx 取消时中止打包。
+func zipDirectory(ctx context.Context, srcDir, dstZip string, ignore *IgnoreMatcher) (string, error) {
 	if err := os.MkdirAll(filepath.Dir(dstZip), 0755); err != nil {
-		return err
+		return "", err
 	}
 	f, err := os.Create(dstZip)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
 
-	zw := zip.NewWriter(f)
-	defer zw.Close()
-
-	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
+	h := sha256.New()
+	zw := zip.NewWriter(io.MultiWriter(f, h))
+	walkErr := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if path == srcDir {
 			return nil
 		}
@@ -182,6 +188,14 @@ func zipDirectory(srcDir, dstZip string, ignore *IgnoreMatcher) error {
 		_, err = io.Copy(w, src)
 		return err
 	})
+	if walkErr != nil {
+		_ = zw.Close()
+		return "", walkErr
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func extractZip(srcZip, dstDir string) error {
@@ -236,7 +250,9 @@ func extractZip(srcZip, dstDir string) error {
 	return nil
 }
 
-func syncDirectories(src, target string, ignore *IgnoreMatcher, removeMissing bool) ([]ChangedFile, error) {
+// syncDirectories 把 src 同步到 target；onFile 非空时每处理完一个源文件回调一次（已处理数/总数）。
+// ctx 取消时在处理下一个文件前返回，已复制的文件保持原样。
+func syncDirectories(ctx context.Context, src, target string, ignore *IgnoreMatcher, removeMissing bool, onFile func(done, total int)) ([]ChangedFile, error) {
 	type srcFile struct {
 		abs  string
 		size int64
@@ -330,7 +346,13 @@ func syncDirectories(src, target string, ignore *IgnoreMatcher, removeMissing bo
 	}
 	sort.Strings(keys)
 
-	for _, rel := range keys {
+	for i, rel := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if onFile != nil && i > 0 {
+			onFile(i, len(keys))
+		}
 		sf := sourceFiles[rel]
 		dst := filepath.Join(target, filepath.FromSlash(rel))
 		exists := true
@@ -358,6 +380,9 @@ func syncDirectories(src, target string, ignore *IgnoreMatcher, removeMissing bo
 		changes = append(changes, ChangedFile{Path: rel, Action: action, Size: sf.size})
 	}
 
+	if onFile != nil {
+		onFile(len(keys), len(keys))
+	}
 	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
 	return changes, nil
 }
@@ -677,17 +702,52 @@ func clearDirWithIgnore(target string, ignore *IgnoreMatcher) error {
 	return nil
 }
 
-func saveMultipartFile(src multipart.File, dst string) error {
+// saveMultipartFile 保存上传文件并在写入的同时计算 SHA-256，避免落盘后再完整读取一遍。
+func saveMultipartFile(src multipart.File, dst string) (string, error) {
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		return err
+		return "", err
 	}
 	f, err := os.Create(dst)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer f.Close()
-	_, err = io.Copy(f, src)
-	return err
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkExpectedSHA256 比对客户端提供的 expected_sha256；未提供时跳过。
+func checkExpectedSHA256(expected, actual string) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	if expected == "" {
+		return nil
+	}
+	if len(expected) != sha256.Size*2 {
+		return fmt.Errorf("expected_sha256 格式错误，应为 64 位十六进制: %s", expected)
+	}
+	if _, err := hex.DecodeString(expected); err != nil {
+		return fmt.Errorf("expected_sha256 格式错误，应为 64 位十六进制: %s", expected)
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return fmt.Errorf("文件校验失败，上传内容可能损坏或被篡改: expected_sha256=%s，实际 %s", expected, actual)
+	}
+	return nil
 }
 
 func copyFile(src, dst string) error {
@@ -699,7 +759,12 @@ func copyFile(src, dst string) error {
 		return err
 	}
 	defer in.Close()
-	out, err := os.Create(dst)
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// 新建的文件沿用源文件的权限（如可执行位）；已存在的文件截断后保留原权限。
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
@@ -4,4 +4,7 @@ go 1.23.0
 
 toolchain go1.24.6
 
-require golang.org/x/sys v0.31.0
+require (
+	golang.org/x/crypto v0.36.0
+	golang.org/x/sys v0.31.0
+)
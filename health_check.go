@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	HealthCheckHTTP    = "http"
+	HealthCheckTCP     = "tcp"
+	HealthCheckCommand = "command"
+
+	defaultHealthInitialDelaySec = 5
+	defaultHealthIntervalSec     = 3
+	defaultHealthTimeoutSec      = 5
+	defaultHealthRetries         = 10
+	defaultHealthDeadlineSec     = 120
+	maxHealthDeadlineSec         = 1800
+	healthBodyReadLimit          = 64 * 1024
+)
+
+const (
+	HealthStatusPassed = "passed"
+	HealthStatusFailed = "failed"
+
+	AutoRestoreSuccess = "success"
+	AutoRestoreFailed  = "failed"
+	AutoRestoreSkipped = "skipped"
+)
+
+// normalizeHealthCheck 校验健康检查配置并补全默认值；type 为空表示不检查，返回 nil。
+func normalizeHealthCheck(hc *ProjectHealthCheck) (*ProjectHealthCheck, error) {
+	if hc == nil {
+		return nil, nil
+	}
+	out := *hc
+	out.Type = strings.ToLower(strings.TrimSpace(out.Type))
+	out.URL = strings.TrimSpace(out.URL)
+	out.Address = strings.TrimSpace(out.Address)
+	out.Command = strings.TrimSpace(out.Command)
+	switch out.Type {
+	case "":
+		return nil, nil
+	case HealthCheckHTTP:
+		if !strings.HasPrefix(out.URL, "http://") && !strings.HasPrefix(out.URL, "https://") {
+			return nil, errors.New("health_check.url 必须以 http:// 或 https:// 开头")
+		}
+		if out.ExpectStatus != 0 && (out.ExpectStatus < 100 || out.ExpectStatus > 599) {
+			return nil, errors.New("health_check.expect_status 无效（0 表示任意 2xx）")
+		}
+	case HealthCheckTCP:
+		if _, _, err := net.SplitHostPort(out.Address); err != nil {
+			return nil, fmt.Errorf("health_check.address 格式错误，应为 host:port: %v", err)
+		}
+	case HealthCheckCommand:
+		if out.Command == "" {
+			return nil, errors.New("health_check.command 不能为空")
+		}
+	default:
+		return nil, fmt.Errorf("health_check.type 无效: %s，可选 http / tcp / command", out.Type)
+	}
+	if out.InitialDelaySec < 0 || out.IntervalSec < 0 || out.TimeoutSec < 0 || out.Retries < 0 || out.DeadlineSec < 0 {
+		return nil, errors.New("health_check 的时间与次数不能为负数")
+	}
+	if out.DeadlineSec > maxHealthDeadlineSec {
+		return nil, fmt.Errorf("health_check.deadline_sec 不能超过 %d", maxHealthDeadlineSec)
+	}
+	return &out, nil
+}
+
+// runHealthCheck 在服务启动后反复探测，直到一次成功、用完重试次数、超过截止时间或 ctx 取消。
+func (a *App) runHealthCheck(ctx context.Context, depID string, check ProjectHealthCheck, progress int, targetDir string) error {
+	initialDelay := durationOrDefault(check.InitialDelaySec, defaultHealthInitialDelaySec)
+	interval := durationOrDefault(check.IntervalSec, defaultHealthIntervalSec)
+	timeout := durationOrDefault(check.TimeoutSec, defaultHealthTimeoutSec)
+	retries := check.Retries
+	if retries <= 0 {
+		retries = defaultHealthRetries
+	}
+	deadline := time.Now().Add(durationOrDefault(check.DeadlineSec, defaultHealthDeadlineSec))
+
+	a.publishProgress(depID, "info", "健康检查", progress, "开始健康检查（%s %s），%s 后首次探测，最多 %d 次", check.Type, check.target(), initialDelay, retries)
+	if err := sleepContext(ctx, initialDelay); err != nil {
+		return err
+	}
+	var lastErr error
+	for attempt := 1; attempt <= retries; attempt++ {
+		lastErr = probeHealth(check, timeout, targetDir)
+		if lastErr == nil {
+			a.publishProgress(depID, "info", "健康检查", progress, "健康检查通过（第 %d 次）", attempt)
+			return nil
+		}
+		a.publish(depID, "warn", "健康检查未通过（第 %d/%d 次）: %v", attempt, retries, lastErr)
+		if attempt == retries {
+			break
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("健康检查在截止时间内未通过（已探测 %d 次）: %w", attempt, lastErr)
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("健康检查 %d 次均未通过: %w", retries, lastErr)
+}
+
+// restoreAfterHealthFailure 在健康检查未通过后恢复本次部署前的备份，记录恢复结果并返回写入部署记录的错误。
+func (a *App) restoreAfterHealthFailure(id string, rs backupRestore, healthErr error) error {
+	if rs.BackupFile == "" {
+		_ = a.store.UpdateField(id, func(d *Deployment) { d.AutoRestore = AutoRestoreSkipped })
+		a.publish(id, "warn", "本次为首次部署，没有可恢复的备份，请人工处理")
+		return fmt.Errorf("%v；首次部署无备份，未自动恢复", healthErr)
+	}
+	a.publishProgress(id, "warn", "自动恢复", 96, "健康检查未通过，自动恢复部署前备份: %s", rs.BackupFile)
+	err := a.restoreBackup(context.Background(), id, rs, func(p int) int { return 96 + p*3/100 })
+	if err != nil {
+		_ = a.store.UpdateField(id, func(d *Deployment) {
+			d.AutoRestore = AutoRestoreFailed
+			d.AutoRestoreError = err.Error()
+		})
+		a.publish(id, "error", "自动恢复失败: %v", err)
+		return fmt.Errorf("%v；自动恢复失败: %v", healthErr, err)
+	}
+	_ = a.store.UpdateField(id, func(d *Deployment) { d.AutoRestore = AutoRestoreSuccess })
+	a.publishProgress(id, "warn", "自动恢复", 99, "已自动恢复到部署前版本")
+	return fmt.Errorf("%v；已自动恢复到部署前版本", healthErr)
+}
+
+func (c ProjectHealthCheck) target() string {
+	switch c.Type {
+	case HealthCheckHTTP:
+		return c.URL
+	case HealthCheckTCP:
+		return c.Address
+	default:
+		return c.Command
+	}
+}
+
+func probeHealth(check ProjectHealthCheck, timeout time.Duration, targetDir string) error {
+	switch check.Type {
+	case HealthCheckHTTP:
+		return probeHTTP(check, timeout)
+	case HealthCheckTCP:
+		conn, err := net.DialTimeout("tcp", check.Address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case HealthCheckCommand:
+		return probeCommand(check.Command, timeout, targetDir)
+	default:
+		return fmt.Errorf("未知的健康检查类型: %s", check.Type)
+	}
+}
+
+func probeHTTP(check ProjectHealthCheck, timeout time.Duration) error {
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// 健康检查通常访问本机服务的自签名证书，只关心服务是否存活。
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(check.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, healthBodyReadLimit))
+	if check.ExpectStatus != 0 {
+		if resp.StatusCode != check.ExpectStatus {
+			return fmt.Errorf("HTTP 状态码 %d，期望 %d", resp.StatusCode, check.ExpectStatus)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP 状态码 %d，期望 2xx", resp.StatusCode)
+	}
+	if check.ExpectBody != "" && !strings.Contains(string(body), check.ExpectBody) {
+		return fmt.Errorf("响应内容不包含 %q", check.ExpectBody)
+	}
+	return nil
+}
+
+func probeCommand(command string, timeout time.Duration, targetDir string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := hookShellCommand(ctx, command)
+	if info, err := os.Stat(targetDir); err == nil && info.IsDir() {
+		cmd.Dir = targetDir
+	}
+	cmd.WaitDelay = hookWaitDelay
+	out, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("命令超时（%s）", timeout)
+	}
+	if err != nil {
+		text := strings.TrimSpace(string(out))
+		if r := []rune(text); len(r) > 200 {
+			text = string(r[:200]) + "…"
+		}
+		if text != "" {
+			return fmt.Errorf("%v: %s", err, text)
+		}
+		return err
+	}
+	return nil
+}
+
+func durationOrDefault(sec, def int) time.Duration {
+	if sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
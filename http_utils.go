@@ -9,13 +9,82 @@ import (
 	"strings"
 )
 
-func clientIP(r *http.Request) string {
-	if xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For")); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
+// resolveClientIP 只有在直连对端属于受信代理时才采信转发头，按 Forwarded、X-Forwarded-For、
+// X-Real-IP 的优先级取代理链，从右向左跳过受信代理，返回第一个不受信的地址，避免客户端伪造来源 IP。
+func resolveClientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer := remoteHost(r)
+	if !ipInNets(peer, trusted) {
+		return peer
+	}
+	hops := forwardedHops(r.Header)
+	candidate := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		candidate = hop
+		if !ipInNets(hop, trusted) {
+			return hop
+		}
+	}
+	return candidate
+}
+
+// forwardedHops 返回代理链中的客户端地址列表（从左到右为由远及近），解析不出的节点原样保留以便截断。
+func forwardedHops(h http.Header) []string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		hops := make([]string, 0)
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(k), "for") {
+					hop = normalizeForwardedNode(v)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+	if xff := strings.TrimSpace(strings.Join(h.Values("X-Forwarded-For"), ",")); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := range hops {
+			hops[i] = normalizeForwardedNode(hops[i])
 		}
+		return hops
 	}
+	if realIP := strings.TrimSpace(h.Get("X-Real-IP")); realIP != "" {
+		return []string{normalizeForwardedNode(realIP)}
+	}
+	return nil
+}
+
+// normalizeForwardedNode 去掉引号、IPv6 方括号与端口，如 "[2001:db8::1]:443" -> 2001:db8::1。
+func normalizeForwardedNode(v string) string {
+	v = strings.Trim(strings.TrimSpace(v), `"`)
+	if strings.HasPrefix(v, "[") {
+		if end := strings.Index(v, "]"); end > 0 {
+			return v[1:end]
+		}
+		return v
+	}
+	if net.ParseIP(v) != nil {
+		return v
+	}
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		return host
+	}
+	return v
+}
+
+// clientIP 按当前配置的受信代理解析请求来源 IP，部署记录、日志、会话、登录锁定与令牌记录统一使用该值。
+func (a *App) clientIP(r *http.Request) string {
+	trusted, _ := parseTrustedProxies(a.currentConfig().TrustedProxies)
+	return resolveClientIP(r, trusted)
+}
+
+func remoteHost(r *http.Request) string {
 	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
 	if err != nil {
 		return strings.TrimSpace(r.RemoteAddr)
@@ -23,6 +92,49 @@ func clientIP(r *http.Request) string {
 	return host
 }
 
+func ipInNets(raw string, nets []*net.IPNet) bool {
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies 解析受信代理列表，支持单个 IP 与 CIDR。
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	out := make([]*net.IPNet, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的受信代理地址: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的受信代理 CIDR: %s", item)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
 func writeJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(code)
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	loginFailureForgetAfter = 24 * time.Hour
+	loginGlobalWindow       = 10 * time.Minute
+)
+
+type loginFailureState struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// loginGuard 记录登录失败次数：单个 IP 超过阈值后按指数退避锁定，全局失败过多时暂停所有密码登录。
+// 状态仅保存在内存中，重启后清零。
+type loginGuard struct {
+	mu             sync.Mutex
+	ips            map[string]*loginFailureState
+	globalFailures []time.Time
+	globalLocked   time.Time
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{ips: make(map[string]*loginFailureState)}
+}
+
+type loginGuardPolicy struct {
+	MaxFailures       int
+	BaseLockout       time.Duration
+	MaxLockout        time.Duration
+	GlobalMaxFailures int
+}
+
+func loginPolicyFromConfig(cfg Config) loginGuardPolicy {
+	p := loginGuardPolicy{
+		MaxFailures:       cfg.LoginMaxFailures,
+		BaseLockout:       time.Duration(cfg.LoginLockoutMinutes) * time.Minute,
+		MaxLockout:        time.Duration(cfg.LoginMaxLockoutMins) * time.Minute,
+		GlobalMaxFailures: cfg.LoginGlobalMaxFails,
+	}
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = 5
+	}
+	if p.BaseLockout <= 0 {
+		p.BaseLockout = time.Minute
+	}
+	if p.MaxLockout < p.BaseLockout {
+		p.MaxLockout = p.BaseLockout
+	}
+	return p
+}
+
+// Check 返回该 IP 当前是否允许尝试登录，以及不允许时需要等待的时长。
+func (g *loginGuard) Check(ip string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	if now.Before(g.globalLocked) {
+		return g.globalLocked.Sub(now), false
+	}
+	if st, ok := g.ips[ip]; ok && now.Before(st.LockedUntil) {
+		return st.LockedUntil.Sub(now), false
+	}
+	return 0, true
+}
+
+// Fail 记录一次失败；若本次失败触发了新的锁定，返回锁定描述供调用方记录日志和发送通知。
+func (g *loginGuard) Fail(ip string, p loginGuardPolicy) (string, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+
+	for k, v := range g.ips {
+		if now.Sub(v.LastFailure) > loginFailureForgetAfter && now.After(v.LockedUntil) {
+			delete(g.ips, k)
+		}
+	}
+	st, ok := g.ips[ip]
+	if !ok {
+		st = &loginFailureState{}
+		g.ips[ip] = st
+	}
+	st.Failures++
+	st.LastFailure = now
+
+	var events []string
+	if st.Failures >= p.MaxFailures {
+		lockout := p.BaseLockout
+		for i := p.MaxFailures; i < st.Failures && lockout < p.MaxLockout; i++ {
+			lockout *= 2
+		}
+		if lockout > p.MaxLockout {
+			lockout = p.MaxLockout
+		}
+		st.LockedUntil = now.Add(lockout)
+		events = append(events, fmt.Sprintf("IP %s 连续登录失败 %d 次，锁定 %s", ip, st.Failures, lockout))
+	}
+
+	if p.GlobalMaxFailures > 0 {
+		cutoff := now.Add(-loginGlobalWindow)
+		kept := g.globalFailures[:0]
+		for _, t := range g.globalFailures {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		g.globalFailures = append(kept, now)
+		if len(g.globalFailures) >= p.GlobalMaxFailures && !now.Before(g.globalLocked) {
+			g.globalLocked = now.Add(p.BaseLockout)
+			g.globalFailures = g.globalFailures[:0]
+			events = append(events, fmt.Sprintf("%s 内全局登录失败达到 %d 次，暂停所有密码登录 %s", loginGlobalWindow, p.GlobalMaxFailures, p.BaseLockout))
+		}
+	}
+	if len(events) == 0 {
+		return "", false
+	}
+	msg := events[0]
+	for _, e := range events[1:] {
+		msg += "；" + e
+	}
+	return msg, true
+}
+
+func (g *loginGuard) Succeed(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.ips, ip)
+}
+
+// recordLoginFailure 记录失败并在触发锁定时写日志、发送通知邮件。
+func (a *App) recordLoginFailure(ip, username, reason string) {
+	cfg := a.currentConfig()
+	msg, locked := a.loginGuard.Fail(ip, loginPolicyFromConfig(cfg))
+	if !locked {
+		return
+	}
+	a.logger.Warn("登录已锁定", "ip", ip, "username", username, "reason", reason, "detail", msg)
+	if cfg.NotifyEmail == "" || cfg.NotifyEmailAuthCode == "" {
+		return
+	}
+	go func() {
+		subject := "[SimpleRemoteUpdate] 登录锁定告警"
+		body := fmt.Sprintf("时间: %s\n来源 IP: %s\n尝试用户名: %s\n原因: %s\n详情: %s\n",
+			time.Now().Format("2006-01-02 15:04:05"), ip, username, reason, msg)
+		if err := sendNotifyEmail(cfg.NotifyEmail, cfg.NotifyEmailAuthCode, subject, body); err != nil {
+			a.logger.Warn("登录锁定通知邮件发送失败", "error", err)
+		}
+	}()
+}
+
+// checkCurrentPassword 校验已登录用户输入的当前密码（修改密码、停用两步验证），与密码登录共用失败计数与锁定，
+// 避免绕过登录防爆破在线猜测密码。校验未通过时返回应答状态码与错误。
+func (a *App) checkCurrentPassword(r *http.Request, user UserAccount, password, reason string) (int, error) {
+	ip := a.clientIP(r)
+	if wait, ok := a.loginGuard.Check(ip); !ok {
+		a.logger.Warn("当前密码校验被锁定拒绝", "username", user.Username, "ip", ip, "reason", reason)
+		return http.StatusTooManyRequests, fmt.Errorf("密码错误次数过多，请 %s 后再试", formatLockoutWait(wait))
+	}
+	ok, err := verifyPassword(user.PasswordHash, password)
+	if err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	if !ok {
+		a.logger.Warn("当前密码校验失败", "username", user.Username, "ip", ip, "reason", reason)
+		a.recordLoginFailure(ip, user.Username, reason)
+		return http.StatusBadRequest, errors.New("当前密码错误")
+	}
+	return http.StatusOK, nil
+}
+
+func formatLockoutWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d 秒", int(d.Seconds())+1)
+	}
+	return fmt.Sprintf("%d 分钟", int(d.Minutes())+1)
+}
@@ -21,6 +21,13 @@ import (
 )
 
 func main() {
+	if handled, err := tryRunSignPackage(os.Args[1:]); handled {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sign-package failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	handled, err := tryRunSelfUpdateWorker(os.Args[1:])
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "self-update worker failed: %v\n", err)
@@ -50,14 +57,38 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(logWriter, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	}))
-	if isDefaultAuthHash(cfg.AuthKeySHA256) {
-		logger.Warn("当前仍在使用默认密钥哈希，请尽快修改 config.json")
-	}
 
 	store, err := newDeploymentStore(cfg.DeploymentsFile)
 	if err != nil {
 		panic(err)
 	}
+	releases, err := newReleaseStore(cfg.ReleasesFile)
+	if err != nil {
+		panic(err)
+	}
+	sessions, err := newSessionManager(cfg.SessionsFile, sessionIdleTimeout(cfg))
+	if err != nil {
+		panic(err)
+	}
+	users, err := newUserStore(cfg.UsersFile, cfg.AuthKeySHA256)
+	if err != nil {
+		panic(err)
+	}
+	tokens, err := newAPITokenStore(cfg.TokensFile)
+	if err != nil {
+		panic(err)
+	}
+	audit, err := newAuditLog(cfg.AuditFile)
+	if err != nil {
+		panic(err)
+	}
+	if users.HasDefaultPassword() {
+		logger.Warn("当前仍有用户使用默认密码，请尽快在控制台修改")
+	}
+	tlsReloader, err := setupTLS(cfg, logger)
+	if err != nil {
+		panic(err)
+	}
 	tmpl, err := parseTemplates()
 	if err != nil {
 		panic(err)
@@ -74,20 +105,34 @@ func main() {
 		logger:      logger,
 		templates:   tmpl,
 		store:       store,
-		sessions:    newSessionManager(),
+		releases:    releases,
+		sessions:    sessions,
+		users:       users,
+		tokens:      tokens,
+		audit:       audit,
+		challenges:  newLoginChallengeStore(),
+		oidc:        newOIDCProvider(),
+		loginGuard:  newLoginGuard(),
+		tls:         tlsReloader,
 		events:      newEventHub(),
 		static:      http.FileServer(http.FS(staticFS)),
 		projectTask: make(map[string]struct{}),
 		schedCancel: make(map[string]func()),
+		runCancel:   make(map[string]context.CancelCauseFunc),
+		queueHold:   make(map[string]int),
 	}
+	app.recoverInterruptedTasks()
+	app.recoverInterruptedReleases()
+	app.resumeQueuedDeployments()
 	app.resumeScheduledDeployments()
 
 	logger.Info("updater server started",
 		"addr", cfg.ListenAddr,
+		"tls", cfg.TLSEnabled,
 		"default_project", cfg.DefaultProjectID,
 		"projects", len(cfg.Projects),
 	)
-	if err := http.ListenAndServe(cfg.ListenAddr, app.routes()); err != nil {
+	if err := app.serve(cfg); err != nil {
 		panic(err)
 	}
 }
@@ -96,64 +141,145 @@ func (a *App) routes() http.Handler {
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.StripPrefix("/static/", a.static))
 	mux.HandleFunc("/login", a.handleLogin)
-	mux.HandleFunc("/logout", a.requireAuth(a.handleLogout))
-	mux.HandleFunc("/", a.requireAuth(a.handleIndex))
-	mux.HandleFunc("/initial-deploy", a.requireAuth(a.handleInitialDeployPage))
-	mux.HandleFunc("/partials/deployments", a.requireAuth(a.handleDeploymentsPartial))
-	mux.HandleFunc("/partials/deployments/rows", a.requireAuth(a.handleDeploymentsRows))
-	mux.HandleFunc("/api/upload", a.requireAuth(a.handleUpload))
-	mux.HandleFunc("/api/preview", a.requireAuth(a.handlePreview))
-	mux.HandleFunc("/api/self-update", a.requireAuth(a.handleSelfUpdate))
-	mux.HandleFunc("/api/config", a.requireAuth(a.handleConfigAPI))
-	mux.HandleFunc("/api/notify/test", a.requireAuth(a.handleNotifyTestAPI))
-	mux.HandleFunc("/api/projects", a.requireAuth(a.handleProjectsAPI))
-	mux.HandleFunc("/api/projects/", a.requireAuth(a.handleProjectItemAPI))
-	mux.HandleFunc("/api/deployments/", a.requireAuth(a.handleDeploymentAPIs))
+	mux.HandleFunc(oidcLoginPath, a.handleOIDCLogin)
+	mux.HandleFunc(oidcCallbackPath, a.handleOIDCCallback)
+	mux.HandleFunc("/logout", a.requireAuth(RoleViewer, RoleViewer, a.handleLogout))
+	mux.HandleFunc("/", a.requireAuth(RoleViewer, RoleViewer, a.handleIndex))
+	mux.HandleFunc("/initial-deploy", a.requireAuth(RoleViewer, RoleViewer, a.handleInitialDeployPage))
+	mux.HandleFunc("/partials/deployments", a.requireAuth(RoleViewer, RoleViewer, a.handleDeploymentsPartial))
+	mux.HandleFunc("/partials/deployments/rows", a.requireAuth(RoleViewer, RoleViewer, a.handleDeploymentsRows))
+	mux.HandleFunc("/api/upload", a.requireAuth(RoleOperator, RoleOperator, a.handleUpload))
+	mux.HandleFunc("/api/preview", a.requireAuth(RoleOperator, RoleOperator, a.handlePreview))
+	mux.HandleFunc("/api/self-update", a.requireAuth(RoleAdmin, RoleAdmin, a.handleSelfUpdate))
+	mux.HandleFunc("/api/config", a.requireAuth(RoleViewer, RoleOperator, a.handleConfigAPI))
+	mux.HandleFunc("/api/notify/test", a.requireAuth(RoleAdmin, RoleAdmin, a.handleNotifyTestAPI))
+	mux.HandleFunc("/api/projects", a.requireAuth(RoleAdmin, RoleAdmin, a.handleProjectsAPI))
+	mux.HandleFunc("/api/projects/", a.requireAuth(RoleAdmin, RoleAdmin, a.handleProjectItemAPI))
+	mux.HandleFunc("/api/deployments/", a.requireAuth(RoleViewer, RoleOperator, a.handleDeploymentAPIs))
+	mux.HandleFunc("/partials/releases", a.requireAuth(RoleViewer, RoleViewer, a.handleReleasesPartial))
+	mux.HandleFunc("/api/releases", a.requireAuth(RoleViewer, RoleOperator, a.handleReleasesAPI))
+	mux.HandleFunc("/api/releases/", a.requireAuth(RoleViewer, RoleOperator, a.handleReleaseItemAPI))
+	mux.HandleFunc("/api/users", a.requireAuth(RoleAdmin, RoleAdmin, a.handleUsersAPI))
+	mux.HandleFunc("/api/users/", a.requireAuth(RoleAdmin, RoleAdmin, a.handleUserItemAPI))
+	mux.HandleFunc("/api/tokens", a.requireAuth(RoleAdmin, RoleAdmin, a.handleTokensAPI))
+	mux.HandleFunc("/api/tokens/", a.requireAuth(RoleAdmin, RoleAdmin, a.handleTokenItemAPI))
+	mux.HandleFunc("/api/audit", a.requireAuth(RoleAdmin, RoleAdmin, a.handleAuditAPI))
+	mux.HandleFunc("/api/account/password", a.requireAuth(RoleViewer, RoleViewer, a.handleAccountPasswordAPI))
+	mux.HandleFunc("/api/sessions", a.requireAuth(RoleViewer, RoleViewer, a.handleSessionsAPI))
+	mux.HandleFunc("/api/sessions/", a.requireAuth(RoleViewer, RoleViewer, a.handleSessionItemAPI))
+	mux.HandleFunc("/api/account/totp", a.requireAuth(RoleViewer, RoleViewer, a.handleAccountTOTPAPI))
 	return withRecover(mux, a.logger)
 }
 
 func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	cfg := a.currentConfig()
 	if r.Method == http.MethodGet {
-		if _, ok := a.authUser(r); ok {
+		if _, ok := a.authPrincipal(r); ok {
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
 		}
-		_ = a.templates.ExecuteTemplate(w, "login.html", map[string]any{
-			"Error":                  "",
-			"ShowDefaultPasswordTip": isDefaultAuthHash(cfg.AuthKeySHA256),
-		})
+		a.renderLogin(w, map[string]any{})
 		return
 	}
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if err := a.checkSameOrigin(r); err != nil {
+		a.logger.Warn("登录请求来源校验失败", "ip", a.clientIP(r), "error", err)
+		http.Error(w, "forbidden: "+err.Error(), http.StatusForbidden)
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	ip := a.clientIP(r)
+	if wait, ok := a.loginGuard.Check(ip); !ok {
+		a.logger.Warn("登录被锁定拒绝", "username", r.FormValue("username"), "ip", ip)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		a.renderLogin(w, map[string]any{
+			"Error":    fmt.Sprintf("登录失败次数过多，请 %s 后再试", formatLockoutWait(wait)),
+			"Username": strings.TrimSpace(r.FormValue("username")),
+		})
+		return
+	}
+	if r.FormValue("challenge") != "" {
+		a.handleLoginSecondFactor(w, r)
+		return
+	}
 
+	username := strings.TrimSpace(r.FormValue("username"))
+	if username == "" {
+		username = bootstrapAdminUser
+	}
 	key := r.FormValue("key")
-	if !isKeyMatch(cfg.AuthKeySHA256, key) {
-		_ = a.templates.ExecuteTemplate(w, "login.html", map[string]any{
-			"Error":                  "密钥错误",
-			"ShowDefaultPasswordTip": isDefaultAuthHash(cfg.AuthKeySHA256),
-		})
+	user, found := a.users.Get(username)
+	matched := false
+	var verifyErr error
+	if found {
+		matched, verifyErr = verifyPassword(user.PasswordHash, key)
+	} else {
+		verifyErr = dummyPasswordCheck(key)
+	}
+	if errors.Is(verifyErr, errPasswordHashBusy) {
+		// 并发校验过多时拒绝本次请求，不计入登录失败次数。
+		a.logger.Warn("登录请求过多，已拒绝", "username", username, "ip", ip)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		a.renderLogin(w, map[string]any{"Error": verifyErr.Error(), "Username": username})
 		return
 	}
+	if !found || user.Disabled || !matched {
+		a.logger.Warn("登录失败", "username", username, "ip", ip)
+		a.recordLoginFailure(ip, username, "密码错误")
+		a.renderLogin(w, map[string]any{"Error": "用户名或密码错误", "Username": username})
+		return
+	}
+	if passwordNeedsRehash(user.PasswordHash) {
+		a.upgradePasswordHash(user.Username, user.PasswordHash, key)
+	}
+
+	if a.renderSecondFactorStep(w, cfg, user) {
+		return
+	}
+
+	a.startSession(w, r, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (a *App) renderLogin(w http.ResponseWriter, data map[string]any) {
+	cfg := a.currentConfig()
+	data["OIDCEnabled"] = cfg.OIDCEnabled
+	data["OIDCDisplayName"] = firstNonEmpty(strings.TrimSpace(cfg.OIDCDisplayName), "企业账号")
+	for _, k := range []string{"Step", "Error", "Username", "RedirectTo"} {
+		if _, ok := data[k]; !ok {
+			data[k] = ""
+		}
+	}
+	if _, ok := data["ShowDefaultPasswordTip"]; !ok {
+		data["ShowDefaultPasswordTip"] = a.users.HasDefaultPassword()
+	}
+	_ = a.templates.ExecuteTemplate(w, "login.html", data)
+}
 
-	token := a.sessions.Create("key-user", 8*time.Hour)
+// startSession 在全部登录校验通过后创建会话并写入 Cookie。
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, user UserAccount) {
+	cfg := a.currentConfig()
+	ip := a.clientIP(r)
+	a.loginGuard.Succeed(ip)
+	a.logger.Info("登录成功", "username", user.Username, "role", user.Role, "totp", user.TOTPEnabled, "ip", ip)
+	token := a.sessions.Create(user.Username, ip, r.UserAgent(), sessionTTL)
 	http.SetCookie(w, &http.Cookie{
 		Name:     cfg.SessionCookie,
 		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   a.tls != nil,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(8 * time.Hour),
+		Expires:  time.Now().Add(sessionTTL),
 	})
-	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
@@ -167,6 +293,7 @@ func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
+		Secure:   a.tls != nil,
 		MaxAge:   -1,
 	})
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
@@ -177,7 +304,7 @@ func (a *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	a.renderConsolePage(w, false)
+	a.renderConsolePage(w, r, false)
 	return
 }
 
@@ -186,17 +313,25 @@ func (a *App) handleInitialDeployPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	a.renderConsolePage(w, true)
+	a.renderConsolePage(w, r, true)
 }
 
-func (a *App) renderConsolePage(w http.ResponseWriter, initialDeployPage bool) {
-	cfg := a.currentConfig()
+func (a *App) renderConsolePage(w http.ResponseWriter, r *http.Request, initialDeployPage bool) {
+	principal := principalFromRequest(r)
+	cfg := visibleConfig(principal, a.currentConfig())
 	project := getDefaultProject(cfg)
 	_ = a.templates.ExecuteTemplate(w, "index.html", map[string]any{
+		"CurrentUser":       principal.Username,
+		"CurrentRole":       principal.Role,
+		"CSRFToken":         principal.CSRFToken,
+		"CanOperate":        roleAllows(principal.Role, RoleOperator),
+		"IsAdmin":           roleAllows(principal.Role, RoleAdmin),
+		"CanEditProjects":   roleAllows(principal.Role, RoleOperator) && principal.CanEditAnyProject(cfg.Projects),
 		"ServiceName":       project.ServiceName,
 		"TargetDir":         project.TargetDir,
 		"MaxUploadMB":       project.MaxUploadMB,
 		"CurrentVersion":    project.CurrentVersion,
+		"TLSFingerprint":    a.tlsFingerprint(),
 		"InitialDeployPage": initialDeployPage,
 		"PageTitle": func() string {
 			if initialDeployPage {
@@ -214,7 +349,7 @@ func (a *App) handleDeploymentsPartial(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	data := buildDeploymentsPageData(a.store.List(), r)
+	data := buildDeploymentsPageData(a.visibleDeployments(r), r)
 	_ = a.templates.ExecuteTemplate(w, "deployments.html", data)
 }
 
@@ -223,7 +358,7 @@ func (a *App) handleDeploymentsRows(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	data := buildDeploymentsPageData(a.store.List(), r)
+	data := buildDeploymentsPageData(a.visibleDeployments(r), r)
 	_ = a.templates.ExecuteTemplate(w, "deployments_rows_fragment", data)
 }
 
@@ -253,6 +388,11 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("未找到程序: %s", projectID)})
 		return
 	}
+	principal := principalFromRequest(r)
+	if !principal.Allows(project.ID, TokenActionDeploy) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": fmt.Sprintf("无权操作程序: %s", project.ID)})
+		return
+	}
 	deployEntry := normalizeDeployEntry(r.FormValue("deploy_entry"))
 	clearTargetBeforeDeploy := parseBoolFormValue(r.FormValue("clear_target_before_deploy"))
 	targetExists, targetEmpty, targetCheckErr := inspectTargetDirState(project.TargetDir)
@@ -291,10 +431,17 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 
 	id := newID("dep")
 	uploadPath := filepath.Join(cfg.UploadDir, id+".zip")
-	if err := saveMultipartFile(file, uploadPath); err != nil {
+	packageSHA256, err := saveMultipartFile(file, uploadPath)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("保存上传文件失败: %v", err)})
 		return
 	}
+	if err := checkExpectedSHA256(r.FormValue("expected_sha256"), packageSHA256); err != nil {
+		_ = os.Remove(uploadPath)
+		a.logger.Warn("上传包校验失败", "project_id", project.ID, "operator", principal.Username, "ip", a.clientIP(r), "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
 	if info, statErr := os.Stat(uploadPath); statErr == nil {
 		projectMaxBytes := project.MaxUploadMB * 1024 * 1024
 		if projectMaxBytes > 0 && info.Size() > projectMaxBytes {
@@ -305,6 +452,19 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	detachedSig, err := readDetachedSignature(r.MultipartForm, "signature")
+	if err != nil {
+		_ = os.Remove(uploadPath)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("读取签名失败: %v", err)})
+		return
+	}
+	signature, err := verifyPackageSignature(project, uploadPath, packageSHA256, detachedSig)
+	if err != nil {
+		_ = os.Remove(uploadPath)
+		a.logger.Warn("部署包签名校验失败", "project_id", project.ID, "sha256", packageSHA256, "operator", principal.Username, "ip", a.clientIP(r), "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
 
 	now := time.Now()
 	requestVersion := normalizeVersion(r.FormValue("target_version"))
@@ -333,21 +493,8 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
 	}
-	runNow := !hasSchedule
-	locked := false
-	if runNow {
-		if ok, reason := a.tryAcquireProjectTask(project.ID); !ok {
-			_ = os.Remove(uploadPath)
-			writeJSON(w, http.StatusConflict, map[string]any{"error": reason})
-			return
-		}
-		locked = true
-	}
-	defer func() {
-		if locked {
-			a.releaseProjectTask(project.ID)
-		}
-	}()
+	needApproval := project.RequireApproval
+	runNow := !hasSchedule && !needApproval
 
 	status := "queued"
 	startedAt := now
@@ -358,6 +505,10 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		planned := scheduledAt
 		scheduledAtPtr = &planned
 	}
+	if needApproval {
+		status = "pending_approval"
+		startedAt = time.Time{}
+	}
 	dep := Deployment{
 		ID:                      id,
 		Type:                    "deploy",
@@ -371,7 +522,10 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		ReplaceIgnore:           append([]string{}, resolveReplaceIgnoreRulesForTarget(project.TargetDir, project.ReplaceIgnore, project.BackupIgnore)...),
 		Status:                  status,
 		Note:                    strings.TrimSpace(r.FormValue("note")),
-		LoginIP:                 clientIP(r),
+		LoginIP:                 a.clientIP(r),
+		Operator:                principal.Username,
+		TokenID:                 principal.TokenID(),
+		TokenName:               principal.TokenName(),
 		CreatedAt:               now,
 		ScheduledAt:             scheduledAtPtr,
 		StartedAt:               startedAt,
@@ -385,6 +539,10 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		ServiceDescription:      project.ServiceDescription,
 		ServiceStartType:        project.ServiceStartType,
 		ClearTargetBeforeDeploy: clearTargetBeforeDeploy,
+		PackageSHA256:           packageSHA256,
+		SignatureStatus:         signature.Status,
+		SignatureMode:           signature.Mode,
+		SignerKeyID:             signature.KeyID,
 	}
 	if initialDeploy {
 		dep.ReplaceIgnore = nil
@@ -399,18 +557,35 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if runNow {
-		go a.runDeployment(id, project.ID)
-		locked = false
-	} else {
-		a.scheduleDeploymentTask(id, project.ID, scheduledAt)
+	queuePosition := 0
+	switch {
+	case needApproval:
+		a.publish(id, "info", "部署已提交，等待其他用户审批")
+		go a.notifyApproval(id, Approval{User: principal.Username, Time: now})
+	case runNow:
+		if queuePosition, err = a.enqueueDeployment(id); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("加入执行队列失败: %v", err)})
+			return
+		}
+	default:
+		a.scheduleDeploymentTask(id, scheduledAt)
 	}
 	respStatus := "queued"
 	respMessage := ""
+	if queuePosition > 0 {
+		respMessage = fmt.Sprintf("程序 %s 当前有任务在执行，任务已加入执行队列，当前位置: 第 %d 位", project.Name, queuePosition)
+	}
 	if hasSchedule {
 		respStatus = "scheduled"
 		respMessage = fmt.Sprintf("任务已加入等待队列，计划执行时间: %s", scheduledAt.Format("2006-01-02 15:04:05"))
 	}
+	if needApproval {
+		respStatus = "pending_approval"
+		respMessage = fmt.Sprintf("程序 %s 需要审批，任务 %s 已提交，待其他用户批准后执行", project.Name, id)
+		if hasSchedule {
+			respMessage += fmt.Sprintf("（计划执行时间: %s）", scheduledAt.Format("2006-01-02 15:04:05"))
+		}
+	}
 	writeJSON(w, http.StatusAccepted, map[string]any{
 		"id":                   id,
 		"status":               respStatus,
@@ -418,7 +593,9 @@ func (a *App) handleUpload(w http.ResponseWriter, r *http.Request) {
 		"project_id":           project.ID,
 		"project_name":         project.Name,
 		"service_install_mode": project.ServiceInstallMode,
+		"package_sha256":       packageSHA256,
 		"scheduled_at":         scheduledAtPtr,
+		"queue_position":       queuePosition,
 		"message":              respMessage,
 	})
 }
@@ -448,6 +625,11 @@ func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("未找到程序: %s", projectID)})
 		return
 	}
+	principal := principalFromRequest(r)
+	if !principal.Allows(project.ID, TokenActionPreview) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": fmt.Sprintf("无权操作程序: %s", project.ID)})
+		return
+	}
 	deployEntry := normalizeDeployEntry(r.FormValue("deploy_entry"))
 
 	file, header, err := r.FormFile("package")
@@ -494,35 +676,28 @@ func (a *App) handlePreview(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("创建预演目录失败: %v", err)})
 		return
 	}
-	if err := saveMultipartFile(file, uploadPath); err != nil {
+	packageSHA256, err := saveMultipartFile(file, uploadPath)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("保存预演上传文件失败: %v", err)})
 		return
 	}
+	if err := checkExpectedSHA256(r.FormValue("expected_sha256"), packageSHA256); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
 
 	replaceRules := resolveReplaceIgnoreRulesForTarget(project.TargetDir, project.ReplaceIgnore, project.BackupIgnore)
 	if initialDeploy {
 		replaceRules = nil
 	}
-	replaceIgnore := newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore"))
+	replaceIgnore := newIgnoreMatcher(append(append([]string{}, replaceRules...), ".replaceignore", packageSignatureFile))
 	changed, ignoredPaths, err := previewZipChanges(uploadPath, project.TargetDir, replaceIgnore, removeMissing)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("预演失败: %v", err)})
 		return
 	}
 
-	added := 0
-	updated := 0
-	deleted := 0
-	for _, c := range changed {
-		switch c.Action {
-		case "added":
-			added++
-		case "updated":
-			updated++
-		case "deleted":
-			deleted++
-		}
-	}
+	added, updated, deleted := countChangedFiles(changed)
 	writeJSON(w, http.StatusOK, map[string]any{
 		"ok":                             true,
 		"type":                           "preview",
@@ -594,7 +769,8 @@ func (a *App) handleSelfUpdate(w http.ResponseWriter, r *http.Request) {
 
 	id := newID("self")
 	uploadPath := filepath.Join(cfg.UploadDir, id+".exe")
-	if err := saveMultipartFile(file, uploadPath); err != nil {
+	packageSHA256, err := saveMultipartFile(file, uploadPath)
+	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("保存上传文件失败: %v", err)})
 		return
 	}
@@ -618,18 +794,20 @@ func (a *App) handleSelfUpdate(w http.ResponseWriter, r *http.Request) {
 
 	now := time.Now()
 	dep := Deployment{
-		ID:          id,
-		Type:        "self_update",
-		Version:     targetVersion,
-		ProjectID:   "__self__",
-		ProjectName: "SimpleRemoteUpdate",
-		ReplaceMode: "self",
-		Status:      "queued",
-		Note:        strings.TrimSpace(r.FormValue("note")),
-		LoginIP:     clientIP(r),
-		CreatedAt:   now,
-		StartedAt:   now,
-		UploadFile:  uploadPath,
+		ID:            id,
+		Type:          "self_update",
+		Version:       targetVersion,
+		ProjectID:     "__self__",
+		ProjectName:   "SimpleRemoteUpdate",
+		ReplaceMode:   "self",
+		Status:        "queued",
+		Note:          strings.TrimSpace(r.FormValue("note")),
+		LoginIP:       a.clientIP(r),
+		Operator:      principalFromRequest(r).Username,
+		CreatedAt:     now,
+		StartedAt:     now,
+		UploadFile:    uploadPath,
+		PackageSHA256: packageSHA256,
 	}
 	if dep.Note == "" {
 		dep.Note = "(未填写自更新说明)"
@@ -664,7 +842,7 @@ func (a *App) handleDeploymentAPIs(w http.ResponseWriter, r *http.Request) {
 
 	if len(parts) == 1 && r.Method == http.MethodGet {
 		dep, ok := a.store.Get(id)
-		if !ok {
+		if !ok || !principalFromRequest(r).CanAccessProject(dep.ProjectID) {
 			writeJSON(w, http.StatusNotFound, map[string]any{"error": "deployment not found"})
 			return
 		}
@@ -702,16 +880,40 @@ func (a *App) handleDeploymentAPIs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		a.handleCancelDeployment(w, r, id)
+	case "queue":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleMoveQueuedDeployment(w, r, id)
+	case ApprovalActionApprove, ApprovalActionReject, ApprovalActionComment:
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleApprovalAction(w, r, id, parts[1])
+	case "preview":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleDeploymentPreview(w, r, id)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
 func (a *App) handleDeploymentEvents(w http.ResponseWriter, r *http.Request, id string) {
-	if _, ok := a.store.Get(id); !ok {
+	if dep, ok := a.store.Get(id); !ok || !principalFromRequest(r).CanAccessProject(dep.ProjectID) {
 		http.Error(w, "deployment not found", http.StatusNotFound)
 		return
 	}
+	a.streamTaskEvents(w, r, id, "deployment_id")
+}
+
+// streamTaskEvents 以 SSE 推送任务 id 的实时日志，直到客户端断开；logKey 为服务日志中标识任务的字段名。
+// 调用方负责权限校验。
+func (a *App) streamTaskEvents(w http.ResponseWriter, r *http.Request, id, logKey string) {
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
@@ -724,8 +926,8 @@ func (a *App) handleDeploymentEvents(w http.ResponseWriter, r *http.Request, id
 	subID, ch, unsubscribe := a.events.Subscribe(id)
 	defer unsubscribe()
 
-	a.logger.Info("sse subscriber connected", "deployment_id", id, "sub_id", subID)
-	defer a.logger.Info("sse subscriber disconnected", "deployment_id", id, "sub_id", subID)
+	a.logger.Info("sse subscriber connected", logKey, id, "sub_id", subID)
+	defer a.logger.Info("sse subscriber disconnected", logKey, id, "sub_id", subID)
 
 	_, _ = io.WriteString(w, ": connected\n\n")
 	flusher.Flush()
@@ -751,6 +953,10 @@ func (a *App) handleUpdateNote(w http.ResponseWriter, r *http.Request, id string
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	if dep, ok := a.store.Get(id); !ok || !principalFromRequest(r).Allows(dep.ProjectID, TokenActionDeploy) {
+		http.Error(w, "deployment not found", http.StatusNotFound)
+		return
+	}
 	note := strings.TrimSpace(r.FormValue("note"))
 	if note == "" {
 		note = "(未填写更新说明)"
@@ -762,12 +968,49 @@ func (a *App) handleUpdateNote(w http.ResponseWriter, r *http.Request, id string
 	a.handleDeploymentsPartial(w, r)
 }
 
+// newRollbackDeployment 生成恢复 source 部署前备份的回滚记录，操作人与说明由调用方填写。
+func newRollbackDeployment(id string, source Deployment, projectID string) Deployment {
+	now := time.Now()
+	return Deployment{
+		ID:                 id,
+		Type:               "rollback",
+		RollbackOf:         source.ID,
+		Version:            source.Version,
+		ProjectID:          projectID,
+		ProjectName:        source.ProjectName,
+		ReplaceMode:        source.ReplaceMode,
+		BackupIgnore:       append([]string{}, source.BackupIgnore...),
+		ReplaceIgnore:      append([]string{}, source.ReplaceIgnore...),
+		Status:             "queued",
+		CreatedAt:          now,
+		StartedAt:          now,
+		BackupFile:         source.BackupFile,
+		BackupSHA256:       source.BackupSHA256,
+		PreviousDir:        source.PreviousDir,
+		ServiceName:        source.ServiceName,
+		TargetDir:          source.TargetDir,
+		InstanceID:         source.InstanceID,
+		InitialDeploy:      source.InitialDeploy,
+		BackupSkipped:      source.BackupSkipped,
+		ServiceInstallMode: source.ServiceInstallMode,
+		ServiceExePath:     source.ServiceExePath,
+		ServiceArgs:        append([]string{}, source.ServiceArgs...),
+		ServiceDisplayName: source.ServiceDisplayName,
+		ServiceDescription: source.ServiceDescription,
+		ServiceStartType:   source.ServiceStartType,
+	}
+}
+
 func (a *App) handleRollback(w http.ResponseWriter, r *http.Request, sourceID string) {
 	source, ok := a.store.Get(sourceID)
 	if !ok {
 		http.Error(w, "deployment not found", http.StatusNotFound)
 		return
 	}
+	if len(source.InstanceDeployments) > 0 {
+		http.Error(w, "滚动更新记录不能整体回滚，请在各实例的部署记录中分别回滚", http.StatusBadRequest)
+		return
+	}
 	if source.BackupFile == "" {
 		if source.InitialDeploy || source.BackupSkipped {
 			http.Error(w, "该部署属于首次部署且未生成备份，当前版本不支持自动回滚到部署前空目录", http.StatusBadRequest)
@@ -781,6 +1024,11 @@ func (a *App) handleRollback(w http.ResponseWriter, r *http.Request, sourceID st
 	if projectID == "" {
 		projectID = a.currentConfig().DefaultProjectID
 	}
+	principal := principalFromRequest(r)
+	if !principal.Allows(projectID, TokenActionRollback) {
+		http.Error(w, fmt.Sprintf("无权回滚程序: %s", projectID), http.StatusForbidden)
+		return
+	}
 	if ok, reason := a.tryAcquireProjectTask(projectID); !ok {
 		http.Error(w, reason, http.StatusConflict)
 		return
@@ -792,35 +1040,13 @@ func (a *App) handleRollback(w http.ResponseWriter, r *http.Request, sourceID st
 		}
 	}()
 
-	now := time.Now()
 	id := newID("rb")
-	rollback := Deployment{
-		ID:                 id,
-		Type:               "rollback",
-		RollbackOf:         sourceID,
-		Version:            source.Version,
-		ProjectID:          projectID,
-		ProjectName:        source.ProjectName,
-		ReplaceMode:        source.ReplaceMode,
-		BackupIgnore:       append([]string{}, source.BackupIgnore...),
-		ReplaceIgnore:      append([]string{}, source.ReplaceIgnore...),
-		Status:             "queued",
-		Note:               fmt.Sprintf("回滚到 %s", sourceID),
-		LoginIP:            clientIP(r),
-		CreatedAt:          now,
-		StartedAt:          now,
-		BackupFile:         source.BackupFile,
-		ServiceName:        source.ServiceName,
-		TargetDir:          source.TargetDir,
-		InitialDeploy:      source.InitialDeploy,
-		BackupSkipped:      source.BackupSkipped,
-		ServiceInstallMode: source.ServiceInstallMode,
-		ServiceExePath:     source.ServiceExePath,
-		ServiceArgs:        append([]string{}, source.ServiceArgs...),
-		ServiceDisplayName: source.ServiceDisplayName,
-		ServiceDescription: source.ServiceDescription,
-		ServiceStartType:   source.ServiceStartType,
-	}
+	rollback := newRollbackDeployment(id, source, projectID)
+	rollback.Note = fmt.Sprintf("回滚到 %s", sourceID)
+	rollback.LoginIP = a.clientIP(r)
+	rollback.Operator = principal.Username
+	rollback.TokenID = principal.TokenID()
+	rollback.TokenName = principal.TokenName()
 	if err := a.store.Add(rollback); err != nil {
 		http.Error(w, "回滚任务创建失败", http.StatusInternalServerError)
 		return
@@ -836,47 +1062,107 @@ func (a *App) handleCancelDeployment(w http.ResponseWriter, r *http.Request, id
 		http.Error(w, "deployment not found", http.StatusNotFound)
 		return
 	}
+	principal := principalFromRequest(r)
+	if !principal.Allows(dep.ProjectID, TokenActionDeploy) {
+		http.Error(w, fmt.Sprintf("无权操作程序: %s", dep.ProjectID), http.StatusForbidden)
+		return
+	}
 	status := strings.ToLower(strings.TrimSpace(dep.Status))
-	if dep.Type != "deploy" || dep.ScheduledAt == nil || (status != "scheduled" && status != "queued") {
+	if status == "deploying" || status == "rollbacking" {
+		// 执行中的任务只发出中止请求，清理与恢复由执行流程完成后写入最终状态。
+		if !a.abortTaskRun(id, principal.Username) {
+			http.Error(w, "任务不在当前进程中执行，无法中止", http.StatusConflict)
+			return
+		}
+		a.publish(id, "warn", "%s 请求中止任务，等待当前步骤结束后清理", principal.Username)
+		a.handleDeploymentsPartial(w, r)
+		return
+	}
+	pending := status == "pending_approval"
+	if dep.Type != "deploy" || (!pending && status != "scheduled" && status != "queued") {
 		http.Error(w, "该任务当前不可取消", http.StatusBadRequest)
 		return
 	}
 
 	a.cancelScheduledDeploymentTask(id)
+	canceled, err := a.cancelWaitingDeployment(id, "任务已取消")
+	if err != nil {
+		http.Error(w, "取消任务失败", http.StatusInternalServerError)
+		return
+	}
+	if !canceled {
+		http.Error(w, "任务已开始执行，无法取消", http.StatusConflict)
+		return
+	}
+	if status == "queued" {
+		a.compactQueue(dep.ProjectID)
+		a.publish(id, "warn", "排队任务已取消")
+	} else {
+		a.publish(id, "warn", "计划任务已取消")
+	}
+	a.notifyDeploymentIfNeeded(id)
+	a.handleDeploymentsPartial(w, r)
+}
+
+// cancelWaitingDeployment 把尚未开始执行的部署（计划中、排队中或待审批）标记为已取消并删除其上传包；
+// 任务已开始执行时返回 false。
+func (a *App) cancelWaitingDeployment(id, reason string) (bool, error) {
 	canceled := false
 	uploadFile := ""
 	now := time.Now()
 	if err := a.store.UpdateField(id, func(d *Deployment) {
 		s := strings.ToLower(strings.TrimSpace(d.Status))
-		if s != "scheduled" && s != "queued" {
+		if s != "scheduled" && s != "queued" && s != "pending_approval" {
 			return
 		}
 		canceled = true
 		d.Status = "canceled"
+		d.QueuePosition = 0
 		d.FinishedAt = &now
 		d.DurationMs = now.Sub(d.CreatedAt).Milliseconds()
-		d.Error = "任务已取消"
+		d.Error = reason
 		uploadFile = strings.TrimSpace(d.UploadFile)
 	}); err != nil {
-		http.Error(w, "取消任务失败", http.StatusInternalServerError)
+		return false, err
+	}
+	if canceled && uploadFile != "" {
+		_ = os.Remove(uploadFile)
+	}
+	return canceled, nil
+}
+
+// handleMoveQueuedDeployment 调整排队任务的位置，表单字段 position 为目标位置（1 为队首）。
+func (a *App) handleMoveQueuedDeployment(w http.ResponseWriter, r *http.Request, id string) {
+	dep, ok := a.store.Get(id)
+	if !ok {
+		http.Error(w, "deployment not found", http.StatusNotFound)
 		return
 	}
-	if !canceled {
-		http.Error(w, "任务已开始执行，无法取消", http.StatusConflict)
+	if !principalFromRequest(r).Allows(dep.ProjectID, TokenActionDeploy) {
+		http.Error(w, fmt.Sprintf("无权操作程序: %s", dep.ProjectID), http.StatusForbidden)
 		return
 	}
-	if uploadFile != "" {
-		_ = os.Remove(uploadFile)
+	if err := parseRequestForm(r); err != nil {
+		http.Error(w, "请求参数解析失败", http.StatusBadRequest)
+		return
 	}
-	a.publish(id, "warn", "计划任务已取消")
-	a.notifyDeploymentIfNeeded(id)
+	position, err := strconv.Atoi(strings.TrimSpace(r.FormValue("position")))
+	if err != nil || position < 1 {
+		http.Error(w, "position 必须为正整数（1 表示队首）", http.StatusBadRequest)
+		return
+	}
+	moved, err := a.moveQueuedDeployment(id, position)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	a.publish(id, "info", "%s 调整了排队位置，当前位置: 第 %d 位", principalFromRequest(r).Username, moved)
 	a.handleDeploymentsPartial(w, r)
 }
 
 func (a *App) handleConfigAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		cfg := a.currentConfig()
-		writeJSON(w, http.StatusOK, configSnapshot(cfg))
+		writeJSON(w, http.StatusOK, configSnapshot(visibleConfig(principalFromRequest(r), a.currentConfig())))
 		return
 	}
 	if r.Method != http.MethodPost {
@@ -891,6 +1177,10 @@ func (a *App) handleConfigAPI(w http.ResponseWriter, r *http.Request) {
 	scope := strings.ToLower(strings.TrimSpace(r.FormValue("scope")))
 	switch scope {
 	case "", "system":
+		if !roleAllows(principalFromRequest(r).Role, RoleAdmin) {
+			writeJSON(w, http.StatusForbidden, map[string]any{"error": "仅 admin 可修改系统配置"})
+			return
+		}
 		a.handleSaveSystemConfig(w, r)
 	case "project":
 		a.handleSaveProjectConfig(w, r)
@@ -976,26 +1266,126 @@ func (a *App) handleSaveSystemConfig(w http.ResponseWriter, r *http.Request) {
 	if _, ok := r.Form["self_update_service_name"]; ok {
 		newCfg.SelfUpdateServiceName = strings.TrimSpace(r.FormValue("self_update_service_name"))
 	}
+	if raw, ok := r.Form["session_idle_minutes"]; ok {
+		minutes, err := strconv.Atoi(strings.TrimSpace(strings.Join(raw, "")))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "session_idle_minutes 必须为整数"})
+			return
+		}
+		newCfg.SessionIdleMinutes = minutes
+	}
+	for _, field := range []struct {
+		name string
+		dst  *int
+	}{
+		{"login_max_failures", &newCfg.LoginMaxFailures},
+		{"login_lockout_minutes", &newCfg.LoginLockoutMinutes},
+		{"login_max_lockout_minutes", &newCfg.LoginMaxLockoutMins},
+		{"login_global_max_failures", &newCfg.LoginGlobalMaxFails},
+	} {
+		raw, ok := r.Form[field.name]
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(strings.Join(raw, "")))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": field.name + " 必须为整数"})
+			return
+		}
+		*field.dst = value
+	}
+	if _, ok := r.Form["tls_enabled"]; ok {
+		newCfg.TLSEnabled = parseBoolFormValue(r.FormValue("tls_enabled"))
+	}
+	if _, ok := r.Form["tls_auto_self_signed"]; ok {
+		newCfg.TLSAutoSelfSigned = parseBoolFormValue(r.FormValue("tls_auto_self_signed"))
+	}
+	if _, ok := r.Form["tls_cert_file"]; ok {
+		newCfg.TLSCertFile = strings.TrimSpace(r.FormValue("tls_cert_file"))
+	}
+	if _, ok := r.Form["tls_key_file"]; ok {
+		newCfg.TLSKeyFile = strings.TrimSpace(r.FormValue("tls_key_file"))
+	}
+	if _, ok := r.Form["tls_redirect_addr"]; ok {
+		newCfg.TLSRedirectAddr = strings.TrimSpace(r.FormValue("tls_redirect_addr"))
+	}
+	if _, ok := r.Form["trusted_proxies_text"]; ok {
+		newCfg.TrustedProxies = splitLinesTrim(r.FormValue("trusted_proxies_text"))
+	}
+	if _, ok := r.Form["totp_required"]; ok {
+		newCfg.TOTPRequired = parseBoolFormValue(r.FormValue("totp_required"))
+	}
+	if _, ok := r.Form["oidc_enabled"]; ok {
+		newCfg.OIDCEnabled = parseBoolFormValue(r.FormValue("oidc_enabled"))
+	}
+	for _, field := range []struct {
+		name string
+		dst  *string
+	}{
+		{"oidc_display_name", &newCfg.OIDCDisplayName},
+		{"oidc_issuer", &newCfg.OIDCIssuer},
+		{"oidc_client_id", &newCfg.OIDCClientID},
+		{"oidc_redirect_url", &newCfg.OIDCRedirectURL},
+		{"oidc_ca_file", &newCfg.OIDCCAFile},
+		{"oidc_username_claim", &newCfg.OIDCUsernameClaim},
+		{"oidc_role_claim", &newCfg.OIDCRoleClaim},
+		{"oidc_default_role", &newCfg.OIDCDefaultRole},
+	} {
+		if _, ok := r.Form[field.name]; ok {
+			*field.dst = strings.TrimSpace(r.FormValue(field.name))
+		}
+	}
+	if _, ok := r.Form["oidc_client_secret"]; ok {
+		if secret := strings.TrimSpace(r.FormValue("oidc_client_secret")); secret != "" {
+			newCfg.OIDCClientSecret = secret
+		}
+	}
+	if _, ok := r.Form["oidc_scopes"]; ok {
+		newCfg.OIDCScopes = strings.Fields(r.FormValue("oidc_scopes"))
+	}
+	if _, ok := r.Form["oidc_role_mapping_text"]; ok {
+		mapping, err := parseOIDCRoleMappingText(r.FormValue("oidc_role_mapping_text"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		newCfg.OIDCRoleMapping = mapping
+	}
 
 	defaultProjectID := strings.TrimSpace(r.FormValue("default_project_id"))
 	if defaultProjectID != "" {
 		newCfg.DefaultProjectID = defaultProjectID
 	}
 
-	newKey := strings.TrimSpace(r.FormValue("new_auth_key"))
-	if newKey != "" {
-		newCfg.AuthKeySHA256 = sha256Hex(newKey)
-	}
-
-	restartFields, err := a.applyConfigChanges(w, r, oldCfg, newCfg)
+	restartFields, err := a.applyConfigChanges(w, r, AuditActionSystemUpdate, "", oldCfg, newCfg)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
 	}
+	saveMsg := "系统配置保存成功，已自动刷新运行配置"
+	if newKey := strings.TrimSpace(r.FormValue("new_auth_key")); newKey != "" {
+		username := principalFromRequest(r).Username
+		newHash, err := hashPassword(newKey)
+		if err == nil {
+			err = a.users.Save(username, func(u *UserAccount, exists bool) error {
+				if !exists {
+					return fmt.Errorf("用户不存在: %s", username)
+				}
+				u.PasswordHash = newHash
+				return nil
+			})
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("系统配置已保存，但修改登录密码失败: %v", err)})
+			return
+		}
+		a.recordAudit(r, AuditActionPasswordChange, username, nil)
+		saveMsg += fmt.Sprintf("；用户 %s 的登录密码已更新", username)
+	}
 	finalCfg := a.currentConfig()
 	writeJSON(w, http.StatusOK, map[string]any{
 		"ok":             true,
-		"message":        "系统配置保存成功，已自动刷新运行配置",
+		"message":        saveMsg,
 		"restart_needed": len(restartFields) > 0,
 		"restart_fields": restartFields,
 		"config":         configSnapshot(finalCfg),
@@ -1005,12 +1395,19 @@ func (a *App) handleSaveSystemConfig(w http.ResponseWriter, r *http.Request) {
 func (a *App) handleSaveProjectConfig(w http.ResponseWriter, r *http.Request) {
 	oldCfg := a.currentConfig()
 	newCfg := oldCfg
+	// 复制程序列表，避免就地修改与运行配置共享的底层数组，审计差异才能对比出改动。
+	newCfg.Projects = append([]ManagedProject(nil), oldCfg.Projects...)
 
 	projectID := strings.TrimSpace(r.FormValue("project_id"))
 	if projectID == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "project_id 不能为空"})
 		return
 	}
+	principal := principalFromRequest(r)
+	if !principal.Allows(projectID, ProjectActionConfig) {
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": fmt.Sprintf("无权修改程序配置: %s", projectID)})
+		return
+	}
 
 	idx := -1
 	for i := range newCfg.Projects {
@@ -1026,16 +1423,21 @@ func (a *App) handleSaveProjectConfig(w http.ResponseWriter, r *http.Request) {
 
 	project := newCfg.Projects[idx]
 	project.Name = firstNonEmpty(strings.TrimSpace(r.FormValue("name")), projectID)
-	project.ServiceName = strings.TrimSpace(r.FormValue("service_name"))
-	project.TargetDir = strings.TrimSpace(r.FormValue("target_dir"))
 	project.CurrentVersion = normalizeVersion(r.FormValue("current_version"))
-	project.AllowInitialDeploy = parseBoolFormValue(r.FormValue("allow_initial_deploy"))
-	project.ServiceInstallMode = normalizeServiceInstallMode(r.FormValue("service_install_mode"))
-	project.ServiceExePath = strings.TrimSpace(r.FormValue("service_exe_path"))
-	project.ServiceArgs = splitLinesTrim(r.FormValue("service_args_text"))
-	project.ServiceDisplayName = strings.TrimSpace(r.FormValue("service_display_name"))
-	project.ServiceDescription = strings.TrimSpace(r.FormValue("service_description"))
-	project.ServiceStartType = normalizeServiceStartType(r.FormValue("service_start_type"))
+	// 目标目录与服务安装参数决定文件写到哪里、以什么程序注册服务，等同于以服务账户执行任意程序，只有 admin 能修改；
+	// 签名、审批、钩子命令、健康检查与实例（含各实例的健康检查）同属安全约束。持有 config 授权的用户保存时保留原值。
+	isAdmin := roleAllows(principal.Role, RoleAdmin)
+	if isAdmin {
+		project.ServiceName = strings.TrimSpace(r.FormValue("service_name"))
+		project.TargetDir = strings.TrimSpace(r.FormValue("target_dir"))
+		project.AllowInitialDeploy = parseBoolFormValue(r.FormValue("allow_initial_deploy"))
+		project.ServiceInstallMode = normalizeServiceInstallMode(r.FormValue("service_install_mode"))
+		project.ServiceExePath = strings.TrimSpace(r.FormValue("service_exe_path"))
+		project.ServiceArgs = splitLinesTrim(r.FormValue("service_args_text"))
+		project.ServiceDisplayName = strings.TrimSpace(r.FormValue("service_display_name"))
+		project.ServiceDescription = strings.TrimSpace(r.FormValue("service_description"))
+		project.ServiceStartType = normalizeServiceStartType(r.FormValue("service_start_type"))
+	}
 	if project.CurrentVersion == "" {
 		project.CurrentVersion = "0.0.1"
 	}
@@ -1058,13 +1460,106 @@ func (a *App) handleSaveProjectConfig(w http.ResponseWriter, r *http.Request) {
 	project.MaxUploadMB = maxUploadMB
 	project.BackupIgnore = splitLinesTrim(r.FormValue("backup_ignore_text"))
 	project.ReplaceIgnore = splitLinesTrim(r.FormValue("replace_ignore_text"))
+	if _, ok := r.Form["deploy_strategy"]; ok {
+		project.DeployStrategy = normalizeDeployStrategy(r.FormValue("deploy_strategy"))
+	}
+	if _, ok := r.Form["queue_supersede"]; ok {
+		project.QueueSupersede = parseBoolFormValue(r.FormValue("queue_supersede"))
+	}
+	if _, ok := r.Form["auto_recover_interrupted"]; ok {
+		project.AutoRecoverInterrupted = parseBoolFormValue(r.FormValue("auto_recover_interrupted"))
+	}
+	if _, ok := r.Form["auto_rollback_on_failure"]; ok {
+		project.AutoRollbackOnFailure = parseBoolFormValue(r.FormValue("auto_rollback_on_failure"))
+	}
+	for _, field := range []struct {
+		name string
+		dst  *int
+	}{
+		{"rolling_batch_size", &project.RollingBatchSize},
+		{"rolling_pause_sec", &project.RollingPauseSec},
+	} {
+		raw, ok := r.Form[field.name]
+		if !ok {
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(strings.Join(raw, "")))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": field.name + " 必须为整数"})
+			return
+		}
+		*field.dst = value
+	}
+	if err := validateRollingSettings(project); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if _, ok := r.Form["require_signature"]; ok && isAdmin {
+		project.RequireSignature = parseBoolFormValue(r.FormValue("require_signature"))
+	}
+	if _, ok := r.Form["require_approval"]; ok && isAdmin {
+		project.RequireApproval = parseBoolFormValue(r.FormValue("require_approval"))
+	}
+	if raw, ok := r.Form["hooks_json"]; ok && isAdmin {
+		hooks := make([]ProjectHook, 0)
+		if text := strings.TrimSpace(strings.Join(raw, "")); text != "" {
+			if err := json.Unmarshal([]byte(text), &hooks); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("hooks_json 格式错误: %v", err)})
+				return
+			}
+		}
+		normalized, err := normalizeProjectHooks(hooks)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		project.Hooks = normalized
+	}
+	if raw, ok := r.Form["health_check_json"]; ok && isAdmin {
+		var check *ProjectHealthCheck
+		if text := strings.TrimSpace(strings.Join(raw, "")); text != "" {
+			if err := json.Unmarshal([]byte(text), &check); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("health_check_json 格式错误: %v", err)})
+				return
+			}
+		}
+		normalized, err := normalizeHealthCheck(check)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		project.HealthCheck = normalized
+	}
+	if raw, ok := r.Form["instances_json"]; ok && isAdmin {
+		instances := make([]ProjectInstance, 0)
+		if text := strings.TrimSpace(strings.Join(raw, "")); text != "" {
+			if err := json.Unmarshal([]byte(text), &instances); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("instances_json 格式错误: %v", err)})
+				return
+			}
+		}
+		normalized, err := normalizeProjectInstances(instances)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		project.Instances = normalized
+	}
+	if _, ok := r.Form["signing_keys_text"]; ok && isAdmin {
+		keys, err := parseSigningKeysText(r.FormValue("signing_keys_text"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		project.SigningKeys = keys
+	}
 
 	newCfg.Projects[idx] = project
-	if parseBoolFormValue(r.FormValue("set_default_project")) {
+	if parseBoolFormValue(r.FormValue("set_default_project")) && isAdmin {
 		newCfg.DefaultProjectID = projectID
 	}
 
-	restartFields, err := a.applyConfigChanges(w, r, oldCfg, newCfg)
+	restartFields, err := a.applyConfigChanges(w, r, AuditActionProjectUpdate, projectID, oldCfg, newCfg)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -1080,7 +1575,7 @@ func (a *App) handleSaveProjectConfig(w http.ResponseWriter, r *http.Request) {
 		"restart_needed":    len(restartFields) > 0,
 		"restart_fields":    restartFields,
 		"active_project_id": projectID,
-		"config":            configSnapshot(finalCfg),
+		"config":            configSnapshot(visibleConfig(principal, finalCfg)),
 	})
 }
 
@@ -1167,7 +1662,7 @@ func (a *App) handleProjectsAPI(w http.ResponseWriter, r *http.Request) {
 		newCfg.DefaultProjectID = project.ID
 	}
 
-	restartFields, err := a.applyConfigChanges(w, r, oldCfg, newCfg)
+	restartFields, err := a.applyConfigChanges(w, r, AuditActionProjectCreate, project.ID, oldCfg, newCfg)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -1223,7 +1718,7 @@ func (a *App) handleProjectItemAPI(w http.ResponseWriter, r *http.Request) {
 		newCfg.DefaultProjectID = filtered[0].ID
 	}
 
-	restartFields, err := a.applyConfigChanges(w, r, oldCfg, newCfg)
+	restartFields, err := a.applyConfigChanges(w, r, AuditActionProjectDelete, projectID, oldCfg, newCfg)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -1266,6 +1761,30 @@ func configSnapshot(cfg Config) map[string]any {
 		"notify_email":               cfg.NotifyEmail,
 		"notify_email_auth_code_set": strings.TrimSpace(cfg.NotifyEmailAuthCode) != "",
 		"self_update_service_name":   cfg.SelfUpdateServiceName,
+		"totp_required":              cfg.TOTPRequired,
+		"session_idle_minutes":       cfg.SessionIdleMinutes,
+		"trusted_proxies_text":       strings.Join(cfg.TrustedProxies, "\n"),
+		"login_max_failures":         cfg.LoginMaxFailures,
+		"login_lockout_minutes":      cfg.LoginLockoutMinutes,
+		"login_max_lockout_minutes":  cfg.LoginMaxLockoutMins,
+		"login_global_max_failures":  cfg.LoginGlobalMaxFails,
+		"tls_enabled":                cfg.TLSEnabled,
+		"tls_cert_file":              cfg.TLSCertFile,
+		"tls_key_file":               cfg.TLSKeyFile,
+		"tls_auto_self_signed":       cfg.TLSAutoSelfSigned,
+		"tls_redirect_addr":          cfg.TLSRedirectAddr,
+		"oidc_enabled":               cfg.OIDCEnabled,
+		"oidc_display_name":          cfg.OIDCDisplayName,
+		"oidc_issuer":                cfg.OIDCIssuer,
+		"oidc_client_id":             cfg.OIDCClientID,
+		"oidc_client_secret_set":     strings.TrimSpace(cfg.OIDCClientSecret) != "",
+		"oidc_scopes":                strings.Join(cfg.OIDCScopes, " "),
+		"oidc_redirect_url":          cfg.OIDCRedirectURL,
+		"oidc_ca_file":               cfg.OIDCCAFile,
+		"oidc_username_claim":        cfg.OIDCUsernameClaim,
+		"oidc_role_claim":            cfg.OIDCRoleClaim,
+		"oidc_role_mapping_text":     oidcRoleMappingText(cfg.OIDCRoleMapping),
+		"oidc_default_role":          cfg.OIDCDefaultRole,
 		"service_name":               dp.ServiceName,
 		"target_dir":                 dp.TargetDir,
 		"replace_mode":               dp.DefaultReplaceMode,
@@ -1283,7 +1802,8 @@ func configSnapshot(cfg Config) map[string]any {
 	}
 }
 
-func (a *App) applyConfigChanges(w http.ResponseWriter, r *http.Request, oldCfg, newCfg Config) ([]string, error) {
+// applyConfigChanges 校验并保存新配置、刷新运行配置，并按 action/target 写入字段级审计记录。
+func (a *App) applyConfigChanges(w http.ResponseWriter, r *http.Request, action, target string, oldCfg, newCfg Config) ([]string, error) {
 	normalizeProjects(&newCfg)
 	if newCfg.SessionCookie == "" {
 		newCfg.SessionCookie = "updater_session"
@@ -1324,6 +1844,8 @@ func (a *App) applyConfigChanges(w http.ResponseWriter, r *http.Request, oldCfg,
 	}
 
 	a.replaceConfig(newCfg)
+	a.recordAudit(r, action, target, configAuditDiff(oldCfg, newCfg))
+	a.sessions.SetIdleTimeout(sessionIdleTimeout(newCfg))
 	if oldCfg.SessionCookie != newCfg.SessionCookie {
 		if oldCookie, err := r.Cookie(oldCfg.SessionCookie); err == nil && oldCookie.Value != "" {
 			http.SetCookie(w, &http.Cookie{
@@ -1338,8 +1860,9 @@ func (a *App) applyConfigChanges(w http.ResponseWriter, r *http.Request, oldCfg,
 				Value:    oldCookie.Value,
 				Path:     "/",
 				HttpOnly: true,
+				Secure:   a.tls != nil,
 				SameSite: http.SameSiteStrictMode,
-				Expires:  time.Now().Add(8 * time.Hour),
+				Expires:  time.Now().Add(sessionTTL),
 			})
 		}
 	}
@@ -1348,6 +1871,10 @@ func (a *App) applyConfigChanges(w http.ResponseWriter, r *http.Request, oldCfg,
 	if oldCfg.ListenAddr != newCfg.ListenAddr {
 		restartFields = append(restartFields, "listen_addr")
 	}
+	if oldCfg.TLSEnabled != newCfg.TLSEnabled || oldCfg.TLSCertFile != newCfg.TLSCertFile || oldCfg.TLSKeyFile != newCfg.TLSKeyFile ||
+		oldCfg.TLSAutoSelfSigned != newCfg.TLSAutoSelfSigned || oldCfg.TLSRedirectAddr != newCfg.TLSRedirectAddr {
+		restartFields = append(restartFields, "tls")
+	}
 	return restartFields, nil
 }
 
@@ -1357,7 +1884,7 @@ func (a *App) notifyDeploymentIfNeeded(depID string) {
 		return
 	}
 	status := strings.ToLower(strings.TrimSpace(dep.Status))
-	if status != "success" && status != "failed" && status != "canceled" && status != "cancelled" {
+	if status != "success" && status != "failed" && status != "canceled" && status != "cancelled" && status != StatusInterrupted && status != StatusAborted {
 		return
 	}
 	if dep.Type != "deploy" && dep.Type != "rollback" {
@@ -1392,6 +1919,12 @@ func (a *App) notifyDeploymentIfNeeded(depID string) {
 		firstNonEmpty(dep.Note, "-"),
 		firstNonEmpty(dep.Error, "-"),
 	)
+	if dep.HealthStatus != "" {
+		body += fmt.Sprintf("健康检查: %s\n", dep.HealthStatus)
+	}
+	if dep.AutoRestore != "" {
+		body += fmt.Sprintf("自动恢复: %s %s\n", dep.AutoRestore, dep.AutoRestoreError)
+	}
 	if err := sendNotifyEmail(email, authCode, subject, body); err != nil {
 		a.logger.Warn("更新结果邮件发送失败", "deployment_id", depID, "error", err.Error())
 		return
@@ -1481,8 +2014,6 @@ func nextProjectID(projects []ManagedProject) string {
 	}
 }
 
-const scheduledTaskRetryInterval = 5 * time.Second
-
 func parseScheduledAtFormValue(raw string) (time.Time, bool, error) {
 	trimmed := strings.TrimSpace(raw)
 	if trimmed == "" {
@@ -1513,7 +2044,7 @@ func parseScheduledAtFormValue(raw string) (time.Time, bool, error) {
 	return parsed, true, nil
 }
 
-func (a *App) scheduleDeploymentTask(depID, projectID string, runAt time.Time) {
+func (a *App) scheduleDeploymentTask(depID string, runAt time.Time) {
 	ctx, cancel := context.WithCancel(context.Background())
 	a.schedMu.Lock()
 	if old := a.schedCancel[depID]; old != nil {
@@ -1522,7 +2053,7 @@ func (a *App) scheduleDeploymentTask(depID, projectID string, runAt time.Time) {
 	a.schedCancel[depID] = cancel
 	a.schedMu.Unlock()
 
-	go a.runScheduledDeployment(ctx, depID, projectID, runAt)
+	go a.runScheduledDeployment(ctx, depID, runAt)
 }
 
 func (a *App) cancelScheduledDeploymentTask(depID string) {
@@ -1541,7 +2072,7 @@ func (a *App) clearScheduledDeploymentTask(depID string) {
 	a.schedMu.Unlock()
 }
 
-func (a *App) runScheduledDeployment(ctx context.Context, depID, projectID string, runAt time.Time) {
+func (a *App) runScheduledDeployment(ctx context.Context, depID string, runAt time.Time) {
 	defer a.clearScheduledDeploymentTask(depID)
 
 	delay := time.Until(runAt)
@@ -1555,65 +2086,30 @@ func (a *App) runScheduledDeployment(ctx context.Context, depID, projectID strin
 		}
 	}
 
+	dep, ok := a.store.Get(depID)
+	if !ok {
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(dep.Status))
+	if status != "scheduled" && status != "queued" {
+		return
+	}
 	a.publish(depID, "info", "计划时间到达，进入执行队列")
-	_ = a.store.UpdateField(depID, func(dep *Deployment) {
-		if strings.EqualFold(dep.Status, "scheduled") {
-			dep.Status = "queued"
-			dep.Error = ""
-		}
-	})
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-		}
-
-		dep, ok := a.store.Get(depID)
-		if !ok {
-			return
-		}
-		status := strings.ToLower(strings.TrimSpace(dep.Status))
-		if status == "canceled" || status == "cancelled" || status == "success" || status == "failed" {
-			return
-		}
-		if status != "queued" && status != "scheduled" {
-			return
-		}
-
-		actualProjectID := strings.TrimSpace(projectID)
-		if actualProjectID == "" {
-			actualProjectID = strings.TrimSpace(dep.ProjectID)
-		}
-		if ok, _ := a.tryAcquireProjectTask(actualProjectID); ok {
-			latest, exists := a.store.Get(depID)
-			if !exists || strings.EqualFold(latest.Status, "canceled") || strings.EqualFold(latest.Status, "cancelled") {
-				a.releaseProjectTask(actualProjectID)
-				return
-			}
-			a.publish(depID, "info", "计划任务开始执行")
-			go a.runDeployment(depID, actualProjectID)
-			return
-		}
-		time.Sleep(scheduledTaskRetryInterval)
+	if _, err := a.enqueueDeployment(depID); err != nil {
+		a.publish(depID, "warn", "加入执行队列失败: %v", err)
 	}
 }
 
 func (a *App) resumeScheduledDeployments() {
 	for _, dep := range a.store.List() {
-		if dep.Type != "deploy" || dep.ScheduledAt == nil {
-			continue
-		}
-		status := strings.ToLower(strings.TrimSpace(dep.Status))
-		if status != "scheduled" && status != "queued" {
+		if dep.Type != "deploy" || dep.ScheduledAt == nil || !strings.EqualFold(dep.Status, "scheduled") {
 			continue
 		}
 		runAt := *dep.ScheduledAt
-		if status == "queued" || runAt.Before(time.Now()) {
+		if runAt.Before(time.Now()) {
 			runAt = time.Now().Add(1 * time.Second)
 		}
-		a.scheduleDeploymentTask(dep.ID, dep.ProjectID, runAt)
+		a.scheduleDeploymentTask(dep.ID, runAt)
 	}
 }
 
@@ -1635,13 +2131,14 @@ func (a *App) tryAcquireProjectTask(projectID string) (bool, string) {
 }
 
 func (a *App) releaseProjectTask(projectID string) {
-	projectID = strings.TrimSpace(projectID)
-	if projectID == "" {
-		projectID = "__default__"
+	key := strings.TrimSpace(projectID)
+	if key == "" {
+		key = "__default__"
 	}
 	a.taskMu.Lock()
-	defer a.taskMu.Unlock()
-	delete(a.projectTask, projectID)
+	delete(a.projectTask, key)
+	a.taskMu.Unlock()
+	go a.dispatchQueue(projectID)
 }
 
 func (a *App) tryAcquireSelfTask() (bool, string) {
@@ -1659,22 +2156,62 @@ func (a *App) tryAcquireSelfTask() (bool, string) {
 
 func (a *App) releaseSelfTask() {
 	a.taskMu.Lock()
-	defer a.taskMu.Unlock()
 	a.selfTask = false
+	a.taskMu.Unlock()
+	go a.dispatchAllQueues()
 }
 
-func (a *App) authUser(r *http.Request) (string, bool) {
+func (a *App) authUser(r *http.Request) (sessionData, bool) {
 	cfg := a.currentConfig()
 	cookie, err := r.Cookie(cfg.SessionCookie)
 	if err != nil || cookie.Value == "" {
-		return "", false
+		return sessionData{}, false
+	}
+	return a.sessions.Get(cookie.Value, a.clientIP(r), r.UserAgent())
+}
+
+// authPrincipal 解析会话对应的用户，角色每次从用户表读取，便于角色调整/禁用立即生效。
+func (a *App) authPrincipal(r *http.Request) (authPrincipal, bool) {
+	session, ok := a.authUser(r)
+	if !ok {
+		return authPrincipal{}, false
+	}
+	user, found := a.users.Get(session.User)
+	if !found || user.Disabled {
+		return authPrincipal{}, false
+	}
+	return authPrincipal{Username: user.Username, Role: user.Role, SessionID: session.ID, CSRFToken: session.CSRFToken, Grants: user.Grants}, true
+}
+
+// tokenPrincipal 校验 Authorization: Bearer 令牌，并限制令牌只能访问其动作范围内的接口。
+func (a *App) tokenPrincipal(w http.ResponseWriter, r *http.Request, plain string) (authPrincipal, bool) {
+	token, ok := a.tokens.Lookup(plain, a.clientIP(r))
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{"error": "API 令牌无效、已过期或已吊销"})
+		return authPrincipal{}, false
+	}
+	action, allowed := tokenActionForRequest(r)
+	if !allowed || !token.AllowsAction(action) {
+		a.logger.Warn("API 令牌越权访问", "token_id", token.ID, "name", token.Name, "path", r.URL.Path, "method", r.Method)
+		writeJSON(w, http.StatusForbidden, map[string]any{"error": "该 API 令牌无权访问此接口"})
+		return authPrincipal{}, false
 	}
-	return a.sessions.Get(cookie.Value)
+	return authPrincipal{Username: "token:" + token.Name, Role: tokenRole(token), Token: &token}, true
 }
 
-func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+// requireAuth 校验登录状态与角色：GET/HEAD 请求需要 readRole，其他方法需要 writeRole。
+func (a *App) requireAuth(readRole, writeRole string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if _, ok := a.authUser(r); !ok {
+		var principal authPrincipal
+		ok := false
+		if plain, hasBearer := bearerToken(r); hasBearer {
+			if principal, ok = a.tokenPrincipal(w, r, plain); !ok {
+				return
+			}
+		} else {
+			principal, ok = a.authPrincipal(r)
+		}
+		if !ok {
 			if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/partials/") {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
 				return
@@ -1682,7 +2219,21 @@ func (a *App) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
-		next(w, r)
+		need := writeRole
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need = readRole
+		}
+		if !roleAllows(principal.Role, need) {
+			a.logger.Warn("权限不足", "username", principal.Username, "role", principal.Role, "required", need, "path", r.URL.Path)
+			http.Error(w, fmt.Sprintf("forbidden: 需要 %s 及以上角色", need), http.StatusForbidden)
+			return
+		}
+		if err := a.checkCSRF(r, principal); err != nil {
+			a.logger.Warn("CSRF 校验失败", "username", principal.Username, "path", r.URL.Path, "method", r.Method, "ip", a.clientIP(r), "error", err)
+			http.Error(w, "forbidden: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(withPrincipal(r.Context(), principal)))
 	}
 }
 
@@ -1693,6 +2244,12 @@ func ensureDirectories(cfg Config) error {
 		cfg.BackupDir,
 		filepath.Dir(cfg.DeploymentsFile),
 		filepath.Dir(cfg.LogFile),
+		filepath.Dir(cfg.UsersFile),
+		filepath.Dir(cfg.TokensFile),
+		filepath.Dir(cfg.SessionsFile),
+		filepath.Dir(cfg.AuditFile),
+		cfg.JournalDir,
+		filepath.Dir(cfg.ReleasesFile),
 	}
 	for _, d := range dirs {
 		if d == "" || d == "." {
@@ -1705,12 +2262,12 @@ func ensureDirectories(cfg Config) error {
 	return nil
 }
 
-func stopService(name string, timeout time.Duration) error {
-	return stopServiceImpl(context.Background(), name, timeout)
+func stopService(ctx context.Context, name string, timeout time.Duration) error {
+	return stopServiceImpl(ctx, name, timeout)
 }
 
-func startService(name string, timeout time.Duration) error {
-	return startServiceImpl(context.Background(), name, timeout)
+func startService(ctx context.Context, name string, timeout time.Duration) error {
+	return startServiceImpl(ctx, name, timeout)
 }
 
 func validateRuntimeConfig(cfg Config) error {
@@ -1747,6 +2304,24 @@ func validateRuntimeConfig(cfg Config) error {
 		if p.MaxUploadMB <= 0 {
 			return fmt.Errorf("projects(%s).max_upload_mb 必须大于 0", p.ID)
 		}
+		if _, err := normalizeSigningKeys(p.SigningKeys); err != nil {
+			return fmt.Errorf("projects(%s).signing_keys 无效: %v", p.ID, err)
+		}
+		if p.RequireSignature && len(p.SigningKeys) == 0 {
+			return fmt.Errorf("projects(%s) 开启 require_signature 时至少需要配置一个签名公钥", p.ID)
+		}
+		if _, err := normalizeProjectHooks(p.Hooks); err != nil {
+			return fmt.Errorf("projects(%s).%v", p.ID, err)
+		}
+		if _, err := normalizeHealthCheck(p.HealthCheck); err != nil {
+			return fmt.Errorf("projects(%s).%v", p.ID, err)
+		}
+		if _, err := normalizeProjectInstances(p.Instances); err != nil {
+			return fmt.Errorf("projects(%s).%v", p.ID, err)
+		}
+		if err := validateRollingSettings(p); err != nil {
+			return fmt.Errorf("projects(%s).%v", p.ID, err)
+		}
 		if p.ServiceInstallMode != ServiceInstallModeNone {
 			if strings.TrimSpace(p.ServiceName) == "" {
 				return fmt.Errorf("projects(%s).service_name 不能为空（启用服务安装时必填）", p.ID)
@@ -1783,6 +2358,50 @@ func validateRuntimeConfig(cfg Config) error {
 	if strings.TrimSpace(cfg.LogFile) == "" {
 		return errors.New("log_file 不能为空")
 	}
+	if err := validateOIDCConfig(cfg); err != nil {
+		return err
+	}
+	if strings.TrimSpace(cfg.UsersFile) == "" {
+		return errors.New("users_file 不能为空")
+	}
+	if strings.TrimSpace(cfg.TokensFile) == "" {
+		return errors.New("tokens_file 不能为空")
+	}
+	if strings.TrimSpace(cfg.SessionsFile) == "" {
+		return errors.New("sessions_file 不能为空")
+	}
+	if strings.TrimSpace(cfg.AuditFile) == "" {
+		return errors.New("audit_file 不能为空")
+	}
+	if strings.TrimSpace(cfg.JournalDir) == "" {
+		return errors.New("journal_dir 不能为空")
+	}
+	if strings.TrimSpace(cfg.ReleasesFile) == "" {
+		return errors.New("releases_file 不能为空")
+	}
+	if strings.TrimSpace(cfg.SecretKeyFile) == "" {
+		return errors.New("secret_key_file 不能为空")
+	}
+	if cfg.SessionIdleMinutes < 0 {
+		return errors.New("session_idle_minutes 不能为负数")
+	}
+	if cfg.TLSEnabled && (strings.TrimSpace(cfg.TLSCertFile) == "" || strings.TrimSpace(cfg.TLSKeyFile) == "") {
+		return errors.New("启用 TLS 时 tls_cert_file 与 tls_key_file 不能为空")
+	}
+	if addr := strings.TrimSpace(cfg.TLSRedirectAddr); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("tls_redirect_addr 格式错误: %v", err)
+		}
+		if addr == strings.TrimSpace(cfg.ListenAddr) {
+			return errors.New("tls_redirect_addr 不能与 listen_addr 相同")
+		}
+	}
+	if _, err := parseTrustedProxies(cfg.TrustedProxies); err != nil {
+		return err
+	}
+	if cfg.LoginMaxFailures < 0 || cfg.LoginLockoutMinutes < 0 || cfg.LoginMaxLockoutMins < 0 || cfg.LoginGlobalMaxFails < 0 {
+		return errors.New("登录锁定相关配置不能为负数")
+	}
 	if email := strings.TrimSpace(cfg.NotifyEmail); email != "" {
 		if _, err := mail.ParseAddress(email); err != nil {
 			return fmt.Errorf("notify_email 格式错误: %v", err)
@@ -1893,6 +2512,8 @@ func buildDeploymentsPageData(all []Deployment, r *http.Request) map[string]any
 		"Total":       total,
 		"NextOffset":  nextOffset,
 		"HasMore":     hasMore,
+		"CanOperate":  roleAllows(principalFromRequest(r).Role, RoleOperator),
+		"CurrentUser": principalFromRequest(r).Username,
 	}
 }
 
@@ -1980,7 +2601,7 @@ func runSelfUpdateWorker(opts selfUpdateWorkerOptions) error {
 	appendSelfUpdateLog(opts.LogFile, "[self-update] worker started: target=%s source=%s backup=%s", opts.TargetPath, opts.SourcePath, opts.BackupPath)
 	if opts.ServiceName != "" {
 		appendSelfUpdateLog(opts.LogFile, "[self-update] service-aware mode enabled: service=%s", opts.ServiceName)
-		if err := stopService(opts.ServiceName, selfUpdateServiceOpTimeout); err != nil {
+		if err := stopService(context.Background(), opts.ServiceName, selfUpdateServiceOpTimeout); err != nil {
 			appendSelfUpdateLog(opts.LogFile, "[self-update] stop service failed: %v", err)
 			_ = updateSelfUpdateResult(opts, "failed", fmt.Sprintf("停止自更新服务失败(%s): %v", opts.ServiceName, err), 0)
 			return err
@@ -2092,7 +2713,7 @@ func restartSelfUpdatedProcess(opts selfUpdateWorkerOptions) error {
 func startSelfUpdateServiceWithRetry(opts selfUpdateWorkerOptions) error {
 	var lastErr error
 	for attempt := 1; attempt <= selfUpdateRestartRetryTimes; attempt++ {
-		lastErr = startService(opts.ServiceName, selfUpdateServiceOpTimeout)
+		lastErr = startService(context.Background(), opts.ServiceName, selfUpdateServiceOpTimeout)
 		if lastErr == nil {
 			if attempt > 1 {
 				appendSelfUpdateLog(opts.LogFile, "[self-update] service restart succeeded on retry %d/%d", attempt, selfUpdateRestartRetryTimes)
@@ -0,0 +1,756 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcLoginPath      = "/login/oidc"
+	oidcCallbackPath   = "/login/oidc/callback"
+	oidcStateCookie    = "updater_oidc_state"
+	oidcStateTTL       = 10 * time.Minute
+	oidcMaxStates      = 1024
+	oidcHTTPTimeout    = 10 * time.Second
+	oidcDiscoveryTTL   = time.Hour
+	oidcJWKSMinRefresh = 30 * time.Second
+	oidcClockSkew      = 2 * time.Minute
+	oidcMaxBodyBytes   = 1 << 20
+)
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcLoginState struct {
+	Verifier    string
+	Nonce       string
+	RedirectURL string
+	ExpiresAt   time.Time
+}
+
+// oidcProvider 缓存身份提供方的发现文档与签名公钥；issuer 或 CA 文件变更后自动重建。
+// 所有请求均由本服务直接发往 issuer，只要本机能访问即可，issuer 可仅部署在内网。
+type oidcProvider struct {
+	mu          sync.Mutex
+	cacheKey    string
+	client      *http.Client
+	discovery   *oidcDiscovery
+	discoveryAt time.Time
+	keys        map[string]crypto.PublicKey
+	keysAt      time.Time
+	states      map[string]*oidcLoginState
+}
+
+func newOIDCProvider() *oidcProvider {
+	return &oidcProvider{states: make(map[string]*oidcLoginState)}
+}
+
+func normalizeIssuer(issuer string) string {
+	return strings.TrimRight(strings.TrimSpace(issuer), "/")
+}
+
+func newOIDCHTTPClient(caFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile = strings.TrimSpace(caFile); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 oidc_ca_file 失败: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("oidc_ca_file 中没有有效的 PEM 证书: %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Timeout: oidcHTTPTimeout, Transport: transport}, nil
+}
+
+// prepare 返回当前配置对应的发现文档与 HTTP 客户端。
+func (p *oidcProvider) prepare(cfg Config) (*oidcDiscovery, *http.Client, error) {
+	issuer := normalizeIssuer(cfg.OIDCIssuer)
+	key := issuer + "\n" + strings.TrimSpace(cfg.OIDCCAFile)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cacheKey != key || p.client == nil {
+		client, err := newOIDCHTTPClient(cfg.OIDCCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.cacheKey = key
+		p.client = client
+		p.discovery = nil
+		p.keys = nil
+		p.keysAt = time.Time{}
+	}
+	if p.discovery != nil && time.Since(p.discoveryAt) < oidcDiscoveryTTL {
+		return p.discovery, p.client, nil
+	}
+	var doc oidcDiscovery
+	if err := oidcGetJSON(p.client, issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, nil, fmt.Errorf("获取 OIDC 发现文档失败: %w", err)
+	}
+	if normalizeIssuer(doc.Issuer) != issuer {
+		return nil, nil, fmt.Errorf("发现文档 issuer 不匹配: %s", doc.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, nil, errors.New("发现文档缺少 authorization_endpoint / token_endpoint / jwks_uri")
+	}
+	p.discovery = &doc
+	p.discoveryAt = time.Now()
+	return p.discovery, p.client, nil
+}
+
+func oidcGetJSON(client *http.Client, endpoint string, out any) error {
+	resp, err := client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBodyBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回 HTTP %d", endpoint, resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// createState 登记一次登录状态；未完成的登录最多保留 oidcMaxStates 个，超出时淘汰最早发起的，
+// 避免未登录请求反复访问登录入口使状态表无限增长。
+func (p *oidcProvider) createState(st oidcLoginState) string {
+	token := randomHex(24)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, v := range p.states {
+		if now.After(v.ExpiresAt) {
+			delete(p.states, k)
+		}
+	}
+	for len(p.states) >= oidcMaxStates {
+		oldest := ""
+		for k, v := range p.states {
+			if oldest == "" || v.ExpiresAt.Before(p.states[oldest].ExpiresAt) {
+				oldest = k
+			}
+		}
+		delete(p.states, oldest)
+	}
+	st.ExpiresAt = now.Add(oidcStateTTL)
+	p.states[token] = &st
+	return token
+}
+
+// takeState 取出并作废登录状态，保证每个授权码回调只能使用一次。
+func (p *oidcProvider) takeState(token string) (oidcLoginState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.states[token]
+	if !ok {
+		return oidcLoginState{}, false
+	}
+	delete(p.states, token)
+	if time.Now().After(st.ExpiresAt) {
+		return oidcLoginState{}, false
+	}
+	return *st, true
+}
+
+// handleOIDCLogin 发起授权码登录（PKCE + state + nonce）并跳转到身份提供方。
+func (a *App) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	cfg := a.currentConfig()
+	if !cfg.OIDCEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if wait, ok := a.loginGuard.Check(a.clientIP(r)); !ok {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		a.renderLogin(w, map[string]any{"Error": fmt.Sprintf("登录失败次数过多，请 %s 后再试", formatLockoutWait(wait))})
+		return
+	}
+	doc, _, err := a.oidc.prepare(cfg)
+	if err != nil {
+		a.logger.Warn("OIDC 登录初始化失败", "issuer", cfg.OIDCIssuer, "error", err)
+		a.renderLogin(w, map[string]any{"Error": "单点登录暂不可用: " + err.Error()})
+		return
+	}
+	authURL, err := url.Parse(doc.AuthorizationEndpoint)
+	if err != nil {
+		a.renderLogin(w, map[string]any{"Error": "authorization_endpoint 格式错误"})
+		return
+	}
+	verifier := base64.RawURLEncoding.EncodeToString([]byte(randomHex(32)))
+	challenge := sha256.Sum256([]byte(verifier))
+	st := oidcLoginState{Verifier: verifier, Nonce: randomHex(16), RedirectURL: a.oidcRedirectURL(cfg, r)}
+	state := a.oidc.createState(st)
+
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.OIDCClientID)
+	q.Set("redirect_uri", st.RedirectURL)
+	q.Set("scope", strings.Join(oidcScopes(cfg), " "))
+	q.Set("state", state)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	// 回调来自身份提供方的跨站跳转，Strict 会话 Cookie 不会随之发送，state 绑定 Cookie 需使用 Lax。
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcLoginPath,
+		HttpOnly: true,
+		Secure:   a.tls != nil,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(oidcStateTTL),
+	})
+	http.Redirect(w, r, authURL.String(), http.StatusFound)
+}
+
+// handleOIDCCallback 校验 state，换取并验证 ID Token，按声明映射角色后建立会话。
+func (a *App) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	cfg := a.currentConfig()
+	if !cfg.OIDCEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	ip := a.clientIP(r)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: oidcLoginPath, HttpOnly: true, Secure: a.tls != nil, MaxAge: -1})
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		a.logger.Warn("OIDC 登录被身份提供方拒绝", "error", e, "description", q.Get("error_description"), "ip", ip)
+		a.renderLogin(w, map[string]any{"Error": "单点登录失败: " + firstNonEmpty(q.Get("error_description"), e)})
+		return
+	}
+	state := q.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if state == "" || err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		a.recordLoginFailure(ip, "", "OIDC state 不匹配")
+		a.renderLogin(w, map[string]any{"Error": "单点登录状态校验失败，请重新登录"})
+		return
+	}
+	st, ok := a.oidc.takeState(state)
+	if !ok {
+		a.renderLogin(w, map[string]any{"Error": "单点登录已过期，请重新登录"})
+		return
+	}
+	claims, err := a.oidcExchange(cfg, q.Get("code"), st)
+	if err != nil {
+		a.logger.Warn("OIDC 登录校验失败", "issuer", cfg.OIDCIssuer, "ip", ip, "error", err)
+		a.recordLoginFailure(ip, "", "OIDC 校验失败")
+		a.renderLogin(w, map[string]any{"Error": "单点登录失败: " + err.Error()})
+		return
+	}
+	user, err := a.provisionOIDCUser(cfg, claims)
+	if err != nil {
+		a.logger.Warn("OIDC 用户登录被拒绝", "sub", claimString(claims, "sub"), "ip", ip, "error", err)
+		a.recordLoginFailure(ip, claimString(claims, cfg.OIDCUsernameClaim), err.Error())
+		a.renderLogin(w, map[string]any{"Error": err.Error()})
+		return
+	}
+	// 与密码登录相同，已启用两步验证或系统要求两步验证时先完成第二步；第二步表单由本站页面提交，会话 Cookie 可正常写入。
+	if a.renderSecondFactorStep(w, cfg, user) {
+		return
+	}
+	a.startSession(w, r, user)
+	// 会话 Cookie 为 SameSite=Strict，直接 302 仍属于跨站跳转链，浏览器不会携带；改由本站页面再跳转一次。
+	a.renderLogin(w, map[string]any{"Step": "redirect", "Username": user.Username, "RedirectTo": "/"})
+}
+
+func (a *App) oidcRedirectURL(cfg Config, r *http.Request) string {
+	if v := strings.TrimSpace(cfg.OIDCRedirectURL); v != "" {
+		return v
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + oidcCallbackPath
+}
+
+func oidcScopes(cfg Config) []string {
+	scopes := []string{"openid"}
+	for _, s := range cfg.OIDCScopes {
+		if s = strings.TrimSpace(s); s != "" && s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+func (a *App) oidcExchange(cfg Config, code string, st oidcLoginState) (map[string]any, error) {
+	if code == "" {
+		return nil, errors.New("回调缺少授权码")
+	}
+	doc, client, err := a.oidc.prepare(cfg)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", st.RedirectURL)
+	form.Set("code_verifier", st.Verifier)
+	form.Set("client_id", cfg.OIDCClientID)
+	useBasic := cfg.OIDCClientSecret != "" && oidcPrefersBasicAuth(doc.TokenAuthMethods)
+	if cfg.OIDCClientSecret != "" && !useBasic {
+		form.Set("client_secret", cfg.OIDCClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(cfg.OIDCClientID), url.QueryEscape(cfg.OIDCClientSecret))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求 token_endpoint 失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.Unmarshal(body, &tokenResp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token_endpoint 返回 HTTP %d %s", resp.StatusCode, firstNonEmpty(tokenResp.ErrorDescription, tokenResp.Error))
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token 响应缺少 id_token")
+	}
+	return a.oidc.verifyIDToken(cfg, tokenResp.IDToken, st.Nonce)
+}
+
+// oidcPrefersBasicAuth 按发现文档选择客户端认证方式；未声明时按规范默认使用 client_secret_basic。
+func oidcPrefersBasicAuth(methods []string) bool {
+	if len(methods) == 0 {
+		return true
+	}
+	for _, m := range methods {
+		if m == "client_secret_basic" {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyIDToken 校验 ID Token 的签名（RS/PS/ES 系列）、issuer、audience、有效期与 nonce，返回声明。
+func (p *oidcProvider) verifyIDToken(cfg Config, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token 格式错误")
+	}
+	headerRaw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("id_token 头部解码失败")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerRaw, &header); err != nil {
+		return nil, errors.New("id_token 头部解析失败")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("id_token 签名解码失败")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	key, err := p.publicKey(header.Kid, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, signed, sig); err != nil {
+		// 身份提供方可能沿用 kid 轮换密钥，重新拉取一次 JWKS 后再校验。
+		if key, err = p.publicKey(header.Kid, true); err != nil {
+			return nil, err
+		}
+		if err := verifyJWTSignature(header.Alg, key, signed, sig); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("id_token 载荷解码失败")
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("id_token 载荷解析失败")
+	}
+	if normalizeIssuer(claimString(claims, "iss")) != normalizeIssuer(cfg.OIDCIssuer) {
+		return nil, errors.New("id_token issuer 不匹配")
+	}
+	audiences := claimStrings(claims, "aud")
+	audOK := false
+	for _, aud := range audiences {
+		if aud == cfg.OIDCClientID {
+			audOK = true
+			break
+		}
+	}
+	if !audOK {
+		return nil, errors.New("id_token audience 不包含当前 client_id")
+	}
+	if azp := claimString(claims, "azp"); len(audiences) > 1 && azp != cfg.OIDCClientID {
+		return nil, errors.New("id_token azp 不匹配")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id_token 已过期")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id_token 签发时间晚于当前时间，请检查时钟")
+	}
+	if subtle.ConstantTimeCompare([]byte(claimString(claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, errors.New("id_token nonce 不匹配")
+	}
+	if claimString(claims, "sub") == "" {
+		return nil, errors.New("id_token 缺少 sub")
+	}
+	return claims, nil
+}
+
+// publicKey 按 kid 查找签名公钥；未命中或 refresh 为 true 时重新拉取 JWKS 以适配密钥轮换，拉取间隔受 oidcJWKSMinRefresh 限制。
+func (p *oidcProvider) publicKey(kid string, refresh bool) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery == nil || p.client == nil {
+		return nil, errors.New("OIDC 发现文档尚未加载")
+	}
+	lookup := func() (crypto.PublicKey, bool) {
+		if kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				return k, true
+			}
+		}
+		k, ok := p.keys[kid]
+		return k, ok
+	}
+	if k, ok := lookup(); ok && !refresh {
+		return k, nil
+	}
+	if !p.keysAt.IsZero() && time.Since(p.keysAt) < oidcJWKSMinRefresh {
+		if refresh {
+			return nil, errors.New("id_token 签名校验失败")
+		}
+		return nil, fmt.Errorf("未找到 id_token 签名公钥: kid=%s", kid)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := oidcGetJSON(p.client, p.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("获取 JWKS 失败: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+	if k, ok := lookup(); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("未找到 id_token 签名公钥: kid=%s", kid)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("JWK 字段解码失败")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("JWK 指数无效")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("不支持的密钥类型: %s", k.Kty)
+	}
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		hash = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		hash = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("不支持的 id_token 签名算法: %s", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("签名算法与公钥类型不匹配")
+		}
+		var err error
+		if alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		} else {
+			err = rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+		if err != nil {
+			return errors.New("id_token 签名校验失败")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("签名算法与公钥类型不匹配")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("id_token 签名长度错误")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("id_token 签名校验失败")
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的 id_token 签名算法: %s", alg)
+	}
+}
+
+// claimValue 读取声明，支持用点号访问嵌套字段（如 Keycloak 的 realm_access.roles）。
+func claimValue(claims map[string]any, path string) any {
+	var cur any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+func claimString(claims map[string]any, path string) string {
+	s, _ := claimValue(claims, path).(string)
+	return strings.TrimSpace(s)
+}
+
+func claimStrings(claims map[string]any, path string) []string {
+	switch v := claimValue(claims, path).(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// oidcRole 将角色声明中的每个值按 oidc_role_mapping 映射，取权限最高的角色；均未命中时使用 oidc_default_role。
+func oidcRole(cfg Config, claims map[string]any) string {
+	role := ""
+	for _, v := range claimStrings(claims, cfg.OIDCRoleClaim) {
+		mapped, ok := cfg.OIDCRoleMapping[v]
+		if !ok {
+			continue
+		}
+		if role == "" || roleRank(mapped) > roleRank(role) {
+			role = normalizeRole(mapped)
+		}
+	}
+	if role == "" && strings.TrimSpace(cfg.OIDCDefaultRole) != "" {
+		role = normalizeRole(cfg.OIDCDefaultRole)
+	}
+	return role
+}
+
+// provisionOIDCUser 创建或更新单点登录用户，每次登录按身份提供方声明刷新角色。
+// 同名本地账号不会被接管，已禁用的用户仍然无法登录。
+func (a *App) provisionOIDCUser(cfg Config, claims map[string]any) (UserAccount, error) {
+	username := claimString(claims, cfg.OIDCUsernameClaim)
+	if !usernamePattern.MatchString(username) {
+		return UserAccount{}, fmt.Errorf("身份提供方返回的用户名（%s）无效或缺失", cfg.OIDCUsernameClaim)
+	}
+	role := oidcRole(cfg, claims)
+	if role == "" {
+		return UserAccount{}, fmt.Errorf("用户 %s 未被授予任何角色，请联系管理员", username)
+	}
+	subject := claimString(claims, "sub")
+	err := a.users.Save(username, func(u *UserAccount, exists bool) error {
+		if exists && u.OIDCSubject == "" {
+			return fmt.Errorf("本地用户 %s 已存在，不能通过单点登录登录", username)
+		}
+		if exists && u.OIDCSubject != subject {
+			return fmt.Errorf("用户 %s 已绑定其他单点登录账号", username)
+		}
+		if u.Disabled {
+			return fmt.Errorf("用户 %s 已被禁用", username)
+		}
+		u.OIDCSubject = subject
+		u.Role = role
+		return nil
+	})
+	if err != nil {
+		return UserAccount{}, err
+	}
+	user, ok := a.users.Get(username)
+	if !ok {
+		return UserAccount{}, fmt.Errorf("用户不存在: %s", username)
+	}
+	return user, nil
+}
+
+// parseOIDCRoleMappingText 解析每行一条的 “声明值=角色” 映射。
+func parseOIDCRoleMappingText(text string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, line := range splitLinesTrim(text) {
+		value, role, ok := strings.Cut(line, "=")
+		value, role = strings.TrimSpace(value), strings.ToLower(strings.TrimSpace(role))
+		if !ok || value == "" {
+			return nil, fmt.Errorf("角色映射格式错误: %s（示例: ops-team=operator）", line)
+		}
+		if role != RoleViewer && role != RoleOperator && role != RoleAdmin {
+			return nil, fmt.Errorf("角色映射 %s 的角色无效: %s", value, role)
+		}
+		out[value] = role
+	}
+	return out, nil
+}
+
+func oidcRoleMappingText(mapping map[string]string) string {
+	lines := make([]string, 0, len(mapping))
+	for k, v := range mapping {
+		lines = append(lines, k+"="+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func validateOIDCConfig(cfg Config) error {
+	if !cfg.OIDCEnabled {
+		return nil
+	}
+	issuer, err := url.Parse(normalizeIssuer(cfg.OIDCIssuer))
+	if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+		return errors.New("oidc_issuer 必须是 http(s) 地址")
+	}
+	if strings.TrimSpace(cfg.OIDCClientID) == "" {
+		return errors.New("oidc_client_id 不能为空")
+	}
+	if strings.TrimSpace(cfg.OIDCUsernameClaim) == "" {
+		return errors.New("oidc_username_claim 不能为空")
+	}
+	if v := strings.TrimSpace(cfg.OIDCRedirectURL); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" || u.Path != oidcCallbackPath {
+			return fmt.Errorf("oidc_redirect_url 必须是指向 %s 的完整地址", oidcCallbackPath)
+		}
+	}
+	if role := strings.ToLower(strings.TrimSpace(cfg.OIDCDefaultRole)); role != "" && role != RoleViewer && role != RoleOperator && role != RoleAdmin {
+		return fmt.Errorf("oidc_default_role 无效: %s", cfg.OIDCDefaultRole)
+	}
+	for value, role := range cfg.OIDCRoleMapping {
+		if role != RoleViewer && role != RoleOperator && role != RoleAdmin {
+			return fmt.Errorf("oidc_role_mapping[%s] 的角色无效: %s", value, role)
+		}
+	}
+	return nil
+}
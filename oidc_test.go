@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testOIDCClientID = "updater-test"
+
+// mockIssuer 是本地模拟身份提供方：提供发现文档、JWKS 与令牌端点，令牌端点返回 idToken 当前的值。
+type mockIssuer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	keys      []jsonWebKey
+	idToken   string
+	verifier  string
+	jwksFetch int
+}
+
+func newMockIssuer(t *testing.T, signers ...testSigner) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{}
+	for _, s := range signers {
+		m.keys = append(m.keys, s.jwk())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksFetch++
+		writeJSON(w, http.StatusOK, map[string]any{"keys": m.keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code") != "test-code" ||
+			r.FormValue("code_verifier") != m.verifier || r.FormValue("client_id") != testOIDCClientID {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id_token": m.idToken, "token_type": "Bearer"})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockIssuer) setToken(token string) {
+	m.mu.Lock()
+	m.idToken = token
+	m.mu.Unlock()
+}
+
+func (m *mockIssuer) setKeys(signers ...testSigner) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = m.keys[:0]
+	for _, s := range signers {
+		m.keys = append(m.keys, s.jwk())
+	}
+}
+
+func (m *mockIssuer) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jwksFetch
+}
+
+// exchange 以新的登录状态走一遍授权码换取与 ID Token 校验。
+func (m *mockIssuer) exchange(a *App) (map[string]any, error) {
+	st := oidcLoginState{Verifier: "test-verifier", Nonce: "test-nonce", RedirectURL: m.URL + "/callback"}
+	m.mu.Lock()
+	m.verifier = st.Verifier
+	m.mu.Unlock()
+	return a.oidcExchange(Config{OIDCIssuer: m.URL, OIDCClientID: testOIDCClientID}, "test-code", st)
+}
+
+func (m *mockIssuer) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":                m.URL,
+		"aud":                testOIDCClientID,
+		"sub":                "user-1",
+		"nonce":              "test-nonce",
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"preferred_username": "alice",
+	}
+}
+
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newTestSigner(t *testing.T, kid, alg string) testSigner {
+	t.Helper()
+	var (
+		key crypto.Signer
+		err error
+	)
+	if strings.HasPrefix(alg, "ES") {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{kid: kid, alg: alg, key: key}
+}
+
+func (s testSigner) jwk() jsonWebKey {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", Kid: s.kid, Use: "sig", N: enc(pub.N.Bytes()), E: enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jsonWebKey{Kty: "EC", Kid: s.kid, Use: "sig", Crv: "P-256", X: enc(pub.X.FillBytes(make([]byte, 32))), Y: enc(pub.Y.FillBytes(make([]byte, 32)))}
+	}
+	return jsonWebKey{}
+}
+
+func (s testSigner) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	var (
+		sig []byte
+		err error
+	)
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		if strings.HasPrefix(s.alg, "PS") {
+			sig, err = rsa.SignPSS(rand.Reader, key, crypto.SHA256, sum, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		} else {
+			sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum)
+		}
+	case *ecdsa.PrivateKey:
+		var r, ss *big.Int
+		r, ss, err = ecdsa.Sign(rand.Reader, key, sum)
+		if err == nil {
+			sig = make([]byte, 64)
+			r.FillBytes(sig[:32])
+			ss.FillBytes(sig[32:])
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCExchange(t *testing.T) {
+	rs := newTestSigner(t, "rs", "RS256")
+	ps := newTestSigner(t, "ps", "PS256")
+	es := newTestSigner(t, "es", "ES256")
+	m := newMockIssuer(t, rs, ps, es)
+
+	cases := []struct {
+		name    string
+		signer  testSigner
+		mutate  func(map[string]any)
+		tamper  bool
+		wantErr string
+	}{
+		{name: "RS256 正常登录", signer: rs},
+		{name: "PS256 正常登录", signer: ps},
+		{name: "ES256 正常登录", signer: es},
+		{name: "nonce 不匹配", signer: rs, mutate: func(c map[string]any) { c["nonce"] = "other-nonce" }, wantErr: "nonce 不匹配"},
+		{name: "audience 不匹配", signer: rs, mutate: func(c map[string]any) { c["aud"] = "other-client" }, wantErr: "audience"},
+		{name: "多个 audience 时 azp 不匹配", signer: rs, mutate: func(c map[string]any) {
+			c["aud"] = []string{testOIDCClientID, "other-client"}
+			c["azp"] = "other-client"
+		}, wantErr: "azp 不匹配"},
+		{name: "已过期", signer: es, mutate: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "已过期"},
+		{name: "issuer 不匹配", signer: rs, mutate: func(c map[string]any) { c["iss"] = "http://evil.example" }, wantErr: "issuer 不匹配"},
+		{name: "签名被篡改", signer: rs, tamper: true, wantErr: "签名校验失败"},
+		{name: "ES256 签名被篡改", signer: es, tamper: true, wantErr: "签名"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := m.claims()
+			if tc.mutate != nil {
+				tc.mutate(claims)
+			}
+			token := tc.signer.sign(t, claims)
+			if tc.tamper {
+				// 改动载荷而保留原签名。
+				parts := strings.Split(token, ".")
+				claims["sub"] = "attacker"
+				payload, _ := json.Marshal(claims)
+				token = parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			}
+			m.setToken(token)
+
+			a := &App{oidc: newOIDCProvider()}
+			got, err := m.exchange(a)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("期望登录成功，实际错误: %v", err)
+				}
+				if claimString(got, "sub") != "user-1" || claimString(got, "preferred_username") != "alice" {
+					t.Fatalf("声明不正确: %v", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("期望错误包含 %q，实际: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeRejectsBadTokenResponse(t *testing.T) {
+	rs := newTestSigner(t, "rs", "RS256")
+	m := newMockIssuer(t, rs)
+	m.setToken(rs.sign(t, m.claims()))
+	a := &App{oidc: newOIDCProvider()}
+	st := oidcLoginState{Verifier: "wrong-verifier", Nonce: "test-nonce", RedirectURL: m.URL + "/callback"}
+	m.mu.Lock()
+	m.verifier = "test-verifier"
+	m.mu.Unlock()
+	_, err := a.oidcExchange(Config{OIDCIssuer: m.URL, OIDCClientID: testOIDCClientID}, "test-code", st)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("期望令牌端点拒绝错误的 code_verifier，实际: %v", err)
+	}
+}
+
+func TestOIDCUnknownKidRefreshesJWKS(t *testing.T) {
+	oldKey := newTestSigner(t, "k1", "RS256")
+	newKey := newTestSigner(t, "k2", "ES256")
+	m := newMockIssuer(t, oldKey)
+	a := &App{oidc: newOIDCProvider()}
+
+	m.setToken(oldKey.sign(t, m.claims()))
+	if _, err := m.exchange(a); err != nil {
+		t.Fatalf("首次登录失败: %v", err)
+	}
+	if n := m.fetches(); n != 1 {
+		t.Fatalf("期望拉取 JWKS 1 次，实际 %d 次", n)
+	}
+
+	// 身份提供方轮换到新密钥；距上次拉取不足 oidcJWKSMinRefresh 时不重新拉取。
+	m.setKeys(oldKey, newKey)
+	m.setToken(newKey.sign(t, m.claims()))
+	if _, err := m.exchange(a); err == nil || !strings.Contains(err.Error(), "kid=k2") {
+		t.Fatalf("期望在最小拉取间隔内找不到新 kid，实际: %v", err)
+	}
+	if n := m.fetches(); n != 1 {
+		t.Fatalf("最小拉取间隔内不应重新拉取 JWKS，实际 %d 次", n)
+	}
+
+	a.oidc.mu.Lock()
+	a.oidc.keysAt = time.Now().Add(-oidcJWKSMinRefresh - time.Second)
+	a.oidc.mu.Unlock()
+	got, err := m.exchange(a)
+	if err != nil {
+		t.Fatalf("未知 kid 应触发重新拉取 JWKS 后登录成功，实际: %v", err)
+	}
+	if claimString(got, "sub") != "user-1" {
+		t.Fatalf("声明不正确: %v", got)
+	}
+	if n := m.fetches(); n != 2 {
+		t.Fatalf("期望拉取 JWKS 2 次，实际 %d 次", n)
+	}
+}
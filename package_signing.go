@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// packageSignatureFile 是压缩包内嵌签名文件名，部署时不会下发到目标目录。
+const packageSignatureFile = ".package.sig"
+
+const (
+	SignatureStatusVerified = "verified"
+	SignatureStatusUnsigned = "unsigned"
+	SignatureModeDetached   = "detached"
+	SignatureModeEmbedded   = "embedded"
+)
+
+type packageSignatureResult struct {
+	Status string
+	Mode   string
+	KeyID  string
+}
+
+// parseSigningPublicKey 支持 32 字节原始公钥或 SubjectPublicKeyInfo（openssl pkey -pubout 的 PEM 正文）的 base64。
+func parseSigningPublicKey(raw string) (ed25519.PublicKey, error) {
+	raw = strings.TrimSpace(raw)
+	if block, _ := pem.Decode([]byte(raw)); block != nil {
+		raw = base64.StdEncoding.EncodeToString(block.Bytes)
+	}
+	b, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("公钥不是合法的 base64: %v", err)
+	}
+	if len(b) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(b), nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析公钥: %v", err)
+	}
+	key, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("仅支持 ed25519 公钥")
+	}
+	return key, nil
+}
+
+func signingKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return "ed25519:" + hex.EncodeToString(sum[:8])
+}
+
+// normalizeSigningKeys 校验公钥并为未命名的公钥补全默认 ID（公钥 SHA-256 前缀）。
+func normalizeSigningKeys(keys []PackageSigningKey) ([]PackageSigningKey, error) {
+	out := make([]PackageSigningKey, 0, len(keys))
+	seen := map[string]struct{}{}
+	for _, k := range keys {
+		pub, err := parseSigningPublicKey(k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSpace(k.ID)
+		if id == "" {
+			id = signingKeyID(pub)
+		}
+		if _, dup := seen[id]; dup {
+			return nil, fmt.Errorf("签名公钥 ID 重复: %s", id)
+		}
+		seen[id] = struct{}{}
+		out = append(out, PackageSigningKey{ID: id, PublicKey: base64.StdEncoding.EncodeToString(pub)})
+	}
+	return out, nil
+}
+
+// parseSigningKeysText 解析每行一个的公钥配置，格式为 “公钥” 或 “ID 公钥”。
+func parseSigningKeysText(text string) ([]PackageSigningKey, error) {
+	keys := make([]PackageSigningKey, 0)
+	for _, line := range splitLinesTrim(text) {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			keys = append(keys, PackageSigningKey{PublicKey: fields[0]})
+		case 2:
+			keys = append(keys, PackageSigningKey{ID: fields[0], PublicKey: fields[1]})
+		default:
+			return nil, fmt.Errorf("签名公钥格式错误: %s", line)
+		}
+	}
+	return normalizeSigningKeys(keys)
+}
+
+// packageManifestDigest 计算压缩包内容清单摘要：除内嵌签名文件外，每个文件一行 “sha256  路径”，按路径排序后整体取 SHA-256。
+// 内嵌签名对该摘要签名，因此与压缩方式、文件顺序无关。
+func packageManifestDigest(zipPath string) (string, []byte, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	var sig []byte
+	lines := make([]string, 0, len(r.File))
+	for _, f := range r.File {
+		name := normalizeRelPath(f.Name)
+		if name == "" || f.FileInfo().IsDir() {
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return "", nil, err
+		}
+		if name == packageSignatureFile {
+			sig, err = io.ReadAll(io.LimitReader(src, 4096))
+			src.Close()
+			if err != nil {
+				return "", nil, err
+			}
+			continue
+		}
+		h := sha256.New()
+		_, copyErr := io.Copy(h, src)
+		src.Close()
+		if copyErr != nil {
+			return "", nil, copyErr
+		}
+		lines = append(lines, hex.EncodeToString(h.Sum(nil))+"  "+name+"\n")
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i][66:] < lines[j][66:]
+	})
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return hex.EncodeToString(sum[:]), sig, nil
+}
+
+func decodeSignature(raw string) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("签名不是合法的 base64: %v", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("签名长度错误: %d", len(sig))
+	}
+	return sig, nil
+}
+
+func matchSigningKey(keys []PackageSigningKey, message string, sig []byte) (string, bool) {
+	for _, k := range keys {
+		pub, err := parseSigningPublicKey(k.PublicKey)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, []byte(message), sig) {
+			return k.ID, true
+		}
+	}
+	return "", false
+}
+
+// verifyPackageSignature 校验分离签名（对压缩包 SHA-256 十六进制字符串签名）或内嵌签名文件。
+// 提供了签名但校验失败时一律拒绝；未提供签名时仅在程序要求签名时拒绝。
+func verifyPackageSignature(project ManagedProject, zipPath, packageSHA256, detached string) (packageSignatureResult, error) {
+	if strings.TrimSpace(detached) != "" {
+		sig, err := decodeSignature(detached)
+		if err != nil {
+			return packageSignatureResult{}, err
+		}
+		keyID, ok := matchSigningKey(project.SigningKeys, packageSHA256, sig)
+		if !ok {
+			return packageSignatureResult{}, errors.New("分离签名校验失败：没有匹配的受信公钥")
+		}
+		return packageSignatureResult{Status: SignatureStatusVerified, Mode: SignatureModeDetached, KeyID: keyID}, nil
+	}
+	digest, embedded, err := packageManifestDigest(zipPath)
+	if err != nil {
+		return packageSignatureResult{}, fmt.Errorf("读取压缩包失败: %v", err)
+	}
+	if len(embedded) > 0 {
+		sig, err := decodeSignature(string(embedded))
+		if err != nil {
+			return packageSignatureResult{}, fmt.Errorf("内嵌签名 %s 无效: %v", packageSignatureFile, err)
+		}
+		keyID, ok := matchSigningKey(project.SigningKeys, digest, sig)
+		if !ok {
+			return packageSignatureResult{}, errors.New("内嵌签名校验失败：没有匹配的受信公钥")
+		}
+		return packageSignatureResult{Status: SignatureStatusVerified, Mode: SignatureModeEmbedded, KeyID: keyID}, nil
+	}
+	if project.RequireSignature {
+		return packageSignatureResult{}, fmt.Errorf("程序 %s 要求部署包签名，请提供 signature 字段或在压缩包根目录放置 %s", project.Name, packageSignatureFile)
+	}
+	return packageSignatureResult{Status: SignatureStatusUnsigned}, nil
+}
+
+// readDetachedSignature 读取上传表单中的分离签名，支持同名的文本字段或文件字段（常规上传为 signature）。
+func readDetachedSignature(form *multipart.Form, field string) (string, error) {
+	if form == nil {
+		return "", nil
+	}
+	if v := form.Value[field]; len(v) > 0 && strings.TrimSpace(v[0]) != "" {
+		return v[0], nil
+	}
+	files := form.File[field]
+	if len(files) == 0 {
+		return "", nil
+	}
+	f, err := files[0].Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, 4096))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// tryRunSignPackage 提供命令行签名工具，便于 CI 生成分离签名或写入内嵌签名：
+//
+//	updater --sign-package -key signing.pem [-embed] package.zip
+//	updater --sign-package -gen-key signing.pem
+func tryRunSignPackage(args []string) (bool, error) {
+	if len(args) == 0 || strings.TrimSpace(args[0]) != "--sign-package" {
+		return false, nil
+	}
+	fs := flag.NewFlagSet("sign-package", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "ed25519 private key (PKCS#8 PEM)")
+	genKey := fs.String("gen-key", "", "generate a new private key to this path")
+	embed := fs.Bool("embed", false, "write the signature into the zip as "+packageSignatureFile)
+	if err := fs.Parse(args[1:]); err != nil {
+		return true, err
+	}
+	if *genKey != "" {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return true, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return true, err
+		}
+		if err := os.WriteFile(*genKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return true, err
+		}
+		fmt.Printf("key_id: %s\npublic_key: %s\n", signingKeyID(pub), base64.StdEncoding.EncodeToString(pub))
+		return true, nil
+	}
+	if *keyPath == "" || fs.NArg() != 1 {
+		return true, errors.New("usage: --sign-package -key signing.pem [-embed] package.zip")
+	}
+	priv, err := loadSigningPrivateKey(*keyPath)
+	if err != nil {
+		return true, err
+	}
+	zipPath := fs.Arg(0)
+	if *embed {
+		digest, _, err := packageManifestDigest(zipPath)
+		if err != nil {
+			return true, err
+		}
+		sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(digest)))
+		return true, appendZipEntry(zipPath, packageSignatureFile, []byte(sig))
+	}
+	sum, err := fileSHA256(zipPath)
+	if err != nil {
+		return true, err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(sum))))
+	return true, nil
+}
+
+func loadSigningPrivateKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("私钥不是 PEM 格式")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("仅支持 ed25519 私钥")
+	}
+	return priv, nil
+}
+
+// appendZipEntry 重写压缩包并替换/追加指定文件，其余条目原样拷贝。
+func appendZipEntry(zipPath, name string, content []byte) error {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	tmp := zipPath + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		if normalizeRelPath(f.Name) == name {
+			continue
+		}
+		if err := w.Copy(f); err != nil {
+			r.Close()
+			out.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	r.Close()
+	entry, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err == nil {
+		_, err = entry.Write(content)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, zipPath)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// 口令哈希使用 argon2id（RFC 9106 推荐参数），以 PHC 字符串格式保存：
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>。
+// 旧版无盐 SHA-256（64 位十六进制）仍可校验，登录成功后自动升级为 argon2id。
+const (
+	argon2Prefix  = "$argon2id$"
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+
+	// 每次计算占用 argon2Memory KiB 内存；同时计算的数量不超过 maxArgon2Concurrency，
+	// 等待 argon2SlotWait 仍无空位时直接拒绝，避免并发登录请求耗尽内存。
+	maxArgon2Concurrency = 4
+	argon2SlotWait       = 500 * time.Millisecond
+)
+
+// errPasswordHashBusy 表示同时进行的口令计算过多，本次请求被拒绝。
+var errPasswordHashBusy = errors.New("口令校验请求过多，请稍后重试")
+
+var (
+	// defaultHashCache 缓存 argon2id 哈希是否为默认密码，避免登录页每次渲染都重新计算。
+	defaultHashCache sync.Map
+
+	dummyHashOnce sync.Once
+	dummyHash     string
+
+	argon2Slots = make(chan struct{}, max(1, min(runtime.GOMAXPROCS(0), maxArgon2Concurrency)))
+)
+
+// argon2IDKey 在并发上限内计算 argon2id。
+func argon2IDKey(password, salt []byte, iterations, memory uint32, threads uint8, keyLen uint32) ([]byte, error) {
+	timer := time.NewTimer(argon2SlotWait)
+	defer timer.Stop()
+	select {
+	case argon2Slots <- struct{}{}:
+	case <-timer.C:
+		return nil, errPasswordHashBusy
+	}
+	defer func() { <-argon2Slots }()
+	return argon2.IDKey(password, salt, iterations, memory, threads, keyLen), nil
+}
+
+// hashPassword 生成带随机盐的 argon2id 口令哈希。
+func hashPassword(plain string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("生成口令盐失败: %w", err)
+	}
+	key, err := argon2IDKey([]byte(plain), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2Hash(hash string) (argon2Params, bool) {
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2Params{}, false
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, false
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return argon2Params{}, false
+	}
+	if p.memory == 0 || p.time == 0 || p.threads == 0 || p.memory > 1024*1024 || p.time > 64 {
+		return argon2Params{}, false
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(p.salt) == 0 {
+		return argon2Params{}, false
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return argon2Params{}, false
+	}
+	return p, true
+}
+
+// isArgon2Hash 判断存储的哈希是否已是 argon2id 格式。
+func isArgon2Hash(hash string) bool {
+	return strings.HasPrefix(strings.TrimSpace(hash), argon2Prefix)
+}
+
+// normalizePasswordHash 规范化存储的哈希：旧版 SHA-256 统一为小写十六进制，argon2id 保持原样（base64 区分大小写）。
+func normalizePasswordHash(hash string) string {
+	hash = strings.TrimSpace(hash)
+	if isArgon2Hash(hash) {
+		return hash
+	}
+	return strings.ToLower(hash)
+}
+
+// passwordNeedsRehash 判断哈希是否为旧版 SHA-256 或参数低于当前设置，需要在登录成功后升级。
+func passwordNeedsRehash(hash string) bool {
+	p, ok := parseArgon2Hash(strings.TrimSpace(hash))
+	if !ok {
+		return true
+	}
+	return p.memory != argon2Memory || p.time != argon2Time || p.threads != argon2Threads || len(p.key) != argon2KeyLen
+}
+
+// dummyPasswordCheck 在用户不存在时执行一次等价耗时的校验，避免通过响应时间探测用户名。
+func dummyPasswordCheck(input string) error {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hashPassword(randomHex(16))
+	})
+	if dummyHash == "" {
+		return nil
+	}
+	_, err := verifyPassword(dummyHash, input)
+	return err
+}
+
+// verifyPassword 校验口令；只有并发计算过多时返回 errPasswordHashBusy，此时结果无意义，不应计为失败。
+func verifyPassword(expectedHash, input string) (bool, error) {
+	expectedHash = strings.TrimSpace(expectedHash)
+	if isArgon2Hash(expectedHash) {
+		p, ok := parseArgon2Hash(expectedHash)
+		if !ok {
+			return false, nil
+		}
+		got, err := argon2IDKey([]byte(input), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		if err != nil {
+			return false, err
+		}
+		return subtle.ConstantTimeCompare(got, p.key) == 1, nil
+	}
+	want := strings.ToLower(expectedHash)
+	got := strings.ToLower(sha256Hex(input))
+	if len(want) != len(got) {
+		return false, nil
+	}
+	matched := byte(1)
+	for i := 0; i < len(want); i++ {
+		if want[i] != got[i] {
+			matched = 0
+		}
+	}
+	return matched == 1, nil
+}
+
+func isDefaultAuthHash(hash string) bool {
+	want := strings.TrimSpace(hash)
+	if !isArgon2Hash(want) {
+		return strings.ToLower(want) == strings.ToLower(sha256Hex(defaultAuthKey))
+	}
+	if v, ok := defaultHashCache.Load(want); ok {
+		return v.(bool)
+	}
+	matched, err := verifyPassword(want, defaultAuthKey)
+	if err != nil {
+		return false
+	}
+	defaultHashCache.Store(want, matched)
+	return matched
+}
+
+// upgradePasswordHash 在密码校验通过后把旧版 SHA-256 哈希升级为 argon2id；期间密码已被修改则放弃。
+// 升级失败只记日志，不影响本次登录。
+func (a *App) upgradePasswordHash(username, oldHash, plain string) {
+	newHash, err := hashPassword(plain)
+	if err == nil {
+		err = a.users.Save(username, func(u *UserAccount, exists bool) error {
+			if !exists {
+				return fmt.Errorf("用户不存在: %s", username)
+			}
+			if u.PasswordHash == oldHash {
+				u.PasswordHash = newHash
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		a.logger.Warn("升级密码哈希失败", "username", username, "error", err)
+		return
+	}
+	a.logger.Info("密码哈希已升级为 argon2id", "username", username)
+}
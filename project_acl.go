@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ProjectActionConfig 允许非 admin 用户修改指定程序的配置；仅用于用户授权，API 令牌不支持。
+const ProjectActionConfig = "config"
+
+var grantActions = []string{TokenActionDeploy, TokenActionPreview, TokenActionRollback, TokenActionRead, ProjectActionConfig}
+
+// Allows 判断当前身份能否对指定程序执行动作。
+//   - API 令牌：程序在 projects 范围内且动作在 actions 内；
+//   - admin：不受限；
+//   - 未配置授权的用户：沿用角色权限（不含 config）；
+//   - 配置了授权的用户：仅限授权列表中的程序与动作，任一授权都隐含 read。
+func (p authPrincipal) Allows(projectID, action string) bool {
+	if p.Token != nil {
+		return p.Token.AllowsProject(projectID) && p.Token.AllowsAction(action)
+	}
+	if roleAllows(p.Role, RoleAdmin) {
+		return true
+	}
+	if len(p.Grants) == 0 {
+		return action != ProjectActionConfig
+	}
+	for _, g := range p.Grants {
+		if g.Project != "*" && g.Project != projectID {
+			continue
+		}
+		if action == TokenActionRead {
+			return true
+		}
+		for _, a := range g.Actions {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CanAccessProject 判断当前身份能否查看指定程序及其部署记录；API 令牌按 projects 范围限制，用户按授权限制。
+func (p authPrincipal) CanAccessProject(projectID string) bool {
+	if p.Token != nil {
+		return p.Token.AllowsProject(projectID)
+	}
+	return p.Allows(projectID, TokenActionRead)
+}
+
+// CanEditAnyProject 用于控制台决定是否显示程序配置的保存按钮。
+func (p authPrincipal) CanEditAnyProject(projects []ManagedProject) bool {
+	for _, proj := range projects {
+		if p.Allows(proj.ID, ProjectActionConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleConfig 返回仅包含当前身份可见程序的配置副本；默认程序不可见时改用第一个可见程序，非 admin 看不到部署钩子与健康检查。
+func visibleConfig(p authPrincipal, cfg Config) Config {
+	isAdmin := p.Token == nil && roleAllows(p.Role, RoleAdmin)
+	visible := make([]ManagedProject, 0, len(cfg.Projects))
+	for _, proj := range cfg.Projects {
+		if !p.CanAccessProject(proj.ID) {
+			continue
+		}
+		if !isAdmin {
+			proj = redactProjectCommands(proj)
+		}
+		visible = append(visible, proj)
+	}
+	total := len(cfg.Projects)
+	cfg.Projects = visible
+	if len(visible) == total {
+		return cfg
+	}
+	if _, ok := findProjectByID(visible, cfg.DefaultProjectID); !ok {
+		cfg.DefaultProjectID = ""
+		if len(visible) > 0 {
+			cfg.DefaultProjectID = visible[0].ID
+		}
+	}
+	if len(visible) == 0 {
+		// 顶层旧版字段是默认程序的镜像，无可见程序时一并清空，避免泄露目标目录等信息。
+		cfg.ServiceName = ""
+		cfg.TargetDir = ""
+		cfg.CurrentVersion = ""
+		cfg.BackupIgnore = nil
+		cfg.ReplaceIgnore = nil
+	}
+	return cfg
+}
+
+// redactProjectCommands 去掉部署钩子与健康检查（含各实例的健康检查）：其中的命令行、地址与请求头只向 admin 展示。
+func redactProjectCommands(proj ManagedProject) ManagedProject {
+	proj.Hooks = nil
+	proj.HealthCheck = nil
+	if len(proj.Instances) > 0 {
+		instances := make([]ProjectInstance, len(proj.Instances))
+		for i, inst := range proj.Instances {
+			inst.HealthCheck = nil
+			instances[i] = inst
+		}
+		proj.Instances = instances
+	}
+	return proj
+}
+
+// parseGrantsText 解析每行一条的程序授权，格式为 “程序ID: 动作,动作”，程序 ID 可用 * 表示全部程序。
+func parseGrantsText(text string, projects []ManagedProject) ([]ProjectGrant, error) {
+	grants := make([]ProjectGrant, 0)
+	seen := make(map[string]bool)
+	for _, line := range splitLinesTrim(text) {
+		projectID, rawActions, ok := strings.Cut(line, ":")
+		if !ok {
+			projectID, rawActions, ok = strings.Cut(line, "：")
+		}
+		projectID = strings.TrimSpace(projectID)
+		if !ok || projectID == "" {
+			return nil, fmt.Errorf("授权格式错误: %s（示例: demo: deploy,rollback）", line)
+		}
+		if projectID != "*" {
+			if _, exists := findProjectByID(projects, projectID); !exists {
+				return nil, fmt.Errorf("未找到程序: %s", projectID)
+			}
+		}
+		if seen[projectID] {
+			return nil, fmt.Errorf("程序授权重复: %s", projectID)
+		}
+		seen[projectID] = true
+		actions, err := normalizeGrantActions(rawActions)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, ProjectGrant{Project: projectID, Actions: actions})
+	}
+	return grants, nil
+}
+
+func normalizeGrantActions(raw string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, v := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '，' }) {
+		v = strings.ToLower(strings.TrimSpace(v))
+		valid := false
+		for _, a := range grantActions {
+			if a == v {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("未知授权动作: %s，可选 %s", v, strings.Join(grantActions, " / "))
+		}
+		seen[v] = true
+	}
+	out := make([]string, 0, len(grantActions))
+	for _, a := range grantActions {
+		if seen[a] {
+			out = append(out, a)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errors.New("每条授权至少需要一个动作")
+	}
+	return out, nil
+}
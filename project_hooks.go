@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HookStageBeforeBackup = "before_backup"
+	HookStageAfterStop    = "after_stop"
+	HookStageAfterReplace = "after_replace"
+	HookStageAfterStart   = "after_start"
+	HookStageOnFailure    = "on_failure"
+
+	defaultHookTimeoutSec = 300
+	maxHookTimeoutSec     = 3600
+	hookWaitDelay         = 5 * time.Second
+	hookMaxLineBytes      = 64 * 1024
+)
+
+var hookStages = []string{HookStageBeforeBackup, HookStageAfterStop, HookStageAfterReplace, HookStageAfterStart, HookStageOnFailure}
+
+var hookStageLabels = map[string]string{
+	HookStageBeforeBackup: "备份前钩子",
+	HookStageAfterStop:    "停止服务后钩子",
+	HookStageAfterReplace: "替换文件后钩子",
+	HookStageAfterStart:   "启动服务后钩子",
+	HookStageOnFailure:    "失败钩子",
+}
+
+// hookContext 描述一次部署，以 UPDATER_* 环境变量传给钩子命令。
+type hookContext struct {
+	DeploymentID string
+	ProjectID    string
+	InstanceID   string
+	Version      string
+	TargetDir    string
+	BackupFile   string
+	PackageDir   string
+	Error        string
+}
+
+// env 生成钩子环境变量；路径统一转为绝对路径，钩子在目标目录下运行时也能直接使用。
+func (c hookContext) env(stage string) []string {
+	abs := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		if v, err := filepath.Abs(p); err == nil {
+			return v
+		}
+		return p
+	}
+	return []string{
+		"UPDATER_STAGE=" + stage,
+		"UPDATER_DEPLOYMENT_ID=" + c.DeploymentID,
+		"UPDATER_PROJECT_ID=" + c.ProjectID,
+		"UPDATER_INSTANCE_ID=" + c.InstanceID,
+		"UPDATER_VERSION=" + c.Version,
+		"UPDATER_TARGET_DIR=" + abs(c.TargetDir),
+		"UPDATER_BACKUP_FILE=" + abs(c.BackupFile),
+		"UPDATER_PACKAGE_DIR=" + abs(c.PackageDir),
+		"UPDATER_ERROR=" + c.Error,
+	}
+}
+
+func normalizeProjectHooks(hooks []ProjectHook) ([]ProjectHook, error) {
+	out := make([]ProjectHook, 0, len(hooks))
+	for i, h := range hooks {
+		h.Stage = strings.ToLower(strings.TrimSpace(h.Stage))
+		h.Command = strings.TrimSpace(h.Command)
+		h.WorkDir = strings.TrimSpace(h.WorkDir)
+		valid := false
+		for _, s := range hookStages {
+			if s == h.Stage {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("hooks[%d].stage 无效: %s，可选 %s", i, h.Stage, strings.Join(hookStages, " / "))
+		}
+		if h.Command == "" {
+			return nil, fmt.Errorf("hooks[%d].command 不能为空", i)
+		}
+		if h.TimeoutSec < 0 || h.TimeoutSec > maxHookTimeoutSec {
+			return nil, fmt.Errorf("hooks[%d].timeout_sec 必须在 0-%d 之间（0 表示默认 %d 秒）", i, maxHookTimeoutSec, defaultHookTimeoutSec)
+		}
+		out = append(out, h)
+	}
+	return out, nil
+}
+
+// runProjectHooks 依次执行指定阶段的钩子，任一钩子失败即返回错误，由调用方判定部署失败。
+// ctx 取消时结束正在执行的钩子进程。
+func (a *App) runProjectHooks(ctx context.Context, project ManagedProject, stage string, progress int, hc hookContext) error {
+	for _, h := range project.Hooks {
+		if h.Stage != stage {
+			continue
+		}
+		if err := a.runProjectHook(ctx, h, progress, hc); err != nil {
+			return fmt.Errorf("%s执行失败: %w", hookStageLabels[stage], err)
+		}
+	}
+	return nil
+}
+
+func (a *App) runProjectHook(parent context.Context, h ProjectHook, progress int, hc hookContext) error {
+	label := hookStageLabels[h.Stage]
+	timeout := time.Duration(h.TimeoutSec) * time.Second
+	if h.TimeoutSec <= 0 {
+		timeout = defaultHookTimeoutSec * time.Second
+	}
+	dir := h.WorkDir
+	if dir == "" {
+		dir = hc.TargetDir
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(hc.TargetDir, dir)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		if h.WorkDir != "" {
+			return fmt.Errorf("工作目录不存在: %s", dir)
+		}
+		// 首次部署时目标目录可能尚未创建，默认工作目录退回到本服务的当前目录。
+		dir = ""
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	cmd := hookShellCommand(ctx, h.Command)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), hc.env(h.Stage)...)
+	cmd.WaitDelay = hookWaitDelay
+	publishLine := func(level string) func(string) {
+		return func(line string) {
+			a.publishProgress(hc.DeploymentID, level, label, progress, "[hook] %s", line)
+		}
+	}
+	stdout := &hookLineWriter{emit: publishLine("info")}
+	stderr := &hookLineWriter{emit: publishLine("warn")}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	a.publishProgress(hc.DeploymentID, "info", label, progress, "执行%s: %s", label, h.Command)
+	started := time.Now()
+	err := cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+	if parent.Err() != nil {
+		return fmt.Errorf("已中止: %s", h.Command)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("超时（%s）: %s", timeout, h.Command)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// 命令本身已成功退出，只是后台子进程仍占用输出管道，不视为失败。
+		a.publishProgress(hc.DeploymentID, "warn", label, progress, "%s已退出，但仍有后台进程占用输出，已停止读取", label)
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.Command, err)
+	}
+	a.publishProgress(hc.DeploymentID, "info", label, progress, "%s完成，耗时 %d ms", label, time.Since(started).Milliseconds())
+	return nil
+}
+
+// hookLineWriter 把钩子输出按行转发到部署日志，超长行按上限分段输出。
+type hookLineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(string)
+}
+
+func (w *hookLineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			if len(w.buf) >= hookMaxLineBytes {
+				w.emitLocked(w.buf)
+				w.buf = w.buf[:0]
+			}
+			return len(p), nil
+		}
+		w.emitLocked(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *hookLineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.emitLocked(w.buf)
+	w.buf = nil
+}
+
+func (w *hookLineWriter) emitLocked(line []byte) {
+	if text := strings.TrimRight(string(line), "\r"); strings.TrimSpace(text) != "" {
+		w.emit(text)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// hookShellCommand 通过 cmd.exe 执行钩子命令；直接拼接命令行，保留用户填写的引号。
+func hookShellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd.exe")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CmdLine:    `cmd.exe /D /S /C "` + command + `"`,
+		HideWindow: true,
+	}
+	return cmd
+}
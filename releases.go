@@ -0,0 +1,966 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 多程序发布（release）把一组程序的部署包作为一次发布提交：按依赖顺序逐个创建部署记录，
+// 交给各程序的部署队列用 runDeployment 执行；任一程序未成功或发布被中止时，
+// 按相反顺序用备份回退本次发布中已完成的程序。
+const (
+	ReleaseStatusRunning   = "running"
+	ReleaseStatusReverting = "reverting"
+	ReleaseStatusSuccess   = "success"
+	ReleaseStatusFailed    = "failed"
+
+	ReleaseMemberPending = "pending"
+	ReleaseMemberRunning = "running"
+	ReleaseMemberSkipped = "skipped"
+
+	releasePollInterval = 500 * time.Millisecond
+	// 回退等待程序当前任务结束的最长时间，以及等待期间输出提示的间隔。
+	releaseRevertWait   = 30 * time.Minute
+	releaseRevertNotice = time.Minute
+)
+
+type Release struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Note       string          `json:"note"`
+	Status     string          `json:"status"`
+	LoginIP    string          `json:"login_ip"`
+	Operator   string          `json:"operator,omitempty"`
+	TokenID    string          `json:"token_id,omitempty"`
+	TokenName  string          `json:"token_name,omitempty"`
+	AbortedBy  string          `json:"aborted_by,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+	Error      string          `json:"error,omitempty"`
+	Members    []ReleaseMember `json:"members"`
+}
+
+// ReleaseMember 是发布中的一个程序，Members 按执行顺序保存。
+type ReleaseMember struct {
+	ProjectID       string   `json:"project_id"`
+	ProjectName     string   `json:"project_name,omitempty"`
+	DependsOn       []string `json:"depends_on,omitempty"`
+	Version         string   `json:"version"`
+	PreviousVersion string   `json:"previous_version,omitempty"`
+	ReplaceMode     string   `json:"replace_mode,omitempty"`
+	UploadFile      string   `json:"upload_file,omitempty"`
+	PackageSHA256   string   `json:"package_sha256,omitempty"`
+	SignatureStatus string   `json:"signature_status,omitempty"`
+	SignatureMode   string   `json:"signature_mode,omitempty"`
+	SignerKeyID     string   `json:"signer_key_id,omitempty"`
+	Status          string   `json:"status"`
+	DeploymentID    string   `json:"deployment_id,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	RollbackID      string   `json:"rollback_id,omitempty"`
+	RevertStatus    string   `json:"revert_status,omitempty"`
+	RevertError     string   `json:"revert_error,omitempty"`
+}
+
+type releaseStore struct {
+	mu   sync.Mutex
+	file string
+	list []Release
+}
+
+func newReleaseStore(file string) (*releaseStore, error) {
+	s := &releaseStore{
+		file: file,
+		list: make([]Release, 0),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *releaseStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := os.ReadFile(s.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.list = []Release{}
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		s.list = []Release{}
+		return nil
+	}
+	var out []Release
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	s.list = out
+	return nil
+}
+
+func (s *releaseStore) saveLocked() error {
+	raw, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.file); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *releaseStore) Add(rel Release) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, rel)
+	return s.saveLocked()
+}
+
+func (s *releaseStore) UpdateField(id string, fn func(rel *Release)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.list {
+		if s.list[i].ID == id {
+			fn(&s.list[i])
+			return s.saveLocked()
+		}
+	}
+	return errors.New("release not found")
+}
+
+func (s *releaseStore) Get(id string) (Release, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.list {
+		if s.list[i].ID == id {
+			out := s.list[i]
+			out.Members = append([]ReleaseMember{}, out.Members...)
+			return out, true
+		}
+	}
+	return Release{}, false
+}
+
+func (s *releaseStore) List() []Release {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Release, len(s.list))
+	for i := range s.list {
+		out[i] = s.list[i]
+		out[i].Members = append([]ReleaseMember{}, s.list[i].Members...)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+func releaseMemberLabel(m ReleaseMember) string {
+	return firstNonEmpty(m.ProjectName, m.ProjectID)
+}
+
+// canAccessRelease 要求能访问发布中的全部程序；action 非空时还要求对每个程序都有该操作权限。
+func canAccessRelease(p authPrincipal, rel Release, action string) bool {
+	for _, m := range rel.Members {
+		if action != "" && !p.Allows(m.ProjectID, action) {
+			return false
+		}
+		if !p.CanAccessProject(m.ProjectID) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *App) visibleReleases(r *http.Request) []Release {
+	principal := principalFromRequest(r)
+	all := a.releases.List()
+	out := make([]Release, 0, len(all))
+	for _, rel := range all {
+		if canAccessRelease(principal, rel, "") {
+			out = append(out, rel)
+		}
+	}
+	return out
+}
+
+// orderReleaseMembers 按 depends_on 排出执行顺序：被依赖的程序先执行，其余保持提交顺序。
+func orderReleaseMembers(members []ReleaseMember) ([]ReleaseMember, error) {
+	index := make(map[string]int, len(members))
+	for i, m := range members {
+		index[m.ProjectID] = i
+	}
+	for _, m := range members {
+		for _, dep := range m.DependsOn {
+			if dep == m.ProjectID {
+				return nil, fmt.Errorf("程序 %s 不能依赖自身", m.ProjectID)
+			}
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("程序 %s 依赖的 %s 不在本次发布中", m.ProjectID, dep)
+			}
+		}
+	}
+	done := make([]bool, len(members))
+	out := make([]ReleaseMember, 0, len(members))
+	for len(out) < len(members) {
+		next := -1
+		for i, m := range members {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, dep := range m.DependsOn {
+				if !done[index[dep]] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			rest := make([]string, 0)
+			for i, m := range members {
+				if !done[i] {
+					rest = append(rest, m.ProjectID)
+				}
+			}
+			return nil, fmt.Errorf("程序之间存在循环依赖: %s", strings.Join(rest, ", "))
+		}
+		done[next] = true
+		out = append(out, members[next])
+	}
+	return out, nil
+}
+
+// releaseMemberRequest 是创建发布时 members 字段（JSON 数组）中的一项。
+type releaseMemberRequest struct {
+	ProjectID      string   `json:"project_id"`
+	Version        string   `json:"version"`
+	ReplaceMode    string   `json:"replace_mode"`
+	DependsOn      []string `json:"depends_on"`
+	ExpectedSHA256 string   `json:"expected_sha256"`
+}
+
+// handleReleasesAPI 处理 GET /api/releases（发布列表）与 POST /api/releases（创建发布）。
+func (a *App) handleReleasesAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"items": a.visibleReleases(r)})
+	case http.MethodPost:
+		a.handleCreateRelease(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCreateRelease 接收 multipart 表单：members 为成员 JSON 数组，每个程序的部署包放在文件字段
+// package_<程序ID>，分离签名可放在 signature_<程序ID>。全部成员校验通过后才保存记录并开始执行。
+func (a *App) handleCreateRelease(w http.ResponseWriter, r *http.Request) {
+	cfg := a.currentConfig()
+	maxBytes := maxProjectUploadBytes(cfg) * int64(max(len(cfg.Projects), 1))
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("上传数据解析失败: %v", err)})
+		return
+	}
+	var reqs []releaseMemberRequest
+	if err := json.Unmarshal([]byte(r.FormValue("members")), &reqs); err != nil || len(reqs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "members 必须为非空 JSON 数组，如 [{\"project_id\":\"api\"},{\"project_id\":\"web\",\"depends_on\":[\"api\"]}]"})
+		return
+	}
+
+	principal := principalFromRequest(r)
+	id := newID("rel")
+	saved := make([]string, 0, len(reqs))
+	fail := func(status int, format string, args ...any) {
+		for _, path := range saved {
+			_ = os.Remove(path)
+		}
+		writeJSON(w, status, map[string]any{"error": fmt.Sprintf(format, args...)})
+	}
+
+	members := make([]ReleaseMember, 0, len(reqs))
+	seen := map[string]bool{}
+	for i, req := range reqs {
+		projectID := strings.TrimSpace(req.ProjectID)
+		project, found := findProjectByID(cfg.Projects, projectID)
+		if !found {
+			fail(http.StatusBadRequest, "未找到程序: %s", projectID)
+			return
+		}
+		if seen[project.ID] {
+			fail(http.StatusBadRequest, "程序 %s 在发布中重复出现", project.ID)
+			return
+		}
+		seen[project.ID] = true
+		if !principal.Allows(project.ID, TokenActionDeploy) {
+			fail(http.StatusForbidden, "无权操作程序: %s", project.ID)
+			return
+		}
+		if project.RequireApproval {
+			fail(http.StatusBadRequest, "程序 %s 需要审批，暂不支持加入多程序发布", project.Name)
+			return
+		}
+		targetExists, targetEmpty, err := inspectTargetDirState(project.TargetDir)
+		if err != nil {
+			fail(http.StatusInternalServerError, "检查程序 %s 目标目录失败: %v", project.Name, err)
+			return
+		}
+		if !targetExists || targetEmpty {
+			fail(http.StatusConflict, "程序 %s 的目标目录为空或不存在，请先通过“首次部署专页”完成首次部署", project.Name)
+			return
+		}
+
+		field := "package_" + project.ID
+		file, header, err := r.FormFile(field)
+		if err != nil {
+			fail(http.StatusBadRequest, "缺少程序 %s 的上传文件字段 %s", project.Name, field)
+			return
+		}
+		if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
+			file.Close()
+			fail(http.StatusBadRequest, "程序 %s 的部署包仅支持 .zip 文件", project.Name)
+			return
+		}
+		uploadPath := filepath.Join(cfg.UploadDir, fmt.Sprintf("%s-%d.zip", id, i+1))
+		packageSHA256, err := saveMultipartFile(file, uploadPath)
+		file.Close()
+		saved = append(saved, uploadPath)
+		if err != nil {
+			fail(http.StatusInternalServerError, "保存程序 %s 的上传文件失败: %v", project.Name, err)
+			return
+		}
+		if err := checkExpectedSHA256(req.ExpectedSHA256, packageSHA256); err != nil {
+			fail(http.StatusBadRequest, "程序 %s: %v", project.Name, err)
+			return
+		}
+		if info, statErr := os.Stat(uploadPath); statErr == nil {
+			if limit := project.MaxUploadMB * 1024 * 1024; limit > 0 && info.Size() > limit {
+				fail(http.StatusBadRequest, "文件超过程序 %s 的上传限制: %d MB", project.Name, project.MaxUploadMB)
+				return
+			}
+		}
+		detachedSig, err := readDetachedSignature(r.MultipartForm, "signature_"+project.ID)
+		if err != nil {
+			fail(http.StatusBadRequest, "读取程序 %s 的签名失败: %v", project.Name, err)
+			return
+		}
+		signature, err := verifyPackageSignature(project, uploadPath, packageSHA256, detachedSig)
+		if err != nil {
+			a.logger.Warn("部署包签名校验失败", "project_id", project.ID, "release_id", id, "sha256", packageSHA256, "operator", principal.Username, "ip", a.clientIP(r), "error", err)
+			fail(http.StatusBadRequest, "%v", err)
+			return
+		}
+
+		version := normalizeVersion(req.Version)
+		if version == "" {
+			if version, err = nextPatchVersion(project.CurrentVersion); err != nil {
+				fail(http.StatusBadRequest, "程序 %s 当前版本格式错误，无法自动递增: %v", project.Name, err)
+				return
+			}
+		}
+		if !isValidVersion(version) {
+			fail(http.StatusBadRequest, "程序 %s 版本号格式错误: %s，正确格式示例: 0.0.2 / 0.1.1 / 1.0.1", project.Name, version)
+			return
+		}
+		replaceMode := normalizeReplaceMode(req.ReplaceMode)
+		if strings.TrimSpace(req.ReplaceMode) == "" {
+			replaceMode = normalizeReplaceMode(project.DefaultReplaceMode)
+		}
+		dependsOn := make([]string, 0, len(req.DependsOn))
+		for _, dep := range req.DependsOn {
+			if dep = strings.TrimSpace(dep); dep != "" {
+				dependsOn = append(dependsOn, dep)
+			}
+		}
+		members = append(members, ReleaseMember{
+			ProjectID:       project.ID,
+			ProjectName:     project.Name,
+			DependsOn:       dependsOn,
+			Version:         version,
+			ReplaceMode:     replaceMode,
+			UploadFile:      uploadPath,
+			PackageSHA256:   packageSHA256,
+			SignatureStatus: signature.Status,
+			SignatureMode:   signature.Mode,
+			SignerKeyID:     signature.KeyID,
+			Status:          ReleaseMemberPending,
+		})
+	}
+	ordered, err := orderReleaseMembers(members)
+	if err != nil {
+		fail(http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	rel := Release{
+		ID:        id,
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Note:      strings.TrimSpace(r.FormValue("note")),
+		Status:    ReleaseStatusRunning,
+		LoginIP:   a.clientIP(r),
+		Operator:  principal.Username,
+		TokenID:   principal.TokenID(),
+		TokenName: principal.TokenName(),
+		CreatedAt: time.Now(),
+		Members:   ordered,
+	}
+	if rel.Name == "" {
+		rel.Name = id
+	}
+	if rel.Note == "" {
+		rel.Note = "(未填写更新说明)"
+	}
+	if err := a.releases.Add(rel); err != nil {
+		fail(http.StatusInternalServerError, "记录发布失败: %v", err)
+		return
+	}
+	go a.runRelease(id)
+
+	order := make([]string, 0, len(ordered))
+	for _, m := range ordered {
+		order = append(order, m.ProjectID)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"id":      id,
+		"status":  rel.Status,
+		"order":   order,
+		"members": ordered,
+		"message": fmt.Sprintf("发布已开始，执行顺序: %s", strings.Join(order, " → ")),
+	})
+}
+
+// handleReleaseItemAPI 处理 GET /api/releases/{id}、GET /api/releases/{id}/events 与 POST /api/releases/{id}/cancel。
+func (a *App) handleReleaseItemAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/releases/")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 0 || parts[0] == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	rel, ok := a.releases.Get(parts[0])
+	if !ok || !canAccessRelease(principalFromRequest(r), rel, "") {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "release not found"})
+		return
+	}
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, rel)
+		return
+	}
+	switch parts[1] {
+	case "events":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleReleaseEvents(w, r, rel.ID)
+	case "cancel":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.handleCancelRelease(w, r, rel)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleReleaseEvents 推送发布自身的日志以及由 forwardTaskEvents 转发的子部署、回退日志。
+func (a *App) handleReleaseEvents(w http.ResponseWriter, r *http.Request, id string) {
+	a.streamTaskEvents(w, r, id, "release_id")
+}
+
+// handleCancelRelease 中止执行中的发布：排队中的子部署被取消，执行中的子部署被中止，随后回退已完成的程序。
+func (a *App) handleCancelRelease(w http.ResponseWriter, r *http.Request, rel Release) {
+	principal := principalFromRequest(r)
+	if !canAccessRelease(principal, rel, TokenActionDeploy) {
+		http.Error(w, "无权操作该发布中的全部程序", http.StatusForbidden)
+		return
+	}
+	if rel.Status != ReleaseStatusRunning {
+		http.Error(w, "发布当前不可中止", http.StatusConflict)
+		return
+	}
+	if !a.abortTaskRun(rel.ID, principal.Username) {
+		http.Error(w, "发布不在当前进程中执行，无法中止", http.StatusConflict)
+		return
+	}
+	_ = a.releases.UpdateField(rel.ID, func(r *Release) { r.AbortedBy = principal.Username })
+	a.publish(rel.ID, "warn", "%s 请求中止发布，等待当前程序的部署结束后回退", principal.Username)
+	a.handleReleasesPartial(w, r)
+}
+
+func (a *App) handleReleasesPartial(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all := a.visibleReleases(r)
+	offset, limit := parsePageArgs(r, 0, 20, 200)
+	offset = min(offset, len(all))
+	end := min(offset+limit, len(all))
+	_ = a.templates.ExecuteTemplate(w, "releases.html", map[string]any{
+		"Releases":   all[offset:end],
+		"Total":      len(all),
+		"CanOperate": roleAllows(principalFromRequest(r).Role, RoleOperator),
+	})
+}
+
+func (a *App) updateReleaseMember(id string, idx int, fn func(m *ReleaseMember)) {
+	_ = a.releases.UpdateField(id, func(rel *Release) {
+		if idx >= 0 && idx < len(rel.Members) {
+			fn(&rel.Members[idx])
+		}
+	})
+}
+
+// runRelease 按顺序执行发布中的各程序，失败或被中止时回退已完成的程序。
+func (a *App) runRelease(id string) {
+	ctx, endRun := a.beginTaskRun(context.Background(), id)
+	defer endRun()
+	start := time.Now()
+	defer func() {
+		if rec := recover(); rec != nil {
+			a.logger.Error("release panic", "release_id", id, "panic", rec)
+			a.finishRelease(id, ReleaseStatusFailed, fmt.Errorf("panic: %v", rec), start)
+			a.publish(id, "error", "发布异常崩溃: %v", rec)
+		}
+	}()
+
+	rel, ok := a.releases.Get(id)
+	if !ok {
+		return
+	}
+	labels := make([]string, 0, len(rel.Members))
+	for _, m := range rel.Members {
+		labels = append(labels, releaseMemberLabel(m))
+	}
+	a.publishProgress(id, "info", "开始发布", 0, "发布开始，执行顺序: %s", strings.Join(labels, " → "))
+
+	var failure error
+	completed := make([]int, 0, len(rel.Members))
+	for i := range rel.Members {
+		if cause := abortCause(ctx); cause != nil {
+			failure = cause
+			break
+		}
+		if err := a.runReleaseMember(ctx, id, i, len(rel.Members)); err != nil {
+			failure = err
+			break
+		}
+		completed = append(completed, i)
+	}
+	a.skipPendingReleaseMembers(id)
+	if failure == nil {
+		a.finishRelease(id, ReleaseStatusSuccess, nil, start)
+		a.publishProgress(id, "info", "发布完成", 100, "发布完成，耗时 %d ms", time.Since(start).Milliseconds())
+		return
+	}
+
+	status := ReleaseStatusFailed
+	if abortCause(ctx) != nil {
+		status = StatusAborted
+	}
+	a.publish(id, "error", "%v", failure)
+	if len(completed) > 0 {
+		_ = a.releases.UpdateField(id, func(r *Release) { r.Status = ReleaseStatusReverting })
+		a.publishProgress(id, "warn", "回退", -1, "开始按相反顺序回退已完成的 %d 个程序", len(completed))
+		reverted := 0
+		for k := len(completed) - 1; k >= 0; k-- {
+			if a.revertReleaseMember(id, completed[k]) {
+				reverted++
+			}
+		}
+		if reverted == len(completed) {
+			failure = fmt.Errorf("%v；已回退本次发布中已完成的 %d 个程序", failure, reverted)
+		} else {
+			failure = fmt.Errorf("%v；%d 个已完成的程序未能回退，请人工检查", failure, len(completed)-reverted)
+		}
+	}
+	a.finishRelease(id, status, failure, start)
+	a.publish(id, "warn", "发布结束（%s）: %v", status, failure)
+}
+
+func (a *App) finishRelease(id, status string, err error, start time.Time) {
+	now := time.Now()
+	_ = a.releases.UpdateField(id, func(rel *Release) {
+		rel.Status = status
+		rel.FinishedAt = &now
+		rel.DurationMs = now.Sub(start).Milliseconds()
+		if err != nil {
+			rel.Error = err.Error()
+		} else {
+			rel.Error = ""
+		}
+	})
+}
+
+// skipPendingReleaseMembers 把未轮到执行的成员标记为 skipped 并删除其上传包。
+func (a *App) skipPendingReleaseMembers(id string) {
+	var files []string
+	_ = a.releases.UpdateField(id, func(rel *Release) {
+		for i := range rel.Members {
+			m := &rel.Members[i]
+			if m.Status != ReleaseMemberPending {
+				continue
+			}
+			m.Status = ReleaseMemberSkipped
+			if m.UploadFile != "" {
+				files = append(files, m.UploadFile)
+				m.UploadFile = ""
+			}
+		}
+	})
+	for _, path := range files {
+		_ = os.Remove(path)
+	}
+}
+
+// newReleaseDeployment 为发布成员生成常规部署记录，字段与 /api/upload 创建的记录一致。
+func newReleaseDeployment(id string, rel Release, m ReleaseMember, project ManagedProject) Deployment {
+	now := time.Now()
+	return Deployment{
+		ID:                 id,
+		Type:               "deploy",
+		Version:            m.Version,
+		ProjectID:          project.ID,
+		ProjectName:        project.Name,
+		ReleaseID:          rel.ID,
+		ReplaceMode:        m.ReplaceMode,
+		BackupIgnore:       append([]string{}, project.BackupIgnore...),
+		ReplaceIgnore:      append([]string{}, resolveReplaceIgnoreRulesForTarget(project.TargetDir, project.ReplaceIgnore, project.BackupIgnore)...),
+		Status:             "queued",
+		Note:               fmt.Sprintf("发布 %s: %s", rel.Name, rel.Note),
+		LoginIP:            rel.LoginIP,
+		Operator:           rel.Operator,
+		TokenID:            rel.TokenID,
+		TokenName:          rel.TokenName,
+		CreatedAt:          now,
+		StartedAt:          now,
+		UploadFile:         m.UploadFile,
+		ServiceName:        project.ServiceName,
+		TargetDir:          project.TargetDir,
+		ServiceInstallMode: project.ServiceInstallMode,
+		ServiceExePath:     project.ServiceExePath,
+		ServiceArgs:        append([]string{}, project.ServiceArgs...),
+		ServiceDisplayName: project.ServiceDisplayName,
+		ServiceDescription: project.ServiceDescription,
+		ServiceStartType:   project.ServiceStartType,
+		PackageSHA256:      m.PackageSHA256,
+		SignatureStatus:    m.SignatureStatus,
+		SignatureMode:      m.SignatureMode,
+		SignerKeyID:        m.SignerKeyID,
+	}
+}
+
+// runReleaseMember 为第 idx 个成员创建部署记录并加入程序部署队列，等待其执行结束；部署未成功时返回错误。
+func (a *App) runReleaseMember(ctx context.Context, releaseID string, idx, total int) error {
+	rel, ok := a.releases.Get(releaseID)
+	if !ok {
+		return errors.New("release not found")
+	}
+	m := rel.Members[idx]
+	label := releaseMemberLabel(m)
+	project, found := findProjectByID(a.currentConfig().Projects, m.ProjectID)
+	if !found {
+		a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+			rm.Status = ReleaseStatusFailed
+			rm.Error = "程序已被删除"
+		})
+		return fmt.Errorf("[%s] 程序已被删除，发布中止", label)
+	}
+
+	depID := newID("dep")
+	stop := a.forwardTaskEvents(releaseID, depID, label, idx, total)
+	defer stop()
+	if err := a.store.Add(newReleaseDeployment(depID, rel, m, project)); err != nil {
+		a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+			rm.Status = ReleaseStatusFailed
+			rm.Error = err.Error()
+		})
+		return fmt.Errorf("[%s] 记录部署任务失败: %v", label, err)
+	}
+	a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+		rm.Status = ReleaseMemberRunning
+		rm.DeploymentID = depID
+		rm.PreviousVersion = project.CurrentVersion
+		rm.UploadFile = ""
+	})
+	a.publishProgress(releaseID, "info", label, idx*100/total, "[%s] 第 %d/%d 个程序，部署记录 %s，版本 %s → %s",
+		label, idx+1, total, depID, firstNonEmpty(project.CurrentVersion, "-"), m.Version)
+	if _, err := a.enqueueDeployment(depID); err != nil {
+		a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+			rm.Status = ReleaseStatusFailed
+			rm.Error = err.Error()
+		})
+		return fmt.Errorf("[%s] 加入执行队列失败: %v", label, err)
+	}
+
+	dep := a.waitReleaseDeployment(ctx, releaseID, depID)
+	a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+		rm.Status = dep.Status
+		rm.Error = dep.Error
+	})
+	if dep.Status != "success" {
+		return fmt.Errorf("[%s] 部署 %s 未成功（%s）: %s", label, depID, dep.Status, firstNonEmpty(dep.Error, "-"))
+	}
+	return nil
+}
+
+// waitReleaseDeployment 等待子部署结束并返回其最终记录。发布被中止时取消仍在排队的子部署，
+// 或中止执行中的子部署，再等待其完成清理。
+func (a *App) waitReleaseDeployment(ctx context.Context, releaseID, depID string) Deployment {
+	ticker := time.NewTicker(releasePollInterval)
+	defer ticker.Stop()
+	abortSent := false
+	wake := ctx.Done()
+	for {
+		dep, ok := a.store.Get(depID)
+		if !ok {
+			dep.Status = ReleaseStatusFailed
+			dep.Error = "部署记录不存在"
+			return dep
+		}
+		switch strings.ToLower(strings.TrimSpace(dep.Status)) {
+		case "queued", "deploying":
+		default:
+			if !a.taskRunning(depID) {
+				return dep
+			}
+		}
+		if ctx.Err() != nil && !abortSent {
+			wake = nil
+			cause := abortCause(ctx)
+			if strings.EqualFold(dep.Status, "queued") {
+				if canceled, _ := a.cancelWaitingDeployment(depID, fmt.Sprintf("所属发布已中止: %v", cause)); canceled {
+					a.compactQueue(dep.ProjectID)
+					a.publish(depID, "warn", "所属发布已中止，排队任务已取消")
+					a.notifyDeploymentIfNeeded(depID)
+				}
+			} else {
+				abortSent = a.cancelTaskRun(depID, cause)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// revertReleaseMember 用成员部署前的备份回退该程序并恢复版本号；成功时返回 true。
+// 程序在本次发布之后又有成功的部署或回滚时跳过，避免覆盖更新的版本。
+func (a *App) revertReleaseMember(releaseID string, idx int) bool {
+	rel, ok := a.releases.Get(releaseID)
+	if !ok {
+		return false
+	}
+	m := rel.Members[idx]
+	label := releaseMemberLabel(m)
+	setRevert := func(status, errText string) {
+		a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) {
+			rm.RevertStatus = status
+			rm.RevertError = errText
+		})
+	}
+	source, ok := a.store.Get(m.DeploymentID)
+	targets := []Deployment{source}
+	if ok && len(source.InstanceDeployments) > 0 {
+		// 滚动更新按相反顺序逐个回退各实例。
+		children := a.instanceDeployments(source)
+		targets = make([]Deployment, 0, len(children))
+		for k := len(children) - 1; k >= 0; k-- {
+			targets = append(targets, children[k])
+		}
+	}
+	for _, t := range targets {
+		if !ok || (t.BackupFile == "" && t.PreviousDir == "") {
+			setRevert(ReleaseMemberSkipped, "部署没有备份，无法回退")
+			a.publish(releaseID, "error", "[%s] 部署没有备份，无法回退，请人工检查", label)
+			return false
+		}
+	}
+
+	// 等待期间暂停该程序的排队任务，避免程序任务锁每次释放后都被队列中的下一个任务取走。
+	resumeQueue := a.holdQueue(source.ProjectID)
+	setRevert(ReleaseMemberPending, "等待程序当前任务结束")
+	a.publish(releaseID, "info", "[%s] 等待程序当前任务结束后回退，期间暂停执行该程序的排队任务", label)
+	waitStart := time.Now()
+	lastNotice := waitStart
+	for {
+		if ok, _ := a.tryAcquireProjectTask(source.ProjectID); ok {
+			break
+		}
+		if time.Since(waitStart) >= releaseRevertWait {
+			resumeQueue()
+			go a.dispatchQueue(source.ProjectID)
+			msg := fmt.Sprintf("等待程序空闲超过 %s，未回退", releaseRevertWait)
+			setRevert(ReleaseStatusFailed, msg)
+			a.publish(releaseID, "error", "[%s] %s，请人工检查", label, msg)
+			return false
+		}
+		if time.Since(lastNotice) >= releaseRevertNotice {
+			lastNotice = time.Now()
+			a.publish(releaseID, "info", "[%s] 程序仍有任务在执行，继续等待回退（已等待 %s）", label, time.Since(waitStart).Round(time.Second))
+		}
+		time.Sleep(releasePollInterval)
+	}
+	defer func() {
+		resumeQueue()
+		a.releaseProjectTask(source.ProjectID)
+	}()
+
+	for _, d := range a.store.List() {
+		if d.ProjectID == source.ProjectID && d.ID != source.ID && d.ParentID != source.ID && d.Status == "success" &&
+			(d.Type == "deploy" || d.Type == "rollback") && d.StartedAt.After(source.StartedAt) {
+			setRevert(ReleaseMemberSkipped, fmt.Sprintf("程序已由 %s 更新", d.ID))
+			a.publish(releaseID, "warn", "[%s] 程序在本次发布之后已由 %s 更新，跳过回退", label, d.ID)
+			return false
+		}
+	}
+
+	rbIDs := make([]string, 0, len(targets))
+	for _, t := range targets {
+		rbID := newID("rb")
+		rollback := newRollbackDeployment(rbID, t, t.ProjectID)
+		rollback.ReleaseID = releaseID
+		rollback.Note = fmt.Sprintf("发布 %s 失败，回退 %s", rel.Name, t.ID)
+		rollback.LoginIP = rel.LoginIP
+		rollback.Operator = rel.Operator
+		rollback.TokenID = rel.TokenID
+		rollback.TokenName = rel.TokenName
+		if err := a.store.Add(rollback); err != nil {
+			setRevert(ReleaseStatusFailed, err.Error())
+			a.publish(releaseID, "error", "[%s] 创建回退记录失败: %v", label, err)
+			return false
+		}
+		rbIDs = append(rbIDs, rbID)
+		a.updateReleaseMember(releaseID, idx, func(rm *ReleaseMember) { rm.RollbackID = strings.Join(rbIDs, ", ") })
+		a.publish(releaseID, "warn", "[%s] 回退到部署前版本，回退记录: %s", label, rbID)
+
+		stop := a.forwardTaskEvents(releaseID, rbID, label, 0, 0)
+		a.executeRollback(rbID, t.ID, false)
+		stop()
+		a.notifyDeploymentIfNeeded(rbID)
+		if rb, _ := a.store.Get(rbID); rb.Status != "success" {
+			setRevert(ReleaseStatusFailed, rb.Error)
+			a.publish(releaseID, "error", "[%s] 回退失败（%s）: %s", label, rbID, rb.Error)
+			return false
+		}
+	}
+	if m.PreviousVersion != "" {
+		if err := a.setProjectCurrentVersion(source.ProjectID, m.PreviousVersion); err != nil {
+			a.publish(releaseID, "warn", "[%s] 回退成功，但写入当前版本失败: %v", label, err)
+		}
+	}
+	setRevert(ReleaseStatusSuccess, "")
+	a.publish(releaseID, "warn", "[%s] 已回退，当前版本: %s", label, firstNonEmpty(m.PreviousVersion, "-"))
+	return true
+}
+
+// forwardTaskEvents 把子任务的日志转发到上级任务（发布、滚动更新）的事件流：文本与阶段前加 label，
+// total > 0 时把子任务进度换算为第 idx 段（共 total 段）的进度。返回的函数停止转发，调用前子任务应已结束。
+func (a *App) forwardTaskEvents(parentID, childID, label string, idx, total int) func() {
+	_, ch, unsubscribe := a.events.Subscribe(childID)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	forward := func(evt Event) {
+		evt.Text = fmt.Sprintf("[%s] %s", label, evt.Text)
+		if evt.Stage != "" {
+			evt.Stage = label + " " + evt.Stage
+		}
+		if total > 0 && evt.Progress >= 0 {
+			evt.Progress = (idx*100 + evt.Progress) / total
+		} else {
+			evt.Progress = -1
+		}
+		a.events.Publish(parentID, evt)
+	}
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case evt := <-ch:
+				forward(evt)
+			case <-done:
+				for {
+					select {
+					case evt := <-ch:
+						forward(evt)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+		unsubscribe()
+	}
+}
+
+// recoverInterruptedReleases 在启动时把上次进程退出时仍在执行的发布标记为 interrupted：
+// 后续程序不再执行，仍在排队的子部署被取消；已完成的程序不会自动回退，需要人工判断。
+func (a *App) recoverInterruptedReleases() {
+	for _, rel := range a.releases.List() {
+		if rel.Status != ReleaseStatusRunning && rel.Status != ReleaseStatusReverting {
+			continue
+		}
+		reason := "服务重启前发布中断，后续程序未执行"
+		if rel.Status == ReleaseStatusReverting {
+			reason = "服务重启前发布中断，回退未完成"
+		}
+		for i, m := range rel.Members {
+			if m.DeploymentID == "" || m.Status != ReleaseMemberRunning {
+				continue
+			}
+			if canceled, _ := a.cancelWaitingDeployment(m.DeploymentID, "所属发布已中断"); canceled {
+				a.compactQueue(m.ProjectID)
+				a.updateReleaseMember(rel.ID, i, func(rm *ReleaseMember) { rm.Status = "canceled" })
+				continue
+			}
+			if dep, ok := a.store.Get(m.DeploymentID); ok {
+				a.updateReleaseMember(rel.ID, i, func(rm *ReleaseMember) {
+					// 执行中的子部署由 recoverInterruptedTasks 按中断任务处理。
+					rm.Status = dep.Status
+					if s := strings.ToLower(dep.Status); s == "deploying" || s == "rollbacking" {
+						rm.Status = StatusInterrupted
+					}
+					rm.Error = dep.Error
+				})
+			}
+		}
+		a.skipPendingReleaseMembers(rel.ID)
+		a.finishRelease(rel.ID, StatusInterrupted, errors.New(reason+"；已完成的程序未自动回退，请在部署记录中确认后手动回滚"), rel.CreatedAt)
+		a.logger.Warn(reason, "release_id", rel.ID)
+	}
+}
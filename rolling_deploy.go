@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 程序配置了实例（instances）时，一次部署按批滚动更新各实例：每批同时部署 rolling_batch_size 个实例，
+// 批次之间暂停 rolling_pause_sec 秒，有实例未成功即停止，后续实例不再更新。
+// 每个实例各有一条部署记录（parent_id 指向本次滚动更新），备份与变更文件分别保存，可单独回滚。
+
+const maxRollingPauseSec = 3600
+
+func projectInstanceByID(p ManagedProject, id string) (ProjectInstance, bool) {
+	if id == "" {
+		return ProjectInstance{}, false
+	}
+	for _, inst := range p.Instances {
+		if inst.ID == id {
+			return inst, true
+		}
+	}
+	return ProjectInstance{}, false
+}
+
+// normalizeProjectInstances 校验实例配置；实例 ID、目标目录与服务名在同一程序内不能重复。
+func normalizeProjectInstances(instances []ProjectInstance) ([]ProjectInstance, error) {
+	out := make([]ProjectInstance, 0, len(instances))
+	ids := map[string]struct{}{}
+	dirs := map[string]struct{}{}
+	services := map[string]struct{}{}
+	for i, inst := range instances {
+		inst.ID = strings.TrimSpace(inst.ID)
+		inst.TargetDir = strings.TrimSpace(inst.TargetDir)
+		inst.ServiceName = strings.TrimSpace(inst.ServiceName)
+		if inst.ID == "" {
+			return nil, fmt.Errorf("instances[%d].id 不能为空", i)
+		}
+		if _, dup := ids[inst.ID]; dup {
+			return nil, fmt.Errorf("instances.id 重复: %s", inst.ID)
+		}
+		ids[inst.ID] = struct{}{}
+		if inst.TargetDir == "" {
+			return nil, fmt.Errorf("instances(%s).target_dir 不能为空", inst.ID)
+		}
+		dir := strings.ToLower(filepath.Clean(inst.TargetDir))
+		if _, dup := dirs[dir]; dup {
+			return nil, fmt.Errorf("instances(%s).target_dir 与其他实例重复: %s", inst.ID, inst.TargetDir)
+		}
+		dirs[dir] = struct{}{}
+		if inst.ServiceName != "" {
+			name := strings.ToLower(inst.ServiceName)
+			if _, dup := services[name]; dup {
+				return nil, fmt.Errorf("instances(%s).service_name 与其他实例重复: %s", inst.ID, inst.ServiceName)
+			}
+			services[name] = struct{}{}
+		}
+		check, err := normalizeHealthCheck(inst.HealthCheck)
+		if err != nil {
+			return nil, fmt.Errorf("instances(%s).%v", inst.ID, err)
+		}
+		inst.HealthCheck = check
+		out = append(out, inst)
+	}
+	return out, nil
+}
+
+func validateRollingSettings(p ManagedProject) error {
+	if p.RollingBatchSize < 0 {
+		return errors.New("rolling_batch_size 不能为负数")
+	}
+	if p.RollingPauseSec < 0 || p.RollingPauseSec > maxRollingPauseSec {
+		return fmt.Errorf("rolling_pause_sec 必须在 0 到 %d 之间", maxRollingPauseSec)
+	}
+	return nil
+}
+
+// rollingBatchSize 返回每批部署的实例数，未配置时逐个部署。
+func rollingBatchSize(p ManagedProject) int {
+	n := p.RollingBatchSize
+	if n <= 0 {
+		n = 1
+	}
+	return min(n, len(p.Instances))
+}
+
+func newInstanceDeployment(id string, parent Deployment, project ManagedProject, inst ProjectInstance) Deployment {
+	now := time.Now()
+	return Deployment{
+		ID:                      id,
+		Type:                    "deploy",
+		Version:                 parent.Version,
+		ProjectID:               parent.ProjectID,
+		ProjectName:             parent.ProjectName,
+		ParentID:                parent.ID,
+		InstanceID:              inst.ID,
+		ReplaceMode:             parent.ReplaceMode,
+		BackupIgnore:            append([]string{}, parent.BackupIgnore...),
+		ReplaceIgnore:           append([]string{}, resolveReplaceIgnoreRulesForTarget(inst.TargetDir, project.ReplaceIgnore, project.BackupIgnore)...),
+		Status:                  "deploying",
+		Note:                    fmt.Sprintf("滚动更新 %s 的实例 %s", parent.ID, inst.ID),
+		LoginIP:                 parent.LoginIP,
+		Operator:                parent.Operator,
+		TokenID:                 parent.TokenID,
+		TokenName:               parent.TokenName,
+		CreatedAt:               now,
+		StartedAt:               now,
+		UploadFile:              parent.UploadFile,
+		ServiceName:             inst.ServiceName,
+		TargetDir:               inst.TargetDir,
+		ServiceInstallMode:      parent.ServiceInstallMode,
+		ServiceExePath:          parent.ServiceExePath,
+		ServiceArgs:             append([]string{}, parent.ServiceArgs...),
+		ServiceDisplayName:      parent.ServiceDisplayName,
+		ServiceDescription:      parent.ServiceDescription,
+		ServiceStartType:        parent.ServiceStartType,
+		ClearTargetBeforeDeploy: parent.ClearTargetBeforeDeploy,
+		PackageSHA256:           parent.PackageSHA256,
+		SignatureStatus:         parent.SignatureStatus,
+		SignatureMode:           parent.SignatureMode,
+		SignerKeyID:             parent.SignerKeyID,
+	}
+}
+
+// instanceDeployments 返回滚动更新已创建的各实例部署记录，按执行顺序排列。
+func (a *App) instanceDeployments(parent Deployment) []Deployment {
+	out := make([]Deployment, 0, len(parent.InstanceDeployments))
+	for _, childID := range parent.InstanceDeployments {
+		if child, ok := a.store.Get(childID); ok {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+// runRollingDeployment 把部署记录 id 的上传包按批部署到程序的各实例；调用方持有程序任务锁并负责通知。
+// 中止滚动更新时正在部署的实例随之中止并恢复部署前版本，已更新的实例保持新版本。
+func (a *App) runRollingDeployment(parent context.Context, id string, project ManagedProject) {
+	ctx, endRun := a.beginTaskRun(parent, id)
+	defer endRun()
+	start := time.Now()
+	finish := func(status string, err error) {
+		now := time.Now()
+		_ = a.store.UpdateField(id, func(d *Deployment) {
+			d.Status = status
+			d.FinishedAt = &now
+			d.DurationMs = now.Sub(start).Milliseconds()
+			if err != nil {
+				d.Error = err.Error()
+			} else {
+				d.Error = ""
+			}
+		})
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			a.logger.Error("rolling deployment panic", "deployment_id", id, "panic", rec)
+			finish("failed", fmt.Errorf("panic: %v", rec))
+			a.publish(id, "error", "滚动更新异常崩溃: %v", rec)
+		}
+	}()
+
+	dep, ok := a.store.Get(id)
+	if !ok {
+		return
+	}
+	_ = a.store.UpdateField(id, func(d *Deployment) {
+		d.Status = "deploying"
+		d.StartedAt = start
+	})
+	// 滚动更新本身不改动目录；进程意外退出时由部署日志判断中断，各实例按各自的部署日志恢复。
+	journal := a.openJournal(id)
+	defer journal.Remove()
+	journal.Record(JournalEntry{Stage: JournalStarted})
+
+	instances := project.Instances
+	batchSize := rollingBatchSize(project)
+	batches := (len(instances) + batchSize - 1) / batchSize
+	pause := time.Duration(project.RollingPauseSec) * time.Second
+	a.publishProgress(id, "info", "滚动更新", 0, "滚动更新 %d 个实例，每批 %d 个，共 %d 批，批次间隔 %d 秒",
+		len(instances), batchSize, batches, project.RollingPauseSec)
+
+	updated := 0
+	stopped := func(status string, cause error) {
+		err := fmt.Errorf("%v；已更新 %d/%d 个实例，其余实例未更新", cause, updated, len(instances))
+		finish(status, err)
+		if status == StatusAborted {
+			a.publish(id, "warn", "%v", err)
+		} else {
+			a.publish(id, "error", "%v", err)
+		}
+	}
+	for b := 0; b < batches; b++ {
+		group := instances[b*batchSize : min((b+1)*batchSize, len(instances))]
+		if b > 0 && pause > 0 {
+			a.publishProgress(id, "info", "批次间隔", b*100/batches, "等待 %d 秒后更新第 %d/%d 批", project.RollingPauseSec, b+1, batches)
+			_ = sleepContext(ctx, pause)
+		}
+		if cause := abortCause(ctx); cause != nil {
+			stopped(StatusAborted, cause)
+			return
+		}
+
+		names := make([]string, 0, len(group))
+		childIDs := make([]string, 0, len(group))
+		for _, inst := range group {
+			childID := newID("dep")
+			if err := a.store.Add(newInstanceDeployment(childID, dep, project, inst)); err != nil {
+				stopped("failed", fmt.Errorf("记录实例 %s 的部署任务失败: %v", inst.ID, err))
+				return
+			}
+			_ = a.store.UpdateField(id, func(d *Deployment) { d.InstanceDeployments = append(d.InstanceDeployments, childID) })
+			names = append(names, inst.ID)
+			childIDs = append(childIDs, childID)
+		}
+		a.publishProgress(id, "info", "滚动更新", b*100/batches, "开始更新第 %d/%d 批: %s", b+1, batches, strings.Join(names, ", "))
+
+		var wg sync.WaitGroup
+		for i, childID := range childIDs {
+			stop := a.forwardTaskEvents(id, childID, group[i].ID, b, batches)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer stop()
+				a.executeDeployment(ctx, childID)
+			}()
+		}
+		wg.Wait()
+
+		failed := make([]string, 0)
+		aborted := abortCause(ctx) != nil
+		for i, childID := range childIDs {
+			child, _ := a.store.Get(childID)
+			if child.Status == "success" {
+				updated++
+				continue
+			}
+			if child.Status == StatusAborted {
+				aborted = true
+			}
+			failed = append(failed, fmt.Sprintf("实例 %s（%s，%s）: %s", group[i].ID, childID, child.Status, firstNonEmpty(child.Error, "未知错误")))
+		}
+		if len(failed) > 0 {
+			status := "failed"
+			if aborted {
+				status = StatusAborted
+			}
+			stopped(status, fmt.Errorf("滚动更新已停止，%s", strings.Join(failed, "；")))
+			return
+		}
+		a.publishProgress(id, "info", "滚动更新", (b+1)*100/batches, "第 %d/%d 批更新完成: %s", b+1, batches, strings.Join(names, ", "))
+	}
+
+	if dep.Version != "" {
+		if err := a.setProjectCurrentVersion(dep.ProjectID, dep.Version); err != nil {
+			a.publish(id, "warn", "滚动更新成功，但写入当前版本失败: %v", err)
+		} else {
+			a.publish(id, "info", "当前版本已更新为: %s", dep.Version)
+		}
+	}
+	finish("success", nil)
+	a.publishProgress(id, "info", "部署完成", 100, "%d 个实例全部更新完成，耗时 %d ms", len(instances), time.Since(start).Milliseconds())
+}
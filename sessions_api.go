@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	sessionTTL           = 8 * time.Hour
+	sessionTouchInterval = 30 * time.Second
+)
+
+func sessionIdleTimeout(cfg Config) time.Duration {
+	if cfg.SessionIdleMinutes <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.SessionIdleMinutes) * time.Minute
+}
+
+// handleSessionsAPI 列出会话：admin 可查看全部用户，其他角色仅能查看自己的会话。
+func (a *App) handleSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	current := principalFromRequest(r)
+	scopeUser := current.Username
+	if roleAllows(current.Role, RoleAdmin) && r.URL.Query().Get("scope") == "all" {
+		scopeUser = ""
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sessions": sessionSnapshots(a.sessions.List(scopeUser), current.SessionID),
+	})
+}
+
+// handleSessionItemAPI 处理 DELETE /api/sessions/{id} 注销单个会话，以及 POST /api/sessions/revoke-all 批量注销。
+func (a *App) handleSessionItemAPI(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/sessions/"))
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	current := principalFromRequest(r)
+	isAdmin := roleAllows(current.Role, RoleAdmin)
+
+	if id == "revoke-all" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := parseRequestForm(r); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "请求参数解析失败"})
+			return
+		}
+		scopeUser := current.Username
+		if r.FormValue("scope") == "all" {
+			if !isAdmin {
+				writeJSON(w, http.StatusForbidden, map[string]any{"error": "仅 admin 可注销全部用户的会话"})
+				return
+			}
+			scopeUser = ""
+		}
+		count := a.sessions.DeleteAll(scopeUser, current.SessionID)
+		a.logger.Info("批量注销会话", "operator", current.Username, "scope", r.FormValue("scope"), "count", count, "ip", a.clientIP(r))
+		writeJSON(w, http.StatusOK, map[string]any{
+			"ok":      true,
+			"message": fmt.Sprintf("已注销 %d 个会话（当前会话保留）", count),
+		})
+		return
+	}
+
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ownerFilter := current.Username
+	if isAdmin {
+		ownerFilter = ""
+	}
+	if !a.sessions.DeleteByID(id, ownerFilter) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "会话不存在或无权注销"})
+		return
+	}
+	a.logger.Info("会话已注销", "session_id", id, "operator", current.Username, "ip", a.clientIP(r))
+	msg := "会话已注销"
+	if id == current.SessionID {
+		msg = "当前会话已注销，请重新登录"
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "message": msg, "current": id == current.SessionID})
+}
+
+func sessionSnapshots(list []sessionData, currentID string) []map[string]any {
+	out := make([]map[string]any, 0, len(list))
+	for _, s := range list {
+		out = append(out, map[string]any{
+			"id":         s.ID,
+			"user":       s.User,
+			"ip":         s.IP,
+			"user_agent": s.UserAgent,
+			"created_at": s.CreatedAt,
+			"last_seen":  s.LastSeen,
+			"expires_at": s.ExpiresAt,
+			"current":    s.ID == currentID,
+		})
+	}
+	return out
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -85,6 +86,22 @@ func (s *deploymentStore) UpdateField(id string, fn func(dep *Deployment)) error
 	return errors.New("deployment not found")
 }
 
+// UpdateEach 对每条记录调用 fn，fn 返回 true 表示记录有修改；有修改时只落盘一次。
+func (s *deploymentStore) UpdateEach(fn func(dep *Deployment) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for i := range s.list {
+		if fn(&s.list[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveLocked()
+}
+
 func (s *deploymentStore) Get(id string) (Deployment, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -150,50 +167,226 @@ func (s *deploymentStore) SwitchFile(newFile string) error {
 }
 
 type sessionData struct {
-	User      string
-	ExpiresAt time.Time
+	ID        string    `json:"id"`
+	TokenHash string    `json:"token_hash"`
+	User      string    `json:"user"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CSRFToken string    `json:"csrf_token"`
 }
 
+// sessionManager 持久化登录会话，文件中只保存令牌的 SHA-256，进程重启或自更新后会话仍有效。
 type sessionManager struct {
 	mu       sync.Mutex
+	file     string
+	idle     time.Duration
 	sessions map[string]sessionData
 }
 
-func newSessionManager() *sessionManager {
-	return &sessionManager{
+func newSessionManager(file string, idle time.Duration) (*sessionManager, error) {
+	m := &sessionManager{
+		file:     file,
+		idle:     idle,
 		sessions: make(map[string]sessionData),
 	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func (m *sessionManager) Create(user string, ttl time.Duration) string {
+func (m *sessionManager) load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, err := os.ReadFile(m.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var list []sessionData
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, data := range list {
+		if m.expiredLocked(data, now) {
+			continue
+		}
+		if data.CSRFToken == "" {
+			data.CSRFToken = randomHex(32)
+		}
+		m.sessions[data.TokenHash] = data
+	}
+	return nil
+}
+
+func (m *sessionManager) saveLocked() error {
+	list := make([]sessionData, 0, len(m.sessions))
+	for _, data := range m.sessions {
+		list = append(list, data)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(m.file); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := m.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.file)
+}
+
+func (m *sessionManager) expiredLocked(data sessionData, now time.Time) bool {
+	if now.After(data.ExpiresAt) {
+		return true
+	}
+	return m.idle > 0 && now.Sub(data.LastSeen) > m.idle
+}
+
+// SetIdleTimeout 更新空闲超时，0 表示仅按固定有效期过期。
+func (m *sessionManager) SetIdleTimeout(idle time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idle = idle
+}
+
+func (m *sessionManager) Create(user, ip, userAgent string, ttl time.Duration) string {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	token := randomHex(32)
-	m.sessions[token] = sessionData{
+	now := time.Now()
+	for hash, data := range m.sessions {
+		if m.expiredLocked(data, now) {
+			delete(m.sessions, hash)
+		}
+	}
+	hash := sha256Hex(token)
+	m.sessions[hash] = sessionData{
+		ID:        randomHex(8),
+		TokenHash: hash,
 		User:      user,
-		ExpiresAt: time.Now().Add(ttl),
+		IP:        ip,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: now.Add(ttl),
+		CSRFToken: randomHex(32),
 	}
+	_ = m.saveLocked()
 	return token
 }
 
-func (m *sessionManager) Get(token string) (string, bool) {
+// Get 校验会话并按 sessionTouchInterval 节流刷新最近活动时间。
+func (m *sessionManager) Get(token, ip, userAgent string) (sessionData, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	data, ok := m.sessions[token]
+	hash := sha256Hex(token)
+	data, ok := m.sessions[hash]
 	if !ok {
-		return "", false
+		return sessionData{}, false
+	}
+	now := time.Now()
+	if m.expiredLocked(data, now) {
+		delete(m.sessions, hash)
+		_ = m.saveLocked()
+		return sessionData{}, false
 	}
-	if time.Now().After(data.ExpiresAt) {
-		delete(m.sessions, token)
-		return "", false
+	if now.Sub(data.LastSeen) >= sessionTouchInterval || data.IP != ip {
+		data.LastSeen = now
+		data.IP = ip
+		data.UserAgent = userAgent
+		m.sessions[hash] = data
+		_ = m.saveLocked()
 	}
-	return data.User, true
+	return data, true
 }
 
 func (m *sessionManager) Delete(token string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.sessions, token)
+	delete(m.sessions, sha256Hex(token))
+	_ = m.saveLocked()
+}
+
+// DeleteByID 按会话 ID 注销；user 非空时只允许注销该用户自己的会话。
+func (m *sessionManager) DeleteByID(id, user string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, data := range m.sessions {
+		if data.ID != id {
+			continue
+		}
+		if user != "" && !strings.EqualFold(data.User, user) {
+			return false
+		}
+		delete(m.sessions, hash)
+		_ = m.saveLocked()
+		return true
+	}
+	return false
+}
+
+// DeleteAll 注销会话并返回数量；user 非空时只处理该用户，keepID 对应的会话（通常是当前会话）会保留。
+func (m *sessionManager) DeleteAll(user, keepID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for hash, data := range m.sessions {
+		if data.ID == keepID {
+			continue
+		}
+		if user != "" && !strings.EqualFold(data.User, user) {
+			continue
+		}
+		delete(m.sessions, hash)
+		count++
+	}
+	if count > 0 {
+		_ = m.saveLocked()
+	}
+	return count
+}
+
+func (m *sessionManager) DeleteUser(user string) {
+	m.DeleteAll(user, "")
+}
+
+// List 返回未过期的会话；user 非空时只返回该用户的会话。
+func (m *sessionManager) List(user string) []sessionData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	out := make([]sessionData, 0, len(m.sessions))
+	for _, data := range m.sessions {
+		if m.expiredLocked(data, now) {
+			continue
+		}
+		if user != "" && !strings.EqualFold(data.User, user) {
+			continue
+		}
+		out = append(out, data)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].LastSeen.After(out[j].LastSeen)
+	})
+	return out
 }
 
 type eventHub struct {
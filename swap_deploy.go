@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 部署方式：in_place 在目标目录中逐个替换文件；swap 先在同级目录构建完整的新版本，
+// 停止服务后通过目录重命名整体切换，旧目录保留为上一版本，回滚时再切换回去。
+const (
+	DeployStrategyInPlace = "in_place"
+	DeployStrategySwap    = "swap"
+
+	swapStagingSuffix  = ".staging-"
+	swapPreviousSuffix = ".prev-"
+	swapDiscardSuffix  = ".discard-"
+)
+
+func normalizeDeployStrategy(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case DeployStrategySwap:
+		return DeployStrategySwap
+	default:
+		return DeployStrategyInPlace
+	}
+}
+
+// swapSiblingDir 返回与目标目录同级的工作目录，保证重命名不跨卷。
+func swapSiblingDir(targetDir, suffix, id string) string {
+	return filepath.Clean(targetDir) + suffix + id
+}
+
+// buildStagingDir 在 staging 中构建新版本目录：先复制当前目标目录（首次部署除外），
+// 再按与就地替换相同的规则同步上传包，返回相对当前目录的变更明细。
+func buildStagingDir(ctx context.Context, targetDir, stagingDir, extractDir string, ignore *IgnoreMatcher, removeMissing, copyExisting bool) ([]ChangedFile, error) {
+	if err := os.RemoveAll(stagingDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stagingDir, 0755); err != nil {
+		return nil, err
+	}
+	if copyExisting {
+		if err := copyDirTree(targetDir, stagingDir); err != nil {
+			return nil, fmt.Errorf("复制当前目录失败: %w", err)
+		}
+	}
+	return syncDirectories(ctx, extractDir, stagingDir, ignore, removeMissing, nil)
+}
+
+// swapInStagingDir 在服务停止后切换目录：同步最新的保留文件（replace_ignore 命中项）到 staging，
+// 把目标目录改名为上一版本目录，再把 staging 改名为目标目录。切换失败时尽量还原原目录。
+// 返回上一版本目录；目标目录原本不存在时返回空串。
+func (a *App) swapInStagingDir(ctx context.Context, depID, targetDir, stagingDir string, ignore *IgnoreMatcher, preserve bool, progress int) (string, error) {
+	targetExists := isExistingDir(targetDir)
+	if targetExists && preserve {
+		if err := copyIgnoredEntries(targetDir, stagingDir, ignore); err != nil {
+			return "", fmt.Errorf("复制保留文件失败: %w", err)
+		}
+	}
+	prevDir := swapSiblingDir(targetDir, swapPreviousSuffix, depID)
+	if targetExists {
+		if err := a.runFileOpWithRetry(ctx, depID, "切换目录", progress, "移出当前目录", func() error {
+			return os.Rename(targetDir, prevDir)
+		}); err != nil {
+			return "", err
+		}
+	}
+	if err := a.runFileOpWithRetry(ctx, depID, "切换目录", progress, "切换新版本目录", func() error {
+		return os.Rename(stagingDir, targetDir)
+	}); err != nil {
+		if targetExists {
+			if restoreErr := os.Rename(prevDir, targetDir); restoreErr != nil {
+				err = fmt.Errorf("%v; 还原原目录失败，原目录位于 %s: %v", err, prevDir, restoreErr)
+			}
+		}
+		return "", err
+	}
+	pruneSwapDirs(targetDir, swapPreviousSuffix, prevDir)
+	if !targetExists {
+		return "", nil
+	}
+	return prevDir, nil
+}
+
+// swapBackPreviousDir 把上一版本目录切换回目标目录，当前目录中的保留文件会先同步过去，切换成功后删除当前目录。
+func (a *App) swapBackPreviousDir(ctx context.Context, depID, targetDir, prevDir string, ignore *IgnoreMatcher, progress int) error {
+	if isExistingDir(targetDir) {
+		if err := copyIgnoredEntries(targetDir, prevDir, ignore); err != nil {
+			return fmt.Errorf("复制保留文件失败: %w", err)
+		}
+	}
+	discardDir := swapSiblingDir(targetDir, swapDiscardSuffix, depID)
+	movedCurrent := false
+	if isExistingDir(targetDir) {
+		if err := a.runFileOpWithRetry(ctx, depID, "切换目录", progress, "移出当前目录", func() error {
+			return os.Rename(targetDir, discardDir)
+		}); err != nil {
+			return err
+		}
+		movedCurrent = true
+	}
+	if err := a.runFileOpWithRetry(ctx, depID, "切换目录", progress, "切换回上一版本目录", func() error {
+		return os.Rename(prevDir, targetDir)
+	}); err != nil {
+		if movedCurrent {
+			if restoreErr := os.Rename(discardDir, targetDir); restoreErr != nil {
+				err = fmt.Errorf("%v; 还原当前目录失败，目录位于 %s: %v", err, discardDir, restoreErr)
+			}
+		}
+		return err
+	}
+	if movedCurrent {
+		if err := os.RemoveAll(discardDir); err != nil {
+			a.publish(depID, "warn", "删除已替换的目录失败，请手动清理 %s: %v", discardDir, err)
+		}
+	}
+	return nil
+}
+
+// pruneSwapDirs 删除目标目录旁遗留的同类工作目录，只保留 keep。
+func pruneSwapDirs(targetDir, suffix, keep string) {
+	parent := filepath.Dir(filepath.Clean(targetDir))
+	prefix := filepath.Base(filepath.Clean(targetDir)) + suffix
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		path := filepath.Join(parent, e.Name())
+		if path == keep {
+			continue
+		}
+		_ = os.RemoveAll(path)
+	}
+}
+
+func isExistingDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// copyDirTree 把 src 下的全部内容复制到 dst，已存在的文件会被覆盖。
+func copyDirTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		out := filepath.Join(dst, rel)
+		if d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			// 保留目录权限，但确保本进程仍可写入其中的文件。
+			return os.MkdirAll(out, info.Mode().Perm()|0700)
+		}
+		return copyFile(path, out)
+	})
+}
+
+// copyIgnoredEntries 把 src 中被忽略规则命中的文件与目录（如 appsettings.json、logs/）原样覆盖到 dst。
+func copyIgnoredEntries(src, dst string, ignore *IgnoreMatcher) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == src {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if !ignore.ShouldIgnore(normalizeRelPath(rel), d.IsDir()) {
+			return nil
+		}
+		out := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if err := os.RemoveAll(out); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			if err := copyDirTree(path, out); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if err := copyFile(path, out); err != nil {
+			return err
+		}
+		// 覆盖已有文件时 copyFile 保留目标的权限，这里改为与原文件一致。
+		return os.Chmod(out, info.Mode().Perm())
+	})
+}
+
+// deploymentPreviousDir 读取部署记录中保留的上一版本目录。
+func (a *App) deploymentPreviousDir(id string) string {
+	if dep, ok := a.store.Get(id); ok {
+		return dep.PreviousDir
+	}
+	return ""
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	certReloadCheckInterval = 10 * time.Second
+	selfSignedCertValidity  = 5 * 365 * 24 * time.Hour
+)
+
+// certReloader 为 TLS 监听提供证书，证书或私钥文件修改后自动重新加载，续期无需重启。
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	fingerprint string
+	certMod     time.Time
+	keyMod      time.Time
+	lastCheck   time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.loadLocked(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) loadLocked() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载 TLS 证书失败: %w", err)
+	}
+	c.cert = &cert
+	c.fingerprint = certFingerprint(cert.Certificate[0])
+	c.certMod = certInfo.ModTime()
+	c.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// maybeReloadLocked 限频检查文件修改时间；新证书加载失败时继续使用旧证书，避免续期过程中半写入的文件导致中断。
+func (c *certReloader) maybeReloadLocked() {
+	now := time.Now()
+	if now.Sub(c.lastCheck) < certReloadCheckInterval {
+		return
+	}
+	c.lastCheck = now
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return
+	}
+	if certInfo.ModTime().Equal(c.certMod) && keyInfo.ModTime().Equal(c.keyMod) {
+		return
+	}
+	old := c.fingerprint
+	if err := c.loadLocked(); err != nil {
+		c.logger.Warn("TLS 证书重新加载失败，继续使用旧证书", "error", err)
+		return
+	}
+	c.logger.Info("TLS 证书已重新加载", "old_fingerprint", old, "fingerprint", c.fingerprint)
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybeReloadLocked()
+	return c.cert, nil
+}
+
+func (c *certReloader) Fingerprint() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybeReloadLocked()
+	return c.fingerprint
+}
+
+// certFingerprint 返回证书 DER 的 SHA-256 指纹，格式与浏览器证书详情一致（冒号分隔的大写十六进制）。
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	raw := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(raw); i += 2 {
+		parts = append(parts, raw[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// ensureSelfSignedCert 在证书与私钥都不存在时生成自签名证书；只存在其中一个时视为配置错误，不覆盖。
+func ensureSelfSignedCert(certFile, keyFile string) (bool, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return false, nil
+	}
+	if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+		return false, fmt.Errorf("TLS 证书与私钥需同时存在: cert=%v key=%v", certErr, keyErr)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, err
+	}
+	hostname, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: firstNonEmpty(hostname, "localhost"), Organization: []string{"SimpleRemoteUpdate"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" && !strings.EqualFold(hostname, "localhost") {
+		tmpl.DNSNames = append(tmpl.DNSNames, hostname)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return false, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range []string{certFile, keyFile} {
+		if dir := filepath.Dir(f); dir != "" && dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return false, err
+			}
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// httpsRedirectHandler 将明文 HTTP 请求重定向到 HTTPS 监听端口。
+func httpsRedirectHandler(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// serve 按配置启动 HTTP 或 HTTPS 监听；TLS 相关配置修改后需重启生效。
+func (a *App) serve(cfg Config) error {
+	handler := a.routes()
+	if !cfg.TLSEnabled {
+		return http.ListenAndServe(cfg.ListenAddr, handler)
+	}
+	if cfg.TLSRedirectAddr != "" {
+		go func() {
+			a.logger.Info("HTTP 跳转 HTTPS 监听已启动", "addr", cfg.TLSRedirectAddr)
+			if err := http.ListenAndServe(cfg.TLSRedirectAddr, httpsRedirectHandler(cfg.ListenAddr)); err != nil {
+				a.logger.Warn("HTTP 跳转监听退出", "addr", cfg.TLSRedirectAddr, "error", err)
+			}
+		}()
+	}
+	server := &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: a.tls.GetCertificate,
+		},
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// setupTLS 在启用 TLS 时准备证书（按需生成自签名证书）并创建热加载器。
+func setupTLS(cfg Config, logger *slog.Logger) (*certReloader, error) {
+	if !cfg.TLSEnabled {
+		return nil, nil
+	}
+	if cfg.TLSAutoSelfSigned {
+		created, err := ensureSelfSignedCert(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			logger.Warn("已生成自签名 TLS 证书，请在浏览器中核对指纹后再信任", "cert", cfg.TLSCertFile)
+		}
+	}
+	reloader, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("TLS 已启用", "cert", cfg.TLSCertFile, "sha256_fingerprint", reloader.Fingerprint())
+	return reloader, nil
+}
+
+func (a *App) tlsFingerprint() string {
+	if a.tls == nil {
+		return ""
+	}
+	return a.tls.Fingerprint()
+}
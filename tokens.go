@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiTokenPrefix = "sru_"
+
+	TokenActionDeploy   = "deploy"
+	TokenActionPreview  = "preview"
+	TokenActionRollback = "rollback"
+	TokenActionRead     = "read"
+
+	tokenTouchInterval = time.Minute
+)
+
+var tokenActions = []string{TokenActionDeploy, TokenActionPreview, TokenActionRollback, TokenActionRead}
+
+type apiTokenStore struct {
+	mu   sync.Mutex
+	file string
+	list []APIToken
+}
+
+func newAPITokenStore(file string) (*apiTokenStore, error) {
+	s := &apiTokenStore{
+		file: file,
+		list: make([]APIToken, 0),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *apiTokenStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := os.ReadFile(s.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.list = []APIToken{}
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		s.list = []APIToken{}
+		return nil
+	}
+	var out []APIToken
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	s.list = out
+	return nil
+}
+
+func (s *apiTokenStore) saveLocked() error {
+	raw, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.file); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// Create 生成新令牌，明文只在返回值中出现一次，落盘只保存 SHA-256。
+func (s *apiTokenStore) Create(t APIToken) (APIToken, string, error) {
+	plain := apiTokenPrefix + randomHex(24)
+	t.ID = newID("tok")
+	t.TokenHash = sha256Hex(plain)
+	t.Hint = plain[:len(apiTokenPrefix)+6]
+	t.CreatedAt = time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, t)
+	if err := s.saveLocked(); err != nil {
+		s.list = s.list[:len(s.list)-1]
+		return APIToken{}, "", err
+	}
+	return t, plain, nil
+}
+
+// Lookup 按明文令牌查找有效令牌，并按 tokenTouchInterval 节流记录最近使用时间与 IP。
+func (s *apiTokenStore) Lookup(plain, ip string) (APIToken, bool) {
+	plain = strings.TrimSpace(plain)
+	if !strings.HasPrefix(plain, apiTokenPrefix) {
+		return APIToken{}, false
+	}
+	hash := sha256Hex(plain)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.list {
+		t := &s.list[i]
+		if subtle.ConstantTimeCompare([]byte(t.TokenHash), []byte(hash)) != 1 {
+			continue
+		}
+		if t.RevokedAt != nil || (t.ExpiresAt != nil && now.After(*t.ExpiresAt)) {
+			return APIToken{}, false
+		}
+		if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= tokenTouchInterval || t.LastUsedIP != ip {
+			t.LastUsedAt = &now
+			t.LastUsedIP = ip
+			_ = s.saveLocked()
+		}
+		return *t, true
+	}
+	return APIToken{}, false
+}
+
+func (s *apiTokenStore) Revoke(id string) (APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.list {
+		if s.list[i].ID != id {
+			continue
+		}
+		if s.list[i].RevokedAt != nil {
+			return s.list[i], nil
+		}
+		now := time.Now()
+		s.list[i].RevokedAt = &now
+		if err := s.saveLocked(); err != nil {
+			s.list[i].RevokedAt = nil
+			return APIToken{}, err
+		}
+		return s.list[i], nil
+	}
+	return APIToken{}, fmt.Errorf("令牌不存在: %s", id)
+}
+
+func (s *apiTokenStore) List() []APIToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]APIToken, len(s.list))
+	copy(out, s.list)
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+// tokenCreator 返回令牌的创建者；令牌吊销后记录仍保留，找不到时返回空字符串。
+func (a *App) tokenCreator(tokenID string) string {
+	for _, t := range a.tokens.List() {
+		if t.ID == tokenID {
+			return t.CreatedBy
+		}
+	}
+	return ""
+}
+
+func (t APIToken) AllowsAction(action string) bool {
+	for _, a := range t.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func (t APIToken) AllowsProject(projectID string) bool {
+	for _, p := range t.Projects {
+		if p == "*" || p == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenRole 将令牌动作映射为路由角色：只要包含写动作即按 operator 处理，具体动作再由 tokenActionForRequest 限定。
+func tokenRole(t APIToken) string {
+	for _, a := range t.Actions {
+		if a != TokenActionRead {
+			return RoleOperator
+		}
+	}
+	return RoleViewer
+}
+
+// tokenActionForRequest 返回令牌可访问的接口对应的动作；不在列表内的接口一律不允许令牌调用。
+func tokenActionForRequest(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	isRead := r.Method == http.MethodGet || r.Method == http.MethodHead
+	switch {
+	case path == "/api/upload" && r.Method == http.MethodPost:
+		return TokenActionDeploy, true
+	case path == "/api/preview" && r.Method == http.MethodPost:
+		return TokenActionPreview, true
+	case strings.HasPrefix(path, "/api/deployments/") && strings.HasSuffix(path, "/rollback") && r.Method == http.MethodPost:
+		return TokenActionRollback, true
+	case strings.HasPrefix(path, "/api/deployments/") && (strings.HasSuffix(path, "/cancel") || strings.HasSuffix(path, "/queue")) && r.Method == http.MethodPost:
+		return TokenActionDeploy, true
+	case (path == "/api/releases" || (strings.HasPrefix(path, "/api/releases/") && strings.HasSuffix(path, "/cancel"))) && r.Method == http.MethodPost:
+		return TokenActionDeploy, true
+	case isRead && (path == "/api/config" || strings.HasPrefix(path, "/api/deployments/") || strings.HasPrefix(path, "/partials/deployments") ||
+		strings.HasPrefix(path, "/api/releases") || strings.HasPrefix(path, "/partials/releases")):
+		return TokenActionRead, true
+	}
+	return "", false
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := strings.TrimSpace(r.Header.Get("Authorization"))
+	if h == "" {
+		return "", false
+	}
+	scheme, value, ok := strings.Cut(h, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(value), true
+}
+
+func normalizeTokenActions(values []string) []string {
+	seen := make(map[string]bool)
+	for _, raw := range values {
+		for _, v := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+			seen[strings.ToLower(strings.TrimSpace(v))] = true
+		}
+	}
+	out := make([]string, 0, len(tokenActions))
+	for _, a := range tokenActions {
+		if seen[a] {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func normalizeTokenProjects(raw string, projects []ManagedProject) ([]string, error) {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, v := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' || r == ' ' }) {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		if v != "*" {
+			if _, ok := findProjectByID(projects, v); !ok {
+				return nil, fmt.Errorf("未找到程序: %s", v)
+			}
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("至少需要指定一个程序 ID，或使用 * 表示全部程序")
+	}
+	return out, nil
+}
+
+func (a *App) handleTokensAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"tokens": tokenSnapshots(a.tokens.List())})
+	case http.MethodPost:
+		a.handleCreateToken(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *App) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	if err := parseRequestForm(r); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "请求参数解析失败"})
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 64 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "令牌名称不能为空且不超过 64 个字符"})
+		return
+	}
+	projects, err := normalizeTokenProjects(r.FormValue("projects"), a.currentConfig().Projects)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	actions := normalizeTokenActions(r.Form["actions"])
+	if len(actions) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "至少需要选择一个动作: deploy / preview / rollback / read"})
+		return
+	}
+	var expiresAt *time.Time
+	if raw := strings.TrimSpace(r.FormValue("expires_days")); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "expires_days 必须为非负整数"})
+			return
+		}
+		if days > 0 {
+			t := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+			expiresAt = &t
+		}
+	}
+
+	current := principalFromRequest(r)
+	token, plain, err := a.tokens.Create(APIToken{
+		Name:      name,
+		Projects:  projects,
+		Actions:   actions,
+		CreatedBy: current.Username,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": fmt.Sprintf("保存令牌失败: %v", err)})
+		return
+	}
+	a.logger.Info("API 令牌已创建", "token_id", token.ID, "name", token.Name, "projects", strings.Join(projects, ","), "actions", strings.Join(actions, ","), "operator", current.Username)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":      true,
+		"message": "令牌已创建，请立即复制保存，关闭后将无法再次查看",
+		"token":   plain,
+		"tokens":  tokenSnapshots(a.tokens.List()),
+	})
+}
+
+func (a *App) handleTokenItemAPI(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/tokens/"))
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, err := a.tokens.Revoke(id)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	a.logger.Info("API 令牌已吊销", "token_id", token.ID, "name", token.Name, "operator", principalFromRequest(r).Username)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":      true,
+		"message": fmt.Sprintf("令牌 %s 已吊销", token.Name),
+		"tokens":  tokenSnapshots(a.tokens.List()),
+	})
+}
+
+func tokenSnapshots(tokens []APIToken) []map[string]any {
+	out := make([]map[string]any, 0, len(tokens))
+	for _, t := range tokens {
+		out = append(out, map[string]any{
+			"id":           t.ID,
+			"name":         t.Name,
+			"hint":         t.Hint,
+			"projects":     t.Projects,
+			"actions":      t.Actions,
+			"created_by":   t.CreatedBy,
+			"created_at":   t.CreatedAt,
+			"expires_at":   t.ExpiresAt,
+			"last_used_at": t.LastUsedAt,
+			"last_used_ip": t.LastUsedIP,
+			"revoked":      t.RevokedAt != nil,
+			"revoked_at":   t.RevokedAt,
+		})
+	}
+	return out
+}
+
+func (p authPrincipal) TokenID() string {
+	if p.Token == nil {
+		return ""
+	}
+	return p.Token.ID
+}
+
+func (p authPrincipal) TokenName() string {
+	if p.Token == nil {
+		return ""
+	}
+	return p.Token.Name
+}
+
+// visibleDeployments 返回当前身份可见的部署记录：API 令牌限于其程序范围，配置了程序授权的用户限于授权程序。
+func (a *App) visibleDeployments(r *http.Request) []Deployment {
+	all := a.store.List()
+	principal := principalFromRequest(r)
+	out := make([]Deployment, 0, len(all))
+	for _, d := range all {
+		if principal.CanAccessProject(d.ProjectID) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
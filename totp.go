@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpIssuer            = "SimpleRemoteUpdate"
+	totpPeriod            = 30
+	totpDigits            = 6
+	totpSkewSteps         = 1
+	recoveryCodeCount     = 10
+	loginChallengeTTL     = 5 * time.Minute
+	loginChallengeMaxFail = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return totpEncoding.EncodeToString([]byte(randomHex(10)))
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+func totpCodeAt(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for range totpDigits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus), nil
+}
+
+// verifyTOTP 按 RFC 6238 校验验证码，允许前后各 totpSkewSteps 个周期的时钟偏差；
+// 返回命中的时间步，调用方需保存并拒绝不大于 lastStep 的重放。
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits || secret == "" {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for delta := int64(-totpSkewSteps); delta <= totpSkewSteps; delta++ {
+		step := current + delta
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCodeAt(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", totpIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", totpDigits))
+	q.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// newRecoveryCodes 生成一次性恢复码，返回明文（仅展示一次）与落盘用的哈希。
+func newRecoveryCodes() ([]string, []string) {
+	plain := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := randomHex(5)
+		plain = append(plain, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, sha256Hex(raw))
+	}
+	return plain, hashes
+}
+
+// consumeSecondFactor 校验 TOTP 验证码或恢复码，成功后更新重放时间步或作废已用恢复码。
+func (a *App) consumeSecondFactor(username, code string) (bool, bool) {
+	usedRecovery := false
+	err := a.users.Save(username, func(u *UserAccount, exists bool) error {
+		if !exists || !u.TOTPEnabled {
+			return errors.New("未启用两步验证")
+		}
+		if step, ok := verifyTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastStep); ok {
+			u.TOTPLastStep = step
+			return nil
+		}
+		hash := sha256Hex(normalizeRecoveryCode(code))
+		for i, h := range u.RecoveryCodes {
+			if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+				u.RecoveryCodes = append(u.RecoveryCodes[:i:i], u.RecoveryCodes[i+1:]...)
+				usedRecovery = true
+				return nil
+			}
+		}
+		return errors.New("验证码错误")
+	})
+	return err == nil, usedRecovery
+}
+
+type loginChallenge struct {
+	Username  string
+	Secret    string
+	ExpiresAt time.Time
+	Failures  int
+}
+
+// loginChallengeStore 保存密码校验通过、等待第二步验证的登录挑战，仅存于内存。
+type loginChallengeStore struct {
+	mu    sync.Mutex
+	items map[string]*loginChallenge
+}
+
+func newLoginChallengeStore() *loginChallengeStore {
+	return &loginChallengeStore{items: make(map[string]*loginChallenge)}
+}
+
+func (s *loginChallengeStore) Create(username, secret string) string {
+	token := randomHex(24)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, v := range s.items {
+		if now.After(v.ExpiresAt) {
+			delete(s.items, k)
+		}
+	}
+	s.items[token] = &loginChallenge{Username: username, Secret: secret, ExpiresAt: now.Add(loginChallengeTTL)}
+	return token
+}
+
+func (s *loginChallengeStore) Get(token string) (loginChallenge, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[token]
+	if !ok {
+		return loginChallenge{}, false
+	}
+	if time.Now().After(c.ExpiresAt) {
+		delete(s.items, token)
+		return loginChallenge{}, false
+	}
+	return *c, true
+}
+
+// Fail 记录一次验证失败，超过上限后作废挑战，需要重新输入密码。
+func (s *loginChallengeStore) Fail(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[token]
+	if !ok {
+		return false
+	}
+	c.Failures++
+	if c.Failures >= loginChallengeMaxFail {
+		delete(s.items, token)
+		return false
+	}
+	return true
+}
+
+func (s *loginChallengeStore) Delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, token)
+}
+
+// handleLoginSecondFactor 处理登录第二步：已启用用户输入验证码/恢复码，强制启用但未绑定的用户完成绑定。
+func (a *App) handleLoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	challengeToken := r.FormValue("challenge")
+	ch, ok := a.challenges.Get(challengeToken)
+	if !ok {
+		a.renderLogin(w, map[string]any{"Error": "登录验证已过期，请重新输入密码"})
+		return
+	}
+	user, found := a.users.Get(ch.Username)
+	if !found || user.Disabled {
+		a.challenges.Delete(challengeToken)
+		a.renderLogin(w, map[string]any{"Error": "用户名或密码错误"})
+		return
+	}
+	code := r.FormValue("code")
+
+	if ch.Secret != "" {
+		step, ok := verifyTOTP(ch.Secret, code, time.Now(), 0)
+		if !ok {
+			a.logger.Warn("两步验证绑定失败", "username", user.Username, "ip", a.clientIP(r))
+			a.recordLoginFailure(a.clientIP(r), user.Username, "两步验证绑定验证码错误")
+			if !a.challenges.Fail(challengeToken) {
+				a.renderLogin(w, map[string]any{"Error": "验证失败次数过多，请重新输入密码", "Username": user.Username})
+				return
+			}
+			a.renderLogin(w, enrollLoginData(user.Username, challengeToken, ch.Secret, "验证码错误，请确认手机时间准确后重试"))
+			return
+		}
+		plainCodes, hashes := newRecoveryCodes()
+		if err := a.users.Save(user.Username, func(u *UserAccount, exists bool) error {
+			if !exists {
+				return fmt.Errorf("用户不存在: %s", user.Username)
+			}
+			u.TOTPSecret = ch.Secret
+			u.TOTPEnabled = true
+			u.TOTPLastStep = step
+			u.RecoveryCodes = hashes
+			return nil
+		}); err != nil {
+			a.renderLogin(w, enrollLoginData(user.Username, challengeToken, ch.Secret, fmt.Sprintf("保存两步验证失败: %v", err)))
+			return
+		}
+		a.challenges.Delete(challengeToken)
+		a.logger.Info("两步验证已绑定", "username", user.Username, "ip", a.clientIP(r))
+		a.startSession(w, r, user)
+		a.renderLogin(w, map[string]any{"Step": "recovery", "Username": user.Username, "RecoveryCodes": plainCodes})
+		return
+	}
+
+	ok, usedRecovery := a.consumeSecondFactor(user.Username, code)
+	if !ok {
+		a.logger.Warn("两步验证失败", "username", user.Username, "ip", a.clientIP(r))
+		a.recordLoginFailure(a.clientIP(r), user.Username, "两步验证码错误")
+		if !a.challenges.Fail(challengeToken) {
+			a.renderLogin(w, map[string]any{"Error": "验证失败次数过多，请重新输入密码", "Username": user.Username})
+			return
+		}
+		a.renderLogin(w, map[string]any{"Step": "totp", "Username": user.Username, "Challenge": challengeToken, "Error": "验证码错误"})
+		return
+	}
+	a.challenges.Delete(challengeToken)
+	if usedRecovery {
+		a.logger.Warn("使用恢复码登录", "username", user.Username, "ip", a.clientIP(r))
+	}
+	a.startSession(w, r, user)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// renderSecondFactorStep 在用户已启用两步验证或系统要求两步验证时创建登录挑战并渲染第二步页面，返回 true；
+// 密码登录与单点登录共用，均需通过这一步才建立会话。
+func (a *App) renderSecondFactorStep(w http.ResponseWriter, cfg Config, user UserAccount) bool {
+	if user.TOTPEnabled {
+		challenge := a.challenges.Create(user.Username, "")
+		a.renderLogin(w, map[string]any{"Step": "totp", "Username": user.Username, "Challenge": challenge})
+		return true
+	}
+	if cfg.TOTPRequired {
+		secret := newTOTPSecret()
+		challenge := a.challenges.Create(user.Username, secret)
+		a.renderLogin(w, enrollLoginData(user.Username, challenge, secret, ""))
+		return true
+	}
+	return false
+}
+
+func enrollLoginData(username, challenge, secret, errMsg string) map[string]any {
+	return map[string]any{
+		"Step":       "enroll",
+		"Username":   username,
+		"Challenge":  challenge,
+		"TOTPSecret": secret,
+		"TOTPURI":    totpURI(username, secret),
+		"Error":      errMsg,
+	}
+}
+
+// handleAccountTOTPAPI 管理当前用户自己的两步验证：查询状态、生成密钥、启用、停用、重置恢复码。
+func (a *App) handleAccountTOTPAPI(w http.ResponseWriter, r *http.Request) {
+	current := principalFromRequest(r)
+	user, found := a.users.Get(current.Username)
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "用户不存在"})
+		return
+	}
+	cfg := a.currentConfig()
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, totpStatus(user, cfg))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := parseRequestForm(r); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "请求参数解析失败"})
+		return
+	}
+	code := r.FormValue("code")
+	var recoveryCodes []string
+	var message string
+	var secret string
+
+	action := strings.ToLower(strings.TrimSpace(r.FormValue("action")))
+	if action == "disable" {
+		// 口令校验耗时较长，在用户存储锁外完成；写入时确认密码在此期间未被修改。
+		if status, err := a.checkCurrentPassword(r, user, r.FormValue("password"), "停用两步验证时当前密码错误"); err != nil {
+			writeJSON(w, status, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	err := a.users.Save(user.Username, func(u *UserAccount, exists bool) error {
+		if !exists {
+			return fmt.Errorf("用户不存在: %s", user.Username)
+		}
+		switch action {
+		case "begin":
+			if u.TOTPEnabled {
+				return errors.New("两步验证已启用，如需更换请先停用")
+			}
+			secret = newTOTPSecret()
+			u.TOTPPendingSecret = secret
+			message = "请使用验证器扫描或手动录入密钥，然后输入验证码完成启用"
+		case "enable":
+			if u.TOTPEnabled {
+				return errors.New("两步验证已启用")
+			}
+			step, ok := verifyTOTP(u.TOTPPendingSecret, code, time.Now(), 0)
+			if !ok {
+				return errors.New("验证码错误，请确认手机时间准确后重试")
+			}
+			plain, hashes := newRecoveryCodes()
+			u.TOTPSecret = u.TOTPPendingSecret
+			u.TOTPPendingSecret = ""
+			u.TOTPEnabled = true
+			u.TOTPLastStep = step
+			u.RecoveryCodes = hashes
+			recoveryCodes = plain
+			message = "两步验证已启用，请妥善保存恢复码"
+		case "disable":
+			if cfg.TOTPRequired {
+				return errors.New("系统已强制要求两步验证，不能停用")
+			}
+			if !u.TOTPEnabled {
+				return errors.New("两步验证未启用")
+			}
+			if u.PasswordHash != user.PasswordHash {
+				return errors.New("密码已被修改，请重试")
+			}
+			step, ok := verifyTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+			if !ok {
+				return errors.New("验证码错误")
+			}
+			u.TOTPLastStep = step
+			clearTOTP(u)
+			message = "两步验证已停用"
+		case "recovery_codes":
+			if !u.TOTPEnabled {
+				return errors.New("两步验证未启用")
+			}
+			step, ok := verifyTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastStep)
+			if !ok {
+				return errors.New("验证码错误")
+			}
+			plain, hashes := newRecoveryCodes()
+			u.TOTPLastStep = step
+			u.RecoveryCodes = hashes
+			recoveryCodes = plain
+			message = "恢复码已重新生成，旧恢复码全部失效"
+		default:
+			return fmt.Errorf("未知操作: %s", action)
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	a.logger.Info("两步验证设置已更新", "username", user.Username, "action", action, "ip", a.clientIP(r))
+	user, _ = a.users.Get(user.Username)
+	out := totpStatus(user, cfg)
+	out["ok"] = true
+	out["message"] = message
+	if secret != "" {
+		out["secret"] = secret
+		out["otpauth_uri"] = totpURI(user.Username, secret)
+	}
+	if len(recoveryCodes) > 0 {
+		out["recovery_codes"] = recoveryCodes
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func totpStatus(u UserAccount, cfg Config) map[string]any {
+	return map[string]any{
+		"enabled":             u.TOTPEnabled,
+		"required":            cfg.TOTPRequired,
+		"recovery_codes_left": len(u.RecoveryCodes),
+	}
+}
+
+func clearTOTP(u *UserAccount) {
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPPendingSecret = ""
+	u.RecoveryCodes = nil
+}
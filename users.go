@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const bootstrapAdminUser = "admin"
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+type userStore struct {
+	mu   sync.Mutex
+	file string
+	list []UserAccount
+}
+
+// newUserStore 加载用户文件；文件不存在或为空时，用旧版共享密钥哈希初始化 admin 账号，
+// 保证从单密钥版本升级后仍可用原密钥登录。
+func newUserStore(file, bootstrapHash string) (*userStore, error) {
+	s := &userStore{
+		file: file,
+		list: make([]UserAccount, 0),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if len(s.list) == 0 {
+		now := time.Now()
+		s.list = append(s.list, UserAccount{
+			Username:     bootstrapAdminUser,
+			Role:         RoleAdmin,
+			PasswordHash: normalizePasswordHash(bootstrapHash),
+			CreatedAt:    now,
+			UpdatedAt:    now,
+		})
+		s.mu.Lock()
+		err := s.saveLocked()
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *userStore) load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := os.ReadFile(s.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			s.list = []UserAccount{}
+			return nil
+		}
+		return err
+	}
+	if len(b) == 0 {
+		s.list = []UserAccount{}
+		return nil
+	}
+	var out []UserAccount
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	for i := range out {
+		out[i].Role = normalizeRole(out[i].Role)
+	}
+	s.list = out
+	return nil
+}
+
+func (s *userStore) saveLocked() error {
+	raw, err := json.MarshalIndent(s.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.file); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+func (s *userStore) Get(username string) (UserAccount, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.list {
+		if strings.EqualFold(s.list[i].Username, username) {
+			return s.list[i], true
+		}
+	}
+	return UserAccount{}, false
+}
+
+func (s *userStore) List() []UserAccount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]UserAccount, len(s.list))
+	copy(out, s.list)
+	sort.Slice(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Username) < strings.ToLower(out[j].Username)
+	})
+	return out
+}
+
+// Save 新增或更新用户；fn 在锁内执行，可对同名已有账号做增量修改。
+func (s *userStore) Save(username string, fn func(u *UserAccount, exists bool) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for i := range s.list {
+		if !strings.EqualFold(s.list[i].Username, username) {
+			continue
+		}
+		updated := s.list[i]
+		if err := fn(&updated, true); err != nil {
+			return err
+		}
+		updated.Role = normalizeRole(updated.Role)
+		updated.UpdatedAt = now
+		old := s.list[i]
+		s.list[i] = updated
+		if err := s.ensureAdminLocked(); err != nil {
+			s.list[i] = old
+			return err
+		}
+		if err := s.saveLocked(); err != nil {
+			s.list[i] = old
+			return err
+		}
+		return nil
+	}
+	u := UserAccount{Username: username, CreatedAt: now, UpdatedAt: now}
+	if err := fn(&u, false); err != nil {
+		return err
+	}
+	u.Role = normalizeRole(u.Role)
+	s.list = append(s.list, u)
+	if err := s.saveLocked(); err != nil {
+		s.list = s.list[:len(s.list)-1]
+		return err
+	}
+	return nil
+}
+
+func (s *userStore) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.list
+	filtered := make([]UserAccount, 0, len(s.list))
+	removed := false
+	for _, u := range s.list {
+		if strings.EqualFold(u.Username, username) {
+			removed = true
+			continue
+		}
+		filtered = append(filtered, u)
+	}
+	if !removed {
+		return fmt.Errorf("用户不存在: %s", username)
+	}
+	s.list = filtered
+	if err := s.ensureAdminLocked(); err != nil {
+		s.list = old
+		return err
+	}
+	if err := s.saveLocked(); err != nil {
+		s.list = old
+		return err
+	}
+	return nil
+}
+
+func (s *userStore) ensureAdminLocked() error {
+	for _, u := range s.list {
+		if u.Role == RoleAdmin && !u.Disabled {
+			return nil
+		}
+	}
+	return errors.New("至少需要保留一个启用状态的 admin 用户")
+}
+
+func (s *userStore) HasDefaultPassword() bool {
+	// 未命中缓存时需要计算 argon2id，在锁外比较。
+	s.mu.Lock()
+	hashes := make([]string, 0, len(s.list))
+	for _, u := range s.list {
+		if !u.Disabled {
+			hashes = append(hashes, u.PasswordHash)
+		}
+	}
+	s.mu.Unlock()
+	for _, hash := range hashes {
+		if isDefaultAuthHash(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeRole(role string) string {
+	switch strings.ToLower(strings.TrimSpace(role)) {
+	case RoleAdmin:
+		return RoleAdmin
+	case RoleOperator:
+		return RoleOperator
+	default:
+		return RoleViewer
+	}
+}
+
+func roleRank(role string) int {
+	switch normalizeRole(role) {
+	case RoleAdmin:
+		return 3
+	case RoleOperator:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func roleAllows(have, need string) bool {
+	return roleRank(have) >= roleRank(need)
+}
+
+type authPrincipal struct {
+	Username  string
+	Role      string
+	SessionID string
+	CSRFToken string
+	Token     *APIToken
+	Grants    []ProjectGrant
+}
+
+type principalCtxKey struct{}
+
+func withPrincipal(ctx context.Context, p authPrincipal) context.Context {
+	return context.WithValue(ctx, principalCtxKey{}, p)
+}
+
+func principalFromRequest(r *http.Request) authPrincipal {
+	if r == nil {
+		return authPrincipal{}
+	}
+	p, _ := r.Context().Value(principalCtxKey{}).(authPrincipal)
+	return p
+}
+
+func (a *App) handleUsersAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]any{"users": userSnapshots(a.users.List())})
+	case http.MethodPost:
+		a.handleSaveUser(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *App) handleSaveUser(w http.ResponseWriter, r *http.Request) {
+	if err := parseRequestForm(r); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "请求参数解析失败"})
+		return
+	}
+	username := strings.TrimSpace(r.FormValue("username"))
+	if !usernamePattern.MatchString(username) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "用户名格式错误，仅支持字母、数字及 . _ @ -，长度 1-64"})
+		return
+	}
+	role := normalizeRole(r.FormValue("role"))
+	password := r.FormValue("password")
+	disabled := parseBoolFormValue(r.FormValue("disabled"))
+	resetTOTP := parseBoolFormValue(r.FormValue("reset_totp"))
+	current := principalFromRequest(r)
+	if strings.EqualFold(current.Username, username) && (disabled || role != RoleAdmin) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "不能禁用当前登录用户或降低其角色"})
+		return
+	}
+	var grants []ProjectGrant
+	_, updateGrants := r.Form["grants_text"]
+	if updateGrants {
+		parsed, err := parseGrantsText(r.FormValue("grants_text"), a.currentConfig().Projects)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		grants = parsed
+	}
+
+	passwordHash := ""
+	if strings.TrimSpace(password) != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		passwordHash = hash
+	}
+
+	created := false
+	err := a.users.Save(username, func(u *UserAccount, exists bool) error {
+		if !exists {
+			if strings.TrimSpace(password) == "" {
+				return errors.New("新建用户必须设置密码")
+			}
+			created = true
+		}
+		u.Role = role
+		u.Disabled = disabled
+		if updateGrants {
+			u.Grants = grants
+		}
+		if passwordHash != "" {
+			u.PasswordHash = passwordHash
+		}
+		if resetTOTP {
+			clearTOTP(u)
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if disabled || (resetTOTP && !strings.EqualFold(current.Username, username)) {
+		a.sessions.DeleteUser(username)
+	}
+	msg := fmt.Sprintf("用户 %s 已更新", username)
+	if created {
+		msg = fmt.Sprintf("用户 %s 已创建", username)
+	}
+	a.logger.Info("用户已保存", "username", username, "role", role, "operator", current.Username)
+	if strings.TrimSpace(password) != "" {
+		a.recordAudit(r, AuditActionPasswordChange, username, nil)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":      true,
+		"message": msg,
+		"users":   userSnapshots(a.users.List()),
+	})
+}
+
+func (a *App) handleUserItemAPI(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/api/users/"))
+	if username == "" || strings.Contains(username, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	current := principalFromRequest(r)
+	if strings.EqualFold(current.Username, username) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "不能删除当前登录用户"})
+		return
+	}
+	if err := a.users.Delete(username); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	a.sessions.DeleteUser(username)
+	a.logger.Info("用户已删除", "username", username, "operator", current.Username)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"ok":      true,
+		"message": fmt.Sprintf("用户 %s 已删除", username),
+		"users":   userSnapshots(a.users.List()),
+	})
+}
+
+func userSnapshots(users []UserAccount) []map[string]any {
+	out := make([]map[string]any, 0, len(users))
+	for _, u := range users {
+		out = append(out, map[string]any{
+			"username":   u.Username,
+			"role":       u.Role,
+			"disabled":   u.Disabled,
+			"totp":       u.TOTPEnabled,
+			"grants":     u.Grants,
+			"sso":        u.OIDCSubject != "",
+			"created_at": u.CreatedAt,
+			"updated_at": u.UpdatedAt,
+		})
+	}
+	return out
+}
+
+// handleAccountPasswordAPI 供任意已登录用户修改自己的密码，需校验当前密码。
+func (a *App) handleAccountPasswordAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := parseRequestForm(r); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "请求参数解析失败"})
+		return
+	}
+	current := principalFromRequest(r)
+	oldPassword := r.FormValue("current_password")
+	newPassword := r.FormValue("new_password")
+	if strings.TrimSpace(newPassword) == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "新密码不能为空"})
+		return
+	}
+	// 口令校验与哈希耗时较长，在用户存储锁外完成；写入时确认密码在此期间未被修改。
+	user, found := a.users.Get(current.Username)
+	if !found {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": fmt.Sprintf("用户不存在: %s", current.Username)})
+		return
+	}
+	if status, err := a.checkCurrentPassword(r, user, oldPassword, "修改密码时当前密码错误"); err != nil {
+		writeJSON(w, status, map[string]any{"error": err.Error()})
+		return
+	}
+	newHash, err := hashPassword(newPassword)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errPasswordHashBusy) {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, map[string]any{"error": err.Error()})
+		return
+	}
+	err = a.users.Save(current.Username, func(u *UserAccount, exists bool) error {
+		if !exists {
+			return fmt.Errorf("用户不存在: %s", current.Username)
+		}
+		if u.PasswordHash != user.PasswordHash {
+			return errors.New("密码已被修改，请重试")
+		}
+		u.PasswordHash = newHash
+		return nil
+	})
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	a.logger.Info("用户已修改密码", "username", current.Username, "ip", a.clientIP(r))
+	a.recordAudit(r, AuditActionPasswordChange, current.Username, nil)
+	writeJSON(w, http.StatusOK, map[string]any{"ok": true, "message": "密码已更新"})
+}
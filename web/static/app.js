@@ -2,6 +2,7 @@
   const pageMode = `${document.body?.dataset?.pageMode || "standard"}`.trim().toLowerCase();
   const initialDeployURL = `${document.body?.dataset?.initialDeployUrl || "/initial-deploy"}`.trim() || "/initial-deploy";
   const standardDeployURL = `${document.body?.dataset?.standardDeployUrl || "/"}`.trim() || "/";
+  const csrfToken = document.querySelector('meta[name="csrf-token"]')?.getAttribute("content") || "";
   const uploadForm = document.getElementById("upload-form");
   const progressBar = document.getElementById("upload-progress-bar");
   const progressLabel = document.getElementById("upload-progress-label");
@@ -16,6 +17,7 @@
   const logStageProgressLabel = document.getElementById("log-stage-progress-label");
   const logStageProgressBar = document.getElementById("log-stage-progress-bar");
   const deploymentsContainer = document.getElementById("deployments-container");
+  const releasesContainer = document.getElementById("releases-container");
   const runtimeSummary = document.getElementById("runtime-summary");
   const maxUploadLabel = document.getElementById("max-upload-label");
   const projectSelect = document.getElementById("project-select");
@@ -58,6 +60,45 @@
   const changesPreviewHint = document.getElementById("changes-preview-hint");
   const changesPreviewCancel = document.getElementById("changes-preview-cancel");
   const changesPreviewConfirm = document.getElementById("changes-preview-confirm");
+
+  const openUsersBtn = document.getElementById("open-users-btn");
+  const usersDialog = document.getElementById("users-dialog");
+  const usersClose = document.getElementById("users-close");
+  const usersTbody = document.getElementById("users-tbody");
+  const userForm = document.getElementById("user-form");
+  const userFormReset = document.getElementById("user-form-reset");
+  const usersMessage = document.getElementById("users-message");
+  const openTokensBtn = document.getElementById("open-tokens-btn");
+  const tokensDialog = document.getElementById("tokens-dialog");
+  const tokensClose = document.getElementById("tokens-close");
+  const tokensTbody = document.getElementById("tokens-tbody");
+  const tokenForm = document.getElementById("token-form");
+  const tokensMessage = document.getElementById("tokens-message");
+  const openAuditBtn = document.getElementById("open-audit-btn");
+  const auditDialog = document.getElementById("audit-dialog");
+  const auditClose = document.getElementById("audit-close");
+  const auditTbody = document.getElementById("audit-tbody");
+  const auditMessage = document.getElementById("audit-message");
+  const auditMoreBtn = document.getElementById("audit-more");
+  const auditFilterForm = document.getElementById("audit-filter-form");
+  const tokenCreatedValue = document.getElementById("token-created-value");
+  const openAccountBtn = document.getElementById("open-account-btn");
+  const accountDialog = document.getElementById("account-dialog");
+  const accountClose = document.getElementById("account-close");
+  const accountPasswordForm = document.getElementById("account-password-form");
+  const accountMessage = document.getElementById("account-message");
+  const accountTotpForm = document.getElementById("account-totp-form");
+  const totpStatus = document.getElementById("totp-status");
+  const totpSetup = document.getElementById("totp-setup");
+  const totpSecret = document.getElementById("totp-secret");
+  const totpUri = document.getElementById("totp-uri");
+  const totpPasswordWrap = document.getElementById("totp-password-wrap");
+  const totpRecovery = document.getElementById("totp-recovery");
+  const totpMessage = document.getElementById("totp-message");
+  const sessionsTbody = document.getElementById("sessions-tbody");
+  const sessionsScopeAll = document.getElementById("sessions-scope-all");
+  const sessionsRevokeAll = document.getElementById("sessions-revoke-all");
+  const sessionsMessage = document.getElementById("sessions-message");
   const activeProjectStorageKey = "updater.activeProjectId";
 
   let eventSource = null;
@@ -446,6 +487,20 @@
       service_args_text: Array.isArray(project?.service_args) ? project.service_args.join("\n") : "",
       backup_ignore_text: Array.isArray(project?.backup_ignore) ? project.backup_ignore.join("\n") : "",
       replace_ignore_text: Array.isArray(project?.replace_ignore) ? project.replace_ignore.join("\n") : "",
+      require_signature: project?.require_signature ? "true" : "false",
+      deploy_strategy: project?.deploy_strategy || "in_place",
+      require_approval: project?.require_approval ? "true" : "false",
+      auto_rollback_on_failure: project?.auto_rollback_on_failure ? "true" : "false",
+      auto_recover_interrupted: project?.auto_recover_interrupted ? "true" : "false",
+      queue_supersede: project?.queue_supersede ? "true" : "false",
+      signing_keys_text: Array.isArray(project?.signing_keys)
+        ? project.signing_keys.map((k) => `${k.id} ${k.public_key}`).join("\n")
+        : "",
+      hooks_json: Array.isArray(project?.hooks) && project.hooks.length > 0 ? JSON.stringify(project.hooks, null, 2) : "",
+      health_check_json: project?.health_check ? JSON.stringify(project.health_check, null, 2) : "",
+      instances_json: Array.isArray(project?.instances) && project.instances.length > 0 ? JSON.stringify(project.instances, null, 2) : "",
+      rolling_batch_size: project?.rolling_batch_size || 1,
+      rolling_pause_sec: project?.rolling_pause_sec || 0,
     };
     Object.keys(map).forEach((k) => {
       const input = projectForm.elements.namedItem(k);
@@ -495,6 +550,29 @@
       nssm_exe_path: cfg.nssm_exe_path || "nssm.exe",
       notify_email: cfg.notify_email || "",
       self_update_service_name: cfg.self_update_service_name || "",
+      totp_required: cfg.totp_required ? "true" : "false",
+      session_idle_minutes: `${cfg.session_idle_minutes ?? 60}`,
+      trusted_proxies_text: cfg.trusted_proxies_text || "",
+      login_max_failures: `${cfg.login_max_failures ?? 5}`,
+      login_lockout_minutes: `${cfg.login_lockout_minutes ?? 1}`,
+      login_max_lockout_minutes: `${cfg.login_max_lockout_minutes ?? 60}`,
+      login_global_max_failures: `${cfg.login_global_max_failures ?? 100}`,
+      tls_enabled: cfg.tls_enabled ? "true" : "false",
+      tls_auto_self_signed: cfg.tls_auto_self_signed === false ? "false" : "true",
+      tls_redirect_addr: cfg.tls_redirect_addr || "",
+      tls_cert_file: cfg.tls_cert_file || "",
+      tls_key_file: cfg.tls_key_file || "",
+      oidc_enabled: cfg.oidc_enabled ? "true" : "false",
+      oidc_display_name: cfg.oidc_display_name || "",
+      oidc_issuer: cfg.oidc_issuer || "",
+      oidc_client_id: cfg.oidc_client_id || "",
+      oidc_scopes: cfg.oidc_scopes || "",
+      oidc_redirect_url: cfg.oidc_redirect_url || "",
+      oidc_ca_file: cfg.oidc_ca_file || "",
+      oidc_username_claim: cfg.oidc_username_claim || "",
+      oidc_role_claim: cfg.oidc_role_claim || "",
+      oidc_default_role: cfg.oidc_default_role || "",
+      oidc_role_mapping_text: cfg.oidc_role_mapping_text || "",
     };
     Object.keys(map).forEach((k) => {
       const input = systemForm.elements.namedItem(k);
@@ -504,6 +582,8 @@
     if (keyInput) keyInput.value = "";
     const notifyKeyInput = systemForm.elements.namedItem("notify_email_auth_code");
     if (notifyKeyInput) notifyKeyInput.value = "";
+    const oidcSecretInput = systemForm.elements.namedItem("oidc_client_secret");
+    if (oidcSecretInput) oidcSecretInput.value = "";
   }
 
   function selectProject(projectID, options = {}) {
@@ -564,7 +644,7 @@
     }
   }
 
-  async function connectLogs(id) {
+  async function connectLogs(id, apiBase = "/api/deployments") {
     if (!id) return;
     if (eventSource) {
       eventSource.close();
@@ -579,14 +659,14 @@
 
     let dep = null;
     try {
-      const res = await fetch(`/api/deployments/${id}`, { credentials: "same-origin" });
+      const res = await fetch(`${apiBase}/${id}`, { credentials: "same-origin" });
       if (!res.ok) {
         appendLog(`[${new Date().toLocaleTimeString()}] 任务信息读取失败 (${res.status})`, "error");
         return;
       }
       dep = await res.json();
       appendLog(
-        `[${new Date().toLocaleTimeString()}] 任务状态: ${dep.status || "-"} | 类型: ${dep.type || "-"} | 版本: ${dep.version || "-"}`,
+        `[${new Date().toLocaleTimeString()}] 任务状态: ${dep.status || "-"} | 类型: ${dep.type || (Array.isArray(dep.members) ? "release" : "-")} | 版本: ${dep.version || "-"}`,
       );
       if (dep.error) {
         appendLog(`[${new Date().toLocaleTimeString()}] 错误: ${dep.error}`, "error");
@@ -597,14 +677,14 @@
     }
 
     const status = `${dep?.status || ""}`.trim().toLowerCase();
-    const doneStatuses = new Set(["success", "failed", "canceled", "cancelled"]);
+    const doneStatuses = new Set(["success", "failed", "canceled", "cancelled", "rejected", "interrupted", "aborted"]);
     if (doneStatuses.has(status)) {
       appendLog(`[${new Date().toLocaleTimeString()}] 任务已结束，当前显示为任务摘要（无实时增量日志）`, "warn");
       return;
     }
 
     appendLog(`[${new Date().toLocaleTimeString()}] 连接实时日志流...`);
-    eventSource = new EventSource(`/api/deployments/${id}/events`);
+    eventSource = new EventSource(`${apiBase}/${id}/events`);
     eventSource.onmessage = (e) => {
       try {
         const payload = JSON.parse(e.data);
@@ -633,6 +713,15 @@
     } catch (_e) {}
   }
 
+  async function refreshReleases() {
+    if (!releasesContainer) return;
+    try {
+      const res = await fetch("/partials/releases", { credentials: "same-origin" });
+      if (!res.ok) return;
+      releasesContainer.innerHTML = await res.text();
+    } catch (_e) {}
+  }
+
   function renderChangesDialogData(dep, titleText) {
     if (!changesDialog) return;
     const changed = Array.isArray(dep?.changed) ? dep.changed : [];
@@ -733,31 +822,68 @@
     });
   }
 
-  async function showChangesDialog(id) {
+  const approvalPrompts = {
+    approve: "批准该部署，审批意见（可选）：",
+    reject: "拒绝该部署，拒绝原因（可选）：",
+    comment: "评论内容：",
+  };
+
+  async function approvalAction(id, action) {
+    const comment = window.prompt(approvalPrompts[action] || "意见：", "");
+    if (comment === null) return;
+    if (action === "comment" && !comment.trim()) return;
+    const body = new FormData();
+    body.append("comment", comment);
+    try {
+      const res = await fetch(`/api/deployments/${encodeURIComponent(id)}/${action}`, {
+        method: "POST",
+        headers: { "X-CSRF-Token": csrfToken },
+        body,
+        credentials: "same-origin",
+      });
+      if (!res.ok) {
+        window.alert((await res.text()) || `操作失败 (${res.status})`);
+        return;
+      }
+    } catch (_e) {
+      window.alert("操作失败");
+      return;
+    }
+    refreshDeployments();
+  }
+
+  async function showChangesDialog(id, pendingPreview) {
     if (!id || !changesDialog) return;
     clearPendingUpload();
-    changesDialogTitle.textContent = `变更明细 - ${id}`;
+    const title = pendingPreview ? `待执行变更预览 - ${id}` : `变更明细 - ${id}`;
+    changesDialogTitle.textContent = title;
     changesDialogSubtitle.textContent = "加载中...";
     changesIgnoreList.innerHTML = "";
     if (changesIgnoredPaths) changesIgnoredPaths.innerHTML = "";
     changesFileBody.innerHTML = "";
     openDialog(changesDialog);
     try {
-      const res = await fetch(`/api/deployments/${id}`, { credentials: "same-origin" });
+      const url = pendingPreview ? `/api/deployments/${id}/preview` : `/api/deployments/${id}`;
+      const res = await fetch(url, { credentials: "same-origin" });
       if (!res.ok) {
-        changesDialogSubtitle.textContent = `加载失败 (${res.status})`;
+        const payload = await res.json().catch(() => ({}));
+        changesDialogSubtitle.textContent = payload.error || `加载失败 (${res.status})`;
         return;
       }
       const dep = await res.json();
-      renderChangesDialogData(dep, `变更明细 - ${id}`);
+      renderChangesDialogData(dep, title);
     } catch (_e) {
       changesDialogSubtitle.textContent = "加载失败";
     }
   }
 
   window.refreshDeployments = refreshDeployments;
-  window.updaterViewLogs = connectLogs;
-  window.updaterShowChanges = showChangesDialog;
+  window.updaterViewLogs = (id) => connectLogs(id);
+  window.refreshReleases = refreshReleases;
+  window.updaterViewReleaseLogs = (id) => connectLogs(id, "/api/releases");
+  window.updaterShowChanges = (id) => showChangesDialog(id, false);
+  window.updaterShowPendingChanges = (id) => showChangesDialog(id, true);
+  window.updaterApprovalAction = approvalAction;
   refreshScheduleInputMin();
 
   function buildUploadFormData() {
@@ -805,6 +931,7 @@
     const xhr = new XMLHttpRequest();
     xhr.open("POST", "/api/upload", true);
     xhr.withCredentials = true;
+    xhr.setRequestHeader("X-CSRF-Token", csrfToken);
 
     xhr.upload.onprogress = (ev) => {
       if (!ev.lengthComputable) return;
@@ -818,12 +945,15 @@
           payload = JSON.parse(xhr.responseText);
         } catch (_err) {}
         setProgress(100);
-        const isScheduled = `${payload.status || ""}`.toLowerCase() === "scheduled";
-        if (isScheduled) {
+        const respStatus = `${payload.status || ""}`.toLowerCase();
+        if (respStatus === "scheduled" || respStatus === "pending_approval") {
           uploadMessage.textContent =
             payload.message || `任务已排队，任务ID: ${payload.id || "-"}，计划执行时间: ${payload.scheduled_at || "-"}`;
         } else {
           uploadMessage.textContent = `上传完成，任务ID: ${payload.id || "-"}，程序: ${payload.project_name || payload.project_id || "-"}，目标版本: ${payload.version || "-"}`;
+          if (Number(payload.queue_position) > 0) {
+            uploadMessage.textContent += `，排队第 ${payload.queue_position} 位`;
+          }
           if (payload.id) connectLogs(payload.id);
         }
         refreshDeployments();
@@ -858,6 +988,7 @@
       const xhr = new XMLHttpRequest();
       xhr.open("POST", "/api/preview", true);
       xhr.withCredentials = true;
+      xhr.setRequestHeader("X-CSRF-Token", csrfToken);
 
       xhr.upload.onprogress = (ev) => {
         if (!ev.lengthComputable) return;
@@ -1002,6 +1133,7 @@
       const xhr = new XMLHttpRequest();
       xhr.open("POST", "/api/self-update", true);
       xhr.withCredentials = true;
+      xhr.setRequestHeader("X-CSRF-Token", csrfToken);
 
       xhr.upload.onprogress = (ev) => {
         if (!ev.lengthComputable) return;
@@ -1065,6 +1197,7 @@
       try {
         const res = await fetch("/api/config", {
           method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
           body: formData,
           credentials: "same-origin",
         });
@@ -1093,6 +1226,7 @@
       try {
         const res = await fetch("/api/notify/test", {
           method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
           body: formData,
           credentials: "same-origin",
         });
@@ -1119,6 +1253,7 @@
       try {
         const res = await fetch("/api/config", {
           method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
           body: formData,
           credentials: "same-origin",
         });
@@ -1161,6 +1296,7 @@
       try {
         const res = await fetch("/api/projects", {
           method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
           body: formData,
           credentials: "same-origin",
         });
@@ -1239,6 +1375,7 @@
       try {
         const res = await fetch(`/api/projects/${encodeURIComponent(p.id)}`, {
           method: "DELETE",
+          headers: { "X-CSRF-Token": csrfToken },
           credentials: "same-origin",
         });
         const payload = await res.json().catch(() => ({}));
@@ -1305,6 +1442,651 @@
     });
   }
 
+  function setUsersMessage(text) {
+    setText(usersMessage, text);
+  }
+
+  function setAccountMessage(text) {
+    setText(accountMessage, text);
+  }
+
+  function bindDialogClose(dialogEl, closeBtn) {
+    if (!dialogEl || !closeBtn) return;
+    closeBtn.addEventListener("click", () => {
+      closeDialog(dialogEl);
+    });
+    dialogEl.addEventListener("click", (e) => {
+      const rect = dialogEl.getBoundingClientRect();
+      const inDialog =
+        rect.top <= e.clientY &&
+        e.clientY <= rect.top + rect.height &&
+        rect.left <= e.clientX &&
+        e.clientX <= rect.left + rect.width;
+      if (!inDialog) {
+        closeDialog(dialogEl);
+      }
+    });
+  }
+
+  function fillUserForm(user) {
+    if (!userForm) return;
+    userForm.reset();
+    if (!user) return;
+    userForm.elements.namedItem("username").value = user.username || "";
+    userForm.elements.namedItem("role").value = user.role || "viewer";
+    userForm.elements.namedItem("disabled").checked = !!user.disabled;
+    userForm.elements.namedItem("grants_text").value = formatGrants(user.grants).join("\n");
+  }
+
+  function formatGrants(grants) {
+    if (!Array.isArray(grants)) return [];
+    return grants.map((g) => `${g.project}: ${(g.actions || []).join(",")}`);
+  }
+
+  function renderUsers(users) {
+    if (!usersTbody) return;
+    usersTbody.innerHTML = "";
+    if (!Array.isArray(users) || users.length === 0) {
+      const row = document.createElement("tr");
+      const cell = document.createElement("td");
+      cell.colSpan = 7;
+      cell.className = "px-2 py-2 text-slate-500";
+      cell.textContent = "暂无用户";
+      row.appendChild(cell);
+      usersTbody.appendChild(row);
+      return;
+    }
+    users.forEach((u) => {
+      const row = document.createElement("tr");
+      row.className = "border-b";
+      const values = [
+        u.username,
+        u.sso ? `${u.role}（单点登录）` : u.role,
+        u.disabled ? "已禁用" : "启用",
+        u.totp ? "已启用" : "未启用",
+        formatGrants(u.grants).join("; ") || "全部（按角色）",
+        u.updated_at ? new Date(u.updated_at).toLocaleString() : "-",
+      ];
+      values.forEach((v, idx) => {
+        const cell = document.createElement("td");
+        cell.className = `px-2 py-2${idx === 0 ? " font-mono" : ""}${idx === 2 && u.disabled ? " text-rose-700" : ""}`;
+        cell.textContent = v;
+        row.appendChild(cell);
+      });
+      const actions = document.createElement("td");
+      actions.className = "px-2 py-2 flex gap-2";
+      const editBtn = document.createElement("button");
+      editBtn.type = "button";
+      editBtn.className = "text-xs px-1.5 py-0.5 rounded border border-slate-300 hover:bg-slate-100";
+      editBtn.textContent = "编辑";
+      editBtn.addEventListener("click", () => {
+        fillUserForm(u);
+        setUsersMessage(`正在编辑 ${u.username}，密码留空则不修改`);
+      });
+      const deleteBtn = document.createElement("button");
+      deleteBtn.type = "button";
+      deleteBtn.className = "text-xs px-1.5 py-0.5 rounded border border-rose-300 text-rose-700 hover:bg-rose-50";
+      deleteBtn.textContent = "删除";
+      deleteBtn.addEventListener("click", () => deleteUser(u.username));
+      actions.appendChild(editBtn);
+      actions.appendChild(deleteBtn);
+      row.appendChild(actions);
+      usersTbody.appendChild(row);
+    });
+  }
+
+  async function loadUsers() {
+    try {
+      const res = await fetch("/api/users", { credentials: "same-origin" });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setUsersMessage(payload.error || `读取用户失败 (${res.status})`);
+        return;
+      }
+      renderUsers(payload.users || []);
+    } catch (_e) {
+      setUsersMessage("读取用户失败");
+    }
+  }
+
+  async function deleteUser(username) {
+    if (!username) return;
+    if (!window.confirm(`确认删除用户 ${username} 吗？其会话将立即失效。`)) {
+      return;
+    }
+    setUsersMessage("删除中...");
+    try {
+      const res = await fetch(`/api/users/${encodeURIComponent(username)}`, {
+        method: "DELETE",
+        headers: { "X-CSRF-Token": csrfToken },
+        credentials: "same-origin",
+      });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setUsersMessage(payload.error || `删除失败 (${res.status})`);
+        return;
+      }
+      setUsersMessage(payload.message || "删除成功");
+      renderUsers(payload.users || []);
+    } catch (_e) {
+      setUsersMessage("删除失败");
+    }
+  }
+
+  if (openUsersBtn && usersDialog) {
+    openUsersBtn.addEventListener("click", async () => {
+      setUsersMessage("");
+      fillUserForm(null);
+      openDialog(usersDialog);
+      await loadUsers();
+    });
+  }
+  bindDialogClose(usersDialog, usersClose);
+
+  if (userFormReset) {
+    userFormReset.addEventListener("click", () => {
+      fillUserForm(null);
+      setUsersMessage("");
+    });
+  }
+
+  if (userForm) {
+    userForm.addEventListener("submit", async (e) => {
+      e.preventDefault();
+      const formData = new FormData(userForm);
+      formData.set("disabled", userForm.elements.namedItem("disabled").checked ? "true" : "false");
+      formData.set("reset_totp", userForm.elements.namedItem("reset_totp").checked ? "true" : "false");
+      setUsersMessage("保存中...");
+      try {
+        const res = await fetch("/api/users", {
+          method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
+          body: formData,
+          credentials: "same-origin",
+        });
+        const payload = await res.json().catch(() => ({}));
+        if (!res.ok) {
+          setUsersMessage(payload.error || `保存失败 (${res.status})`);
+          return;
+        }
+        setUsersMessage(payload.message || "保存成功");
+        fillUserForm(null);
+        renderUsers(payload.users || []);
+      } catch (_e) {
+        setUsersMessage("保存失败");
+      }
+    });
+  }
+
+  function setTokensMessage(text) {
+    setText(tokensMessage, text);
+  }
+
+  function formatMaybeTime(value) {
+    return value ? new Date(value).toLocaleString() : "-";
+  }
+
+  function showCreatedToken(token) {
+    if (!tokenCreatedValue) return;
+    tokenCreatedValue.value = token || "";
+    tokenCreatedValue.hidden = !token;
+    if (token) tokenCreatedValue.select();
+  }
+
+  function renderTokens(tokens) {
+    if (!tokensTbody) return;
+    tokensTbody.innerHTML = "";
+    if (!Array.isArray(tokens) || tokens.length === 0) {
+      const row = document.createElement("tr");
+      const cell = document.createElement("td");
+      cell.colSpan = 8;
+      cell.className = "px-2 py-2 text-slate-500";
+      cell.textContent = "暂无令牌";
+      row.appendChild(cell);
+      tokensTbody.appendChild(row);
+      return;
+    }
+    tokens.forEach((t) => {
+      const row = document.createElement("tr");
+      row.className = "border-b align-top";
+      const values = [
+        t.name,
+        `${t.hint || ""}…`,
+        (t.projects || []).join(", "),
+        (t.actions || []).join(", "),
+        `${formatMaybeTime(t.created_at)} / ${t.created_by || "-"}`,
+        t.expires_at ? formatMaybeTime(t.expires_at) : "永不",
+        t.last_used_at ? `${formatMaybeTime(t.last_used_at)} / ${t.last_used_ip || "-"}` : "未使用",
+      ];
+      values.forEach((v, idx) => {
+        const cell = document.createElement("td");
+        cell.className = `px-2 py-2${idx === 1 || idx === 2 ? " font-mono" : ""}`;
+        cell.textContent = v;
+        row.appendChild(cell);
+      });
+      const actions = document.createElement("td");
+      actions.className = "px-2 py-2";
+      if (t.revoked) {
+        actions.className += " text-rose-700";
+        actions.textContent = `已吊销 ${formatMaybeTime(t.revoked_at)}`;
+      } else {
+        const revokeBtn = document.createElement("button");
+        revokeBtn.type = "button";
+        revokeBtn.className = "text-xs px-1.5 py-0.5 rounded border border-rose-300 text-rose-700 hover:bg-rose-50";
+        revokeBtn.textContent = "吊销";
+        revokeBtn.addEventListener("click", () => revokeToken(t));
+        actions.appendChild(revokeBtn);
+      }
+      row.appendChild(actions);
+      tokensTbody.appendChild(row);
+    });
+  }
+
+  async function loadTokens() {
+    try {
+      const res = await fetch("/api/tokens", { credentials: "same-origin" });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setTokensMessage(payload.error || `读取令牌失败 (${res.status})`);
+        return;
+      }
+      renderTokens(payload.tokens || []);
+    } catch (_e) {
+      setTokensMessage("读取令牌失败");
+    }
+  }
+
+  async function revokeToken(token) {
+    if (!window.confirm(`确认吊销令牌 ${token.name} 吗？使用该令牌的流水线将立即失效。`)) {
+      return;
+    }
+    setTokensMessage("吊销中...");
+    try {
+      const res = await fetch(`/api/tokens/${encodeURIComponent(token.id)}`, {
+        method: "DELETE",
+        headers: { "X-CSRF-Token": csrfToken },
+        credentials: "same-origin",
+      });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setTokensMessage(payload.error || `吊销失败 (${res.status})`);
+        return;
+      }
+      setTokensMessage(payload.message || "已吊销");
+      renderTokens(payload.tokens || []);
+    } catch (_e) {
+      setTokensMessage("吊销失败");
+    }
+  }
+
+  if (openTokensBtn && tokensDialog) {
+    openTokensBtn.addEventListener("click", async () => {
+      setTokensMessage("");
+      showCreatedToken("");
+      openDialog(tokensDialog);
+      await loadTokens();
+    });
+  }
+  bindDialogClose(tokensDialog, tokensClose);
+
+  if (tokenForm) {
+    tokenForm.addEventListener("submit", async (e) => {
+      e.preventDefault();
+      setTokensMessage("创建中...");
+      showCreatedToken("");
+      try {
+        const res = await fetch("/api/tokens", {
+          method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
+          body: new FormData(tokenForm),
+          credentials: "same-origin",
+        });
+        const payload = await res.json().catch(() => ({}));
+        if (!res.ok) {
+          setTokensMessage(payload.error || `创建失败 (${res.status})`);
+          return;
+        }
+        tokenForm.reset();
+        setTokensMessage(payload.message || "创建成功");
+        showCreatedToken(payload.token || "");
+        renderTokens(payload.tokens || []);
+      } catch (_e) {
+        setTokensMessage("创建失败");
+      }
+    });
+  }
+
+  const auditActionLabels = {
+    "system.update": "修改系统配置",
+    "project.create": "新增程序",
+    "project.update": "修改程序配置",
+    "project.delete": "删除程序",
+    "password.change": "修改密码",
+  };
+  let auditNextOffset = 0;
+
+  function formatAuditChanges(changes) {
+    if (!Array.isArray(changes) || changes.length === 0) return "-";
+    return changes.map((c) => `${c.field}: ${c.old === "" ? "(空)" : c.old} → ${c.new === "" ? "(空)" : c.new}`).join("\n");
+  }
+
+  function appendAuditRows(entries, reset) {
+    if (!auditTbody) return;
+    if (reset) auditTbody.innerHTML = "";
+    if (reset && entries.length === 0) {
+      const row = document.createElement("tr");
+      const cell = document.createElement("td");
+      cell.colSpan = 6;
+      cell.className = "px-2 py-2 text-slate-500";
+      cell.textContent = "暂无审计记录";
+      row.appendChild(cell);
+      auditTbody.appendChild(row);
+      return;
+    }
+    entries.forEach((e) => {
+      const row = document.createElement("tr");
+      row.className = "border-b align-top";
+      const values = [
+        formatMaybeTime(e.time),
+        e.actor || "-",
+        e.ip || "-",
+        auditActionLabels[e.action] || e.action,
+        e.target || "-",
+        formatAuditChanges(e.changes),
+      ];
+      values.forEach((v, idx) => {
+        const cell = document.createElement("td");
+        cell.className = `px-2 py-2${idx === 5 ? " font-mono whitespace-pre-wrap break-all" : ""}`;
+        cell.textContent = v;
+        row.appendChild(cell);
+      });
+      auditTbody.appendChild(row);
+    });
+  }
+
+  async function loadAudit(reset) {
+    if (reset) auditNextOffset = 0;
+    const params = new URLSearchParams({ offset: String(auditNextOffset), limit: "50" });
+    const target = auditFilterForm ? String(new FormData(auditFilterForm).get("target") || "").trim() : "";
+    if (target) params.set("target", target);
+    setText(auditMessage, "读取中...");
+    try {
+      const res = await fetch(`/api/audit?${params.toString()}`, { credentials: "same-origin" });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setText(auditMessage, payload.error || `读取审计日志失败 (${res.status})`);
+        return;
+      }
+      appendAuditRows(payload.entries || [], reset);
+      auditNextOffset = payload.next_offset || 0;
+      if (auditMoreBtn) auditMoreBtn.hidden = !payload.has_more;
+      setText(auditMessage, `共 ${payload.total || 0} 条`);
+    } catch (_e) {
+      setText(auditMessage, "读取审计日志失败");
+    }
+  }
+
+  if (openAuditBtn && auditDialog) {
+    openAuditBtn.addEventListener("click", async () => {
+      openDialog(auditDialog);
+      await loadAudit(true);
+    });
+  }
+  bindDialogClose(auditDialog, auditClose);
+  if (auditMoreBtn) {
+    auditMoreBtn.addEventListener("click", () => loadAudit(false));
+  }
+  if (auditFilterForm) {
+    auditFilterForm.addEventListener("submit", (e) => {
+      e.preventDefault();
+      loadAudit(true);
+    });
+  }
+
+  function renderTotpState(state) {
+    if (!accountTotpForm) return;
+    const enabled = !!state.enabled;
+    let status = enabled ? `已启用，剩余恢复码 ${state.recovery_codes_left || 0} 个` : "未启用";
+    if (state.required) status += "（系统强制）";
+    setText(totpStatus, status);
+    const pending = !enabled && !!state.secret;
+    if (totpSetup) totpSetup.hidden = !pending;
+    if (pending) {
+      setText(totpSecret, state.secret);
+      setText(totpUri, state.otpauth_uri || "");
+    }
+    if (totpPasswordWrap) totpPasswordWrap.hidden = !enabled || !!state.required;
+    const visibleActions = {
+      begin: !enabled,
+      enable: pending,
+      recovery_codes: enabled,
+      disable: enabled && !state.required,
+    };
+    accountTotpForm.querySelectorAll("[data-totp-action]").forEach((btn) => {
+      btn.hidden = !visibleActions[btn.dataset.totpAction];
+    });
+    if (totpRecovery) {
+      const codes = Array.isArray(state.recovery_codes) ? state.recovery_codes : [];
+      totpRecovery.innerHTML = "";
+      totpRecovery.hidden = codes.length === 0;
+      if (codes.length > 0) {
+        const tip = document.createElement("div");
+        tip.className = "font-sans text-xs text-amber-700";
+        tip.textContent = "以下恢复码每个只能使用一次，关闭窗口后无法再次查看，请立即保存：";
+        totpRecovery.appendChild(tip);
+        codes.forEach((code) => {
+          const row = document.createElement("div");
+          row.textContent = code;
+          totpRecovery.appendChild(row);
+        });
+      }
+    }
+  }
+
+  async function loadTotpState() {
+    if (!accountTotpForm) return;
+    try {
+      const res = await fetch("/api/account/totp", { credentials: "same-origin" });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setText(totpMessage, payload.error || `读取两步验证状态失败 (${res.status})`);
+        return;
+      }
+      renderTotpState(payload);
+    } catch (_e) {
+      setText(totpMessage, "读取两步验证状态失败");
+    }
+  }
+
+  if (accountTotpForm) {
+    accountTotpForm.addEventListener("submit", (e) => e.preventDefault());
+    accountTotpForm.querySelectorAll("[data-totp-action]").forEach((btn) => {
+      btn.addEventListener("click", async () => {
+        const action = btn.dataset.totpAction;
+        if (action === "disable" && !window.confirm("确认停用两步验证吗？")) {
+          return;
+        }
+        const formData = new FormData(accountTotpForm);
+        formData.set("action", action);
+        setText(totpMessage, "处理中...");
+        try {
+          const res = await fetch("/api/account/totp", {
+            method: "POST",
+            headers: { "X-CSRF-Token": csrfToken },
+            body: formData,
+            credentials: "same-origin",
+          });
+          const payload = await res.json().catch(() => ({}));
+          if (!res.ok) {
+            setText(totpMessage, payload.error || `操作失败 (${res.status})`);
+            return;
+          }
+          accountTotpForm.reset();
+          setText(totpMessage, payload.message || "操作成功");
+          renderTotpState(payload);
+        } catch (_e) {
+          setText(totpMessage, "操作失败");
+        }
+      });
+    });
+  }
+
+  function sessionsScope() {
+    return sessionsScopeAll && sessionsScopeAll.checked ? "all" : "mine";
+  }
+
+  function renderSessions(list) {
+    if (!sessionsTbody) return;
+    sessionsTbody.innerHTML = "";
+    if (!Array.isArray(list) || list.length === 0) {
+      const row = document.createElement("tr");
+      const cell = document.createElement("td");
+      cell.colSpan = 6;
+      cell.className = "px-2 py-2 text-slate-500";
+      cell.textContent = "暂无会话";
+      row.appendChild(cell);
+      sessionsTbody.appendChild(row);
+      return;
+    }
+    list.forEach((item) => {
+      const row = document.createElement("tr");
+      row.className = "border-b align-top";
+      const values = [
+        item.user,
+        item.ip || "-",
+        item.user_agent || "-",
+        formatMaybeTime(item.created_at),
+        formatMaybeTime(item.last_seen),
+      ];
+      values.forEach((v, idx) => {
+        const cell = document.createElement("td");
+        cell.className = `px-2 py-2${idx === 2 ? " break-all text-slate-500" : ""}`;
+        cell.textContent = v;
+        row.appendChild(cell);
+      });
+      const actions = document.createElement("td");
+      actions.className = "px-2 py-2";
+      if (item.current) {
+        const tag = document.createElement("span");
+        tag.className = "text-emerald-700";
+        tag.textContent = "当前会话";
+        actions.appendChild(tag);
+      } else {
+        const btn = document.createElement("button");
+        btn.type = "button";
+        btn.className = "text-xs px-1.5 py-0.5 rounded border border-rose-300 text-rose-700 hover:bg-rose-50";
+        btn.textContent = "注销";
+        btn.addEventListener("click", () => revokeSession(item.id));
+        actions.appendChild(btn);
+      }
+      row.appendChild(actions);
+      sessionsTbody.appendChild(row);
+    });
+  }
+
+  async function loadSessions() {
+    if (!sessionsTbody) return;
+    try {
+      const res = await fetch(`/api/sessions?scope=${sessionsScope()}`, { credentials: "same-origin" });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setText(sessionsMessage, payload.error || `读取会话失败 (${res.status})`);
+        return;
+      }
+      renderSessions(payload.sessions || []);
+    } catch (_e) {
+      setText(sessionsMessage, "读取会话失败");
+    }
+  }
+
+  async function revokeSession(id) {
+    setText(sessionsMessage, "注销中...");
+    try {
+      const res = await fetch(`/api/sessions/${encodeURIComponent(id)}`, {
+        method: "DELETE",
+        headers: { "X-CSRF-Token": csrfToken },
+        credentials: "same-origin",
+      });
+      const payload = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        setText(sessionsMessage, payload.error || `注销失败 (${res.status})`);
+        return;
+      }
+      setText(sessionsMessage, payload.message || "已注销");
+      await loadSessions();
+    } catch (_e) {
+      setText(sessionsMessage, "注销失败");
+    }
+  }
+
+  if (sessionsScopeAll) {
+    sessionsScopeAll.addEventListener("change", () => loadSessions());
+  }
+
+  if (sessionsRevokeAll) {
+    sessionsRevokeAll.addEventListener("click", async () => {
+      const scope = sessionsScope();
+      const tip = scope === "all" ? "确认注销全部用户的其他会话吗？" : "确认注销你在其他设备上的会话吗？";
+      if (!window.confirm(tip)) return;
+      const formData = new FormData();
+      formData.set("scope", scope);
+      setText(sessionsMessage, "注销中...");
+      try {
+        const res = await fetch("/api/sessions/revoke-all", {
+          method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
+          body: formData,
+          credentials: "same-origin",
+        });
+        const payload = await res.json().catch(() => ({}));
+        if (!res.ok) {
+          setText(sessionsMessage, payload.error || `注销失败 (${res.status})`);
+          return;
+        }
+        setText(sessionsMessage, payload.message || "已注销");
+        await loadSessions();
+      } catch (_e) {
+        setText(sessionsMessage, "注销失败");
+      }
+    });
+  }
+
+  if (openAccountBtn && accountDialog) {
+    openAccountBtn.addEventListener("click", async () => {
+      setAccountMessage("");
+      setText(totpMessage, "");
+      setText(sessionsMessage, "");
+      if (accountPasswordForm) accountPasswordForm.reset();
+      openDialog(accountDialog);
+      await Promise.all([loadTotpState(), loadSessions()]);
+    });
+  }
+  bindDialogClose(accountDialog, accountClose);
+
+  if (accountPasswordForm) {
+    accountPasswordForm.addEventListener("submit", async (e) => {
+      e.preventDefault();
+      setAccountMessage("保存中...");
+      try {
+        const res = await fetch("/api/account/password", {
+          method: "POST",
+          headers: { "X-CSRF-Token": csrfToken },
+          body: new FormData(accountPasswordForm),
+          credentials: "same-origin",
+        });
+        const payload = await res.json().catch(() => ({}));
+        if (!res.ok) {
+          setAccountMessage(payload.error || `修改失败 (${res.status})`);
+          return;
+        }
+        setAccountMessage(payload.message || "密码已更新");
+        accountPasswordForm.reset();
+      } catch (_e) {
+        setAccountMessage("修改失败");
+      }
+    });
+  }
+
   syncProjectNavigation(getQueryProjectId() || getStoredProjectId());
   loadConfig(getQueryProjectId() || getStoredProjectId());
 })();